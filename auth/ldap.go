@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const defaultLDAPTimeout = 10
+
+// LDAPSettings 为 LDAP / Active Directory 认证源的配置。
+// UserFilter 中的 %s 会被替换为转义后的用户名，例如 (&(objectClass=user)(sAMAccountName=%s))。
+type LDAPSettings struct {
+	URL                string `json:"url"`
+	StartTLS           bool   `json:"start_tls"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+	CACertFile         string `json:"ca_cert_file"`
+	BindDN             string `json:"bind_dn"`
+	BindPassword       string `json:"bind_password"`
+	BaseDN             string `json:"base_dn"`
+	UserFilter         string `json:"user_filter"`
+	GroupAttribute     string `json:"group_attribute"`
+	NameAttribute      string `json:"name_attribute"`
+	EmailAttribute     string `json:"email_attribute"`
+	Timeout            int    `json:"timeout"`
+}
+
+type ldapProvider struct {
+	settings LDAPSettings
+}
+
+func newLDAPProvider(raw json.RawMessage) (*ldapProvider, error) {
+	var s LDAPSettings
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, fmt.Errorf("LDAP 配置格式错误: %v", err)
+		}
+	}
+
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+		return nil, fmt.Errorf("LDAP 地址格式错误，应为 ldap://host:389 或 ldaps://host:636")
+	}
+	if s.StartTLS && u.Scheme == "ldaps" {
+		return nil, fmt.Errorf("ldaps 地址不能同时启用 StartTLS")
+	}
+	if s.BaseDN == "" {
+		return nil, fmt.Errorf("Base DN 不能为空")
+	}
+	if s.UserFilter == "" {
+		s.UserFilter = "(uid=%s)"
+	}
+	if strings.Count(s.UserFilter, "%s") != 1 {
+		return nil, fmt.Errorf("用户过滤器必须包含一个 %%s 作为用户名占位符")
+	}
+	if s.GroupAttribute == "" {
+		s.GroupAttribute = "memberOf"
+	}
+	if s.NameAttribute == "" {
+		s.NameAttribute = "displayName"
+	}
+	if s.EmailAttribute == "" {
+		s.EmailAttribute = "mail"
+	}
+	if s.Timeout <= 0 {
+		s.Timeout = defaultLDAPTimeout
+	}
+	return &ldapProvider{settings: s}, nil
+}
+
+func (p *ldapProvider) tlsConfig() (*tls.Config, error) {
+	u, _ := url.Parse(p.settings.URL)
+	config := &tls.Config{
+		ServerName:         u.Hostname(),
+		InsecureSkipVerify: p.settings.InsecureSkipVerify,
+	}
+	if p.settings.CACertFile != "" {
+		pem, err := os.ReadFile(p.settings.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取 CA 证书失败: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA 证书格式错误: %s", p.settings.CACertFile)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// connect 建立连接并以服务账号绑定，未配置 Bind DN 时使用匿名查询
+func (p *ldapProvider) connect() (*ldap.Conn, error) {
+	tlsConfig, err := p.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := time.Duration(p.settings.Timeout) * time.Second
+	conn, err := ldap.DialURL(p.settings.URL, ldap.DialWithTLSConfig(tlsConfig), ldap.DialWithDialer(&net.Dialer{Timeout: timeout}))
+	if err != nil {
+		return nil, fmt.Errorf("连接 LDAP 服务器失败: %v", err)
+	}
+	conn.SetTimeout(timeout)
+
+	if p.settings.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("StartTLS 失败: %v", err)
+		}
+	}
+
+	if p.settings.BindDN != "" {
+		err = conn.Bind(p.settings.BindDN, p.settings.BindPassword)
+	} else {
+		err = conn.UnauthenticatedBind("")
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("服务账号绑定失败: %v", err)
+	}
+	return conn, nil
+}
+
+func (p *ldapProvider) Test() error {
+	conn, err := p.connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Search(ldap.NewSearchRequest(
+		p.settings.BaseDN, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, p.settings.Timeout, false,
+		"(objectClass=*)", []string{"dn"}, nil,
+	))
+	if err != nil {
+		return fmt.Errorf("查询 Base DN 失败: %v", err)
+	}
+	return nil
+}
+
+func (p *ldapProvider) Authenticate(username, password string) (*Identity, error) {
+	// 空密码会被服务器当作匿名绑定而成功，必须拒绝
+	if password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := p.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		p.settings.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, p.settings.Timeout, false,
+		fmt.Sprintf(p.settings.UserFilter, ldap.EscapeFilter(username)),
+		[]string{"dn", p.settings.GroupAttribute, p.settings.NameAttribute, p.settings.EmailAttribute}, nil,
+	))
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) || (err == nil && len(result.Entries) > 1) {
+		return nil, fmt.Errorf("用户过滤器匹配到多个条目，请检查配置")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询用户失败: %v", err)
+	}
+	if len(result.Entries) == 0 {
+		return nil, ErrUserNotFound
+	}
+
+	entry := result.Entries[0]
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("用户绑定失败: %v", err)
+	}
+
+	// 目录通常不区分用户名大小写，以 DN 识别账号，Alice 和 alice 对应同一条目
+	return &Identity{
+		Username:   username,
+		FullName:   entry.GetAttributeValue(p.settings.NameAttribute),
+		Email:      entry.GetAttributeValue(p.settings.EmailAttribute),
+		Groups:     entry.GetAttributeValues(p.settings.GroupAttribute),
+		ExternalID: strings.ToLower(entry.DN),
+	}, nil
+}
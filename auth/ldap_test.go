@@ -0,0 +1,381 @@
+package auth
+
+import (
+	"edge_server/models"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	testBaseDN       = "dc=example,dc=com"
+	testBindDN       = "cn=svc,ou=system,dc=example,dc=com"
+	testBindPassword = "svc-secret"
+)
+
+type ldapEntry struct {
+	DN       string
+	Password string
+	Attrs    map[string][]string
+}
+
+// ldapTestServer 为进程内的最小 LDAP 服务器，支持简单绑定和 and/or/等值/存在过滤器的查询，并记录收到的操作
+type ldapTestServer struct {
+	listener net.Listener
+	entries  []ldapEntry
+
+	mu  sync.Mutex
+	ops []string
+}
+
+func newLDAPTestServer(t *testing.T, entries []ldapEntry) *ldapTestServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := &ldapTestServer{listener: listener, entries: entries}
+	t.Cleanup(func() { listener.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *ldapTestServer) URL() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *ldapTestServer) record(op string) {
+	s.mu.Lock()
+	s.ops = append(s.ops, op)
+	s.mu.Unlock()
+}
+
+func (s *ldapTestServer) Ops() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.ops...)
+}
+
+func (s *ldapTestServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *ldapTestServer) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn, password := op.Children[1].Data.String(), op.Children[2].Data.String()
+			s.record("bind " + dn)
+			conn.Write(ldapResponse(id, ldap.ApplicationBindResponse, s.bind(dn, password)).Bytes())
+		case ldap.ApplicationSearchRequest:
+			s.search(conn, id, op)
+		case ldap.ApplicationUnbindRequest:
+			return
+		}
+	}
+}
+
+func (s *ldapTestServer) bind(dn, password string) int64 {
+	if dn == testBindDN && password == testBindPassword {
+		return ldap.LDAPResultSuccess
+	}
+	for _, e := range s.entries {
+		if strings.EqualFold(e.DN, dn) && e.Password == password {
+			return ldap.LDAPResultSuccess
+		}
+	}
+	return ldap.LDAPResultInvalidCredentials
+}
+
+func (s *ldapTestServer) search(conn net.Conn, id int64, op *ber.Packet) {
+	filter, _ := ldap.DecompileFilter(op.Children[6])
+	s.record("search " + filter)
+
+	sizeLimit := op.Children[3].Value.(int64)
+	var sent int64
+	for _, e := range s.entries {
+		if !matchFilter(op.Children[6], e) {
+			continue
+		}
+		if sizeLimit > 0 && sent >= sizeLimit {
+			conn.Write(ldapResponse(id, ldap.ApplicationSearchResultDone, ldap.LDAPResultSizeLimitExceeded).Bytes())
+			return
+		}
+		conn.Write(ldapSearchEntry(id, e).Bytes())
+		sent++
+	}
+	conn.Write(ldapResponse(id, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess).Bytes())
+}
+
+func matchFilter(f *ber.Packet, e ldapEntry) bool {
+	switch f.Tag {
+	case ldap.FilterAnd:
+		for _, child := range f.Children {
+			if !matchFilter(child, e) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, child := range f.Children {
+			if matchFilter(child, e) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterEqualityMatch:
+		for _, v := range attrValues(e, f.Children[0].Data.String()) {
+			if strings.EqualFold(v, f.Children[1].Data.String()) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterPresent:
+		return len(attrValues(e, f.Data.String())) > 0
+	}
+	return false
+}
+
+func attrValues(e ldapEntry, name string) []string {
+	for key, values := range e.Attrs {
+		if strings.EqualFold(key, name) {
+			return values
+		}
+	}
+	return nil
+}
+
+func ldapResponse(id int64, tag ber.Tag, code int64) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, ""))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	packet.AppendChild(response)
+	return packet
+}
+
+func ldapSearchEntry(id int64, e ldapEntry) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+	entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.DN, ""))
+	attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	for name, values := range e.Attrs {
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+		for _, v := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, ""))
+		}
+		attr.AppendChild(set)
+		attrs.AppendChild(attr)
+	}
+	entry.AppendChild(attrs)
+	packet.AppendChild(entry)
+	return packet
+}
+
+var testLDAPEntries = []ldapEntry{
+	{
+		DN:       "uid=alice,ou=people,dc=example,dc=com",
+		Password: "alice-pass",
+		Attrs: map[string][]string{
+			"uid":         {"alice"},
+			"team":        {"ops"},
+			"displayName": {"Alice Liu"},
+			"mail":        {"alice@example.com"},
+			"memberOf":    {"cn=vpn-users,ou=groups,dc=example,dc=com", "cn=Admins,ou=groups,dc=example,dc=com"},
+		},
+	},
+	{
+		DN:       "uid=bob,ou=people,dc=example,dc=com",
+		Password: "bob-pass",
+		Attrs:    map[string][]string{"uid": {"bob"}, "team": {"ops"}},
+	},
+	{
+		DN:       "uid=carol,ou=people,dc=example,dc=com",
+		Password: "carol-pass",
+		Attrs:    map[string][]string{"uid": {"carol"}, "team": {"ops"}},
+	},
+}
+
+func newTestLDAPProvider(t *testing.T, server *ldapTestServer, settings map[string]interface{}) *ldapProvider {
+	t.Helper()
+	s := map[string]interface{}{
+		"url":           server.URL(),
+		"bind_dn":       testBindDN,
+		"bind_password": testBindPassword,
+		"base_dn":       testBaseDN,
+		"timeout":       2,
+	}
+	for key, value := range settings {
+		s[key] = value
+	}
+	raw, _ := json.Marshal(s)
+	p, err := newLDAPProvider(raw)
+	if err != nil {
+		t.Fatalf("newLDAPProvider: %v", err)
+	}
+	return p
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	server := newLDAPTestServer(t, testLDAPEntries)
+	p := newTestLDAPProvider(t, server, nil)
+
+	identity, err := p.Authenticate("alice", "alice-pass")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if identity.Username != "alice" || identity.FullName != "Alice Liu" || identity.Email != "alice@example.com" || len(identity.Groups) != 2 ||
+		identity.ExternalID != "uid=alice,ou=people,dc=example,dc=com" {
+		t.Errorf("identity = %+v", identity)
+	}
+
+	want := []string{
+		"bind " + testBindDN,
+		"search (uid=alice)",
+		"bind uid=alice,ou=people,dc=example,dc=com",
+	}
+	if got := server.Ops(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("operations:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestLDAPExternalIDIgnoresCase(t *testing.T) {
+	server := newLDAPTestServer(t, testLDAPEntries)
+	p := newTestLDAPProvider(t, server, nil)
+
+	lower, err := p.Authenticate("alice", "alice-pass")
+	if err != nil {
+		t.Fatalf("Authenticate(alice): %v", err)
+	}
+	upper, err := p.Authenticate("Alice", "alice-pass")
+	if err != nil {
+		t.Fatalf("Authenticate(Alice): %v", err)
+	}
+	if upper.Username != "Alice" || upper.ExternalID != lower.ExternalID {
+		t.Errorf("Alice = %+v, alice = %+v", upper, lower)
+	}
+}
+
+func TestLDAPAuthenticateErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		username string
+		password string
+		wantErr  error
+		wantMsg  string
+	}{
+		{name: "密码错误", username: "alice", password: "wrong", wantErr: ErrInvalidCredentials},
+		{name: "用户不存在", username: "nobody", password: "x", wantErr: ErrUserNotFound},
+		{name: "空密码", username: "alice", password: "", wantErr: ErrInvalidCredentials},
+		{name: "过滤器注入", username: "*)(uid=*", password: "alice-pass", wantErr: ErrUserNotFound},
+		{name: "匹配两个条目", settings: map[string]interface{}{"user_filter": "(|(uid=%s)(uid=bob))"}, username: "alice", password: "alice-pass", wantMsg: "匹配到多个条目"},
+		{name: "超过条目上限", settings: map[string]interface{}{"user_filter": "(|(uid=%s)(team=ops))"}, username: "alice", password: "alice-pass", wantMsg: "匹配到多个条目"},
+		{name: "服务账号密码错误", settings: map[string]interface{}{"bind_password": "wrong"}, username: "alice", password: "alice-pass", wantMsg: "服务账号绑定失败"},
+	}
+
+	server := newLDAPTestServer(t, testLDAPEntries)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestLDAPProvider(t, server, tt.settings)
+			_, err := p.Authenticate(tt.username, tt.password)
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantMsg != "" && (err == nil || !strings.Contains(err.Error(), tt.wantMsg)) {
+				t.Fatalf("error = %v, want %q", err, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestLDAPEscapesUsername(t *testing.T) {
+	server := newLDAPTestServer(t, testLDAPEntries)
+	p := newTestLDAPProvider(t, server, nil)
+
+	if _, err := p.Authenticate("*)(uid=*", "alice-pass"); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("error = %v, want ErrUserNotFound", err)
+	}
+	ops := server.Ops()
+	if len(ops) != 2 || ops[1] != `search (uid=\2a\29\28uid=\2a)` {
+		t.Errorf("operations = %q", ops)
+	}
+}
+
+func TestLDAPEmptyPasswordSkipsServer(t *testing.T) {
+	server := newLDAPTestServer(t, testLDAPEntries)
+	p := newTestLDAPProvider(t, server, nil)
+
+	if _, err := p.Authenticate("alice", ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("error = %v, want ErrInvalidCredentials", err)
+	}
+	if ops := server.Ops(); len(ops) != 0 {
+		t.Errorf("empty password reached the server: %q", ops)
+	}
+}
+
+func TestMapIdentity(t *testing.T) {
+	provider := &models.AuthProvider{
+		Type: ProviderLDAP,
+		GroupMappings: []models.GroupMapping{
+			{Group: "cn=vpn-users,ou=groups,dc=example,dc=com", UserGroup: "员工"},
+			{Group: "admins", Role: models.RoleSuperAdmin},
+			{Group: "cn=contractors,ou=groups,dc=example,dc=com", UserGroup: "外包"},
+			{Group: "vpn-users", UserGroup: "不应生效", Role: models.RoleAuditor},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		groups    []string
+		userGroup string
+		role      string
+	}{
+		{
+			name:      "完整 DN 和 CN 映射",
+			groups:    []string{"cn=vpn-users,ou=groups,dc=example,dc=com", "CN=Admins,OU=groups,DC=example,DC=com"},
+			userGroup: "员工",
+			role:      models.RoleSuperAdmin,
+		},
+		{
+			name:      "CN 映射匹配完整 DN",
+			groups:    []string{"cn=vpn-users,ou=other,dc=example,dc=com"},
+			userGroup: "不应生效",
+			role:      models.RoleAuditor,
+		},
+		{name: "RADIUS Class 等非 DN 组名", groups: []string{"Admins"}, role: models.RoleSuperAdmin},
+		{name: "无匹配", groups: []string{"cn=guests,ou=groups,dc=example,dc=com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := MapIdentity(provider, &Identity{Username: "alice", Groups: tt.groups})
+			if result.UserGroup != tt.userGroup || result.Role != tt.role {
+				t.Errorf("got (%q, %q), want (%q, %q)", result.UserGroup, result.Role, tt.userGroup, tt.role)
+			}
+		})
+	}
+}
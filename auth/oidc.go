@@ -0,0 +1,240 @@
+package auth
+
+import (
+	"context"
+	"edge_server/models"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+const defaultOIDCTimeout = 10 * time.Second
+
+// OIDCSettings 为 OpenID Connect 认证源的配置，只用于控制台单点登录。
+// ClientSecret 可留空，此时作为公开客户端仅依靠 PKCE；GroupsClaim 支持用 . 访问嵌套声明，例如 realm_access.roles。
+type OIDCSettings struct {
+	Issuer        string   `json:"issuer"`
+	ClientID      string   `json:"client_id"`
+	ClientSecret  string   `json:"client_secret"`
+	RedirectURL   string   `json:"redirect_url"`
+	Scopes        []string `json:"scopes"`
+	UsernameClaim string   `json:"username_claim"`
+	GroupsClaim   string   `json:"groups_claim"`
+	NameClaim     string   `json:"name_claim"`
+	EmailClaim    string   `json:"email_claim"`
+}
+
+type oidcProvider struct {
+	settings OIDCSettings
+}
+
+var (
+	oidcDiscoveryMu sync.Mutex
+	oidcDiscovery   = make(map[string]*oidc.Provider)
+)
+
+func newOIDCProvider(raw json.RawMessage) (*oidcProvider, error) {
+	var s OIDCSettings
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, fmt.Errorf("OIDC 配置格式错误: %v", err)
+		}
+	}
+
+	u, err := url.Parse(s.Issuer)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("Issuer 地址格式错误，应为 https://idp.example.com/realms/xxx")
+	}
+	if s.ClientID == "" {
+		return nil, fmt.Errorf("Client ID 不能为空")
+	}
+	// 回调地址不能按请求头生成，否则伪造的 Host 会让授权码被发送到其他站点
+	if s.RedirectURL == "" {
+		return nil, fmt.Errorf("回调地址不能为空，应为 https://<控制台地址>/api/auth/oidc/callback")
+	}
+	if u, err := url.Parse(s.RedirectURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("回调地址格式错误: %s", s.RedirectURL)
+	}
+	if len(s.Scopes) == 0 {
+		s.Scopes = []string{"profile", "email"}
+	}
+	if s.UsernameClaim == "" {
+		s.UsernameClaim = "preferred_username"
+	}
+	if s.GroupsClaim == "" {
+		s.GroupsClaim = "groups"
+	}
+	if s.NameClaim == "" {
+		s.NameClaim = "name"
+	}
+	if s.EmailClaim == "" {
+		s.EmailClaim = "email"
+	}
+	return &oidcProvider{settings: s}, nil
+}
+
+// discover 获取 IdP 的端点配置，按 Issuer 缓存；签名密钥由 go-oidc 在遇到未知 kid 时自动刷新
+func (p *oidcProvider) discover(ctx context.Context, cached bool) (*oidc.Provider, error) {
+	oidcDiscoveryMu.Lock()
+	defer oidcDiscoveryMu.Unlock()
+
+	if provider, exists := oidcDiscovery[p.settings.Issuer]; exists && cached {
+		return provider, nil
+	}
+	provider, err := oidc.NewProvider(ctx, p.settings.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("获取 OIDC 配置失败: %v", err)
+	}
+	oidcDiscovery[p.settings.Issuer] = provider
+	return provider, nil
+}
+
+func (p *oidcProvider) oauth2Config(provider *oidc.Provider) *oauth2.Config {
+	scopes := []string{oidc.ScopeOpenID}
+	for _, scope := range p.settings.Scopes {
+		if scope != oidc.ScopeOpenID {
+			scopes = append(scopes, scope)
+		}
+	}
+	return &oauth2.Config{
+		ClientID:     p.settings.ClientID,
+		ClientSecret: p.settings.ClientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  p.settings.RedirectURL,
+		Scopes:       scopes,
+	}
+}
+
+func (p *oidcProvider) Test() error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultOIDCTimeout)
+	defer cancel()
+	_, err := p.discover(ctx, false)
+	return err
+}
+
+// Authenticate OIDC 不支持用户名密码认证，返回 ErrUserNotFound 使密码登录继续尝试其他认证源
+func (p *oidcProvider) Authenticate(username, password string) (*Identity, error) {
+	return nil, ErrUserNotFound
+}
+
+// OIDCAuthCodeURL 返回跳转到 IdP 的授权地址，使用 PKCE (S256) 和 nonce
+func OIDCAuthCodeURL(p *models.AuthProvider, state, nonce, verifier string) (string, error) {
+	impl, err := newOIDCProvider(p.Settings)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultOIDCTimeout)
+	defer cancel()
+	provider, err := impl.discover(ctx, true)
+	if err != nil {
+		return "", err
+	}
+	return impl.oauth2Config(provider).AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), nil
+}
+
+// OIDCExchange 用授权码换取并校验 ID Token，按声明确定用户名和组，返回组映射结果。
+// 用户名声明可由用户在 IdP 中修改，账号以 iss 和 sub 作为 ExternalID 识别。
+func OIDCExchange(p *models.AuthProvider, code, nonce, verifier string) (*Result, error) {
+	impl, err := newOIDCProvider(p.Settings)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultOIDCTimeout)
+	defer cancel()
+	provider, err := impl.discover(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	config := impl.oauth2Config(provider)
+	token, err := config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("授权码换取令牌失败: %v", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, fmt.Errorf("IdP 未返回 ID Token")
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: impl.settings.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("ID Token 校验失败: %v", err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, fmt.Errorf("ID Token 的 nonce 不匹配")
+	}
+
+	claims := make(map[string]interface{})
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("解析 ID Token 失败: %v", err)
+	}
+	// 部分 IdP 只在 UserInfo 中返回用户名或组，ID Token 中缺少时补充
+	if claimValue(claims, impl.settings.UsernameClaim) == nil || claimValue(claims, impl.settings.GroupsClaim) == nil {
+		if info, err := provider.UserInfo(ctx, oauth2.StaticTokenSource(token)); err == nil {
+			extra := make(map[string]interface{})
+			if info.Claims(&extra) == nil && extra["sub"] == claims["sub"] {
+				for key, value := range extra {
+					if _, exists := claims[key]; !exists {
+						claims[key] = value
+					}
+				}
+			}
+		}
+	}
+
+	identity := &Identity{
+		Username:   claimString(claims, impl.settings.UsernameClaim),
+		FullName:   claimString(claims, impl.settings.NameClaim),
+		Email:      claimString(claims, impl.settings.EmailClaim),
+		Groups:     claimStrings(claims, impl.settings.GroupsClaim),
+		ExternalID: idToken.Issuer + "#" + idToken.Subject,
+	}
+	if identity.Username == "" {
+		return nil, fmt.Errorf("ID Token 中缺少用户名声明 %s", impl.settings.UsernameClaim)
+	}
+	return MapIdentity(p, identity), nil
+}
+
+// claimValue 按 . 分隔的路径读取声明
+func claimValue(claims map[string]interface{}, path string) interface{} {
+	var value interface{} = claims
+	for _, key := range strings.Split(path, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		if value, ok = m[key]; !ok {
+			return nil
+		}
+	}
+	return value
+}
+
+func claimString(claims map[string]interface{}, path string) string {
+	if s, ok := claimValue(claims, path).(string); ok {
+		return s
+	}
+	return ""
+}
+
+// claimStrings 读取字符串或字符串数组形式的声明
+func claimStrings(claims map[string]interface{}, path string) []string {
+	switch v := claimValue(claims, path).(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
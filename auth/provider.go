@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"edge_server/models"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	ProviderLDAP   = "ldap"
+	ProviderRADIUS = "radius"
+	ProviderOIDC   = "oidc"
+
+	ScopeVPN     = "vpn"
+	ScopeConsole = "console"
+)
+
+var (
+	// ErrUserNotFound 表示认证源中不存在该用户，可以继续尝试下一个认证源
+	ErrUserNotFound       = errors.New("用户不存在")
+	ErrInvalidCredentials = errors.New("用户名或密码错误")
+)
+
+// Identity 为外部认证源认证成功后返回的用户信息。
+// ExternalID 为账号在认证源中的标识，不随登录时输入的大小写变化：LDAP 为条目 DN，RADIUS 为小写用户名，OIDC 为 iss#sub。
+type Identity struct {
+	Username   string   `json:"username"`
+	FullName   string   `json:"full_name"`
+	Email      string   `json:"email"`
+	Groups     []string `json:"groups"`
+	ExternalID string   `json:"external_id,omitempty"`
+}
+
+// Provider 为外部认证源的实现
+type Provider interface {
+	// Authenticate 校验用户名和密码，用户不存在时返回 ErrUserNotFound
+	Authenticate(username, password string) (*Identity, error)
+	// Test 检查认证源的连接和服务账号配置
+	Test() error
+}
+
+// Result 为认证结果，UserGroup 和 Role 为按组映射得到的本地用户组和管理员角色
+type Result struct {
+	Provider  *models.AuthProvider
+	Identity  *Identity
+	UserGroup string
+	Role      string
+}
+
+func New(p *models.AuthProvider) (Provider, error) {
+	switch p.Type {
+	case ProviderLDAP:
+		return newLDAPProvider(p.Settings)
+	case ProviderRADIUS:
+		return newRADIUSProvider(p.Settings)
+	case ProviderOIDC:
+		if p.VPNEnabled {
+			return nil, fmt.Errorf("OIDC 认证源只能用于控制台登录")
+		}
+		return newOIDCProvider(p.Settings)
+	}
+	return nil, fmt.Errorf("不支持的认证源类型: %s", p.Type)
+}
+
+func enabledFor(p *models.AuthProvider, scope string) bool {
+	if !p.Enabled {
+		return false
+	}
+	if scope == ScopeConsole {
+		return p.ConsoleEnabled
+	}
+	return p.VPNEnabled
+}
+
+// Authenticate 按优先级依次尝试启用的认证源，用户不存在时继续尝试下一个
+func Authenticate(scope, username, password string) (*Result, error) {
+	providers, err := models.ListAuthProviders()
+	if err != nil {
+		return nil, fmt.Errorf("查询认证源失败: %v", err)
+	}
+
+	for i := range providers {
+		p := &providers[i]
+		if !enabledFor(p, scope) {
+			continue
+		}
+		result, err := authenticateWith(p, username, password)
+		if errors.Is(err, ErrUserNotFound) {
+			continue
+		}
+		return result, err
+	}
+	return nil, ErrUserNotFound
+}
+
+// AuthenticateWith 使用指定认证源认证，用于已绑定认证源的本地账号
+func AuthenticateWith(providerID int, scope, username, password string) (*Result, error) {
+	p, err := models.GetAuthProvider(providerID)
+	if err != nil {
+		return nil, fmt.Errorf("认证源不存在")
+	}
+	if !enabledFor(p, scope) {
+		return nil, fmt.Errorf("认证源 %s 未启用", p.Name)
+	}
+	return authenticateWith(p, username, password)
+}
+
+func authenticateWith(p *models.AuthProvider, username, password string) (*Result, error) {
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	provider, err := New(p)
+	if err != nil {
+		return nil, err
+	}
+	identity, err := provider.Authenticate(username, password)
+	if err != nil {
+		return nil, err
+	}
+	return MapIdentity(p, identity), nil
+}
+
+// MapIdentity 按认证源的组映射确定本地用户组和管理员角色，均取第一条匹配的映射
+func MapIdentity(p *models.AuthProvider, identity *Identity) *Result {
+	result := &Result{Provider: p, Identity: identity}
+	for _, m := range p.GroupMappings {
+		if !memberOf(identity.Groups, m.Group) {
+			continue
+		}
+		if result.UserGroup == "" && m.UserGroup != "" {
+			result.UserGroup = m.UserGroup
+		}
+		if result.Role == "" && m.Role != "" {
+			result.Role = m.Role
+		}
+	}
+	return result
+}
+
+// memberOf 判断组列表中是否包含 group，group 可以是完整 DN 或第一个 RDN 的值
+func memberOf(groups []string, group string) bool {
+	for _, g := range groups {
+		if strings.EqualFold(g, group) || strings.EqualFold(firstRDNValue(g), group) {
+			return true
+		}
+	}
+	return false
+}
+
+func firstRDNValue(dn string) string {
+	rdn := dn
+	if i := strings.Index(dn, ","); i >= 0 {
+		rdn = dn[:i]
+	}
+	if i := strings.Index(rdn, "="); i >= 0 {
+		return strings.TrimSpace(rdn[i+1:])
+	}
+	return rdn
+}
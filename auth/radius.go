@@ -0,0 +1,424 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2866"
+	"layeh.com/radius/rfc2869"
+)
+
+const (
+	defaultRADIUSTimeout         = 5
+	defaultRADIUSInterimInterval = 300
+	defaultRADIUSNASIdentifier   = "edge-server"
+
+	radiusGroupClass    = "class"
+	radiusGroupFilterID = "filter_id"
+
+	radiusRetryInterval = time.Second
+)
+
+// errMessageAuthenticator 表示认证响应缺少有效的 Message-Authenticator。
+// 不校验该属性时，中间人可利用 MD5 碰撞把 Access-Reject 伪造为 Access-Accept (Blast-RADIUS, CVE-2024-3596)
+var errMessageAuthenticator = errors.New("响应缺少有效的 Message-Authenticator，请在 RADIUS 服务器上为该客户端启用")
+
+// 计费记录的状态和断开原因
+const (
+	AccountingStart   = "start"
+	AccountingInterim = "interim"
+	AccountingStop    = "stop"
+
+	TerminateUserRequest    = "user_request"
+	TerminateLostCarrier    = "lost_carrier"
+	TerminateIdleTimeout    = "idle_timeout"
+	TerminateSessionTimeout = "session_timeout"
+	TerminateAdminReset     = "admin_reset"
+)
+
+// RADIUSServer 为一台 RADIUS 服务器，AccountingAddress 留空时使用认证地址的主机和 1813 端口
+type RADIUSServer struct {
+	Address           string `json:"address"`
+	AccountingAddress string `json:"accounting_address"`
+	Secret            string `json:"secret"`
+}
+
+// RADIUSSettings 为 RADIUS 认证源的配置。
+// Servers 按顺序使用，前一台超时或网络不通时切换到下一台；Timeout 为每台服务器的等待秒数。
+// 计费默认只针对认证源为本认证源的用户，AccountAllUsers 为 true 时所有 VPN 会话都发送计费请求。
+type RADIUSSettings struct {
+	Servers         []RADIUSServer `json:"servers"`
+	Timeout         int            `json:"timeout"`
+	NASIdentifier   string         `json:"nas_identifier"`
+	GroupAttribute  string         `json:"group_attribute"`
+	Accounting      bool           `json:"accounting"`
+	AccountAllUsers bool           `json:"account_all_users"`
+	InterimInterval int            `json:"interim_interval"`
+}
+
+// AccountingRecord 为一次计费请求携带的会话数据，InputOctets 为客户端上传的字节数
+type AccountingRecord struct {
+	Status         string
+	SessionID      string
+	Username       string
+	RemoteIP       string
+	VirtualIP      string
+	SessionTime    time.Duration
+	InputOctets    int64
+	OutputOctets   int64
+	TerminateCause string
+}
+
+// Accounter 为支持计费的认证源
+type Accounter interface {
+	AccountingEnabled() bool
+	AccountAllUsers() bool
+	InterimInterval() time.Duration
+	Account(record *AccountingRecord) error
+}
+
+type radiusProvider struct {
+	settings RADIUSSettings
+}
+
+func newRADIUSProvider(raw json.RawMessage) (*radiusProvider, error) {
+	var s RADIUSSettings
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, fmt.Errorf("RADIUS 配置格式错误: %v", err)
+		}
+	}
+
+	if len(s.Servers) == 0 {
+		return nil, fmt.Errorf("至少需要配置一台 RADIUS 服务器")
+	}
+	for i := range s.Servers {
+		server := &s.Servers[i]
+		host, _, err := net.SplitHostPort(server.Address)
+		if err != nil || host == "" {
+			return nil, fmt.Errorf("RADIUS 服务器地址格式错误: %s，应为 host:1812", server.Address)
+		}
+		if server.AccountingAddress == "" {
+			server.AccountingAddress = net.JoinHostPort(host, "1813")
+		} else if _, _, err := net.SplitHostPort(server.AccountingAddress); err != nil {
+			return nil, fmt.Errorf("RADIUS 计费地址格式错误: %s，应为 host:1813", server.AccountingAddress)
+		}
+		if server.Secret == "" {
+			return nil, fmt.Errorf("RADIUS 服务器 %s 的共享密钥不能为空", server.Address)
+		}
+	}
+	if s.Timeout <= 0 {
+		s.Timeout = defaultRADIUSTimeout
+	}
+	if s.NASIdentifier == "" {
+		s.NASIdentifier = defaultRADIUSNASIdentifier
+	}
+	switch s.GroupAttribute {
+	case "":
+		s.GroupAttribute = radiusGroupClass
+	case radiusGroupClass, radiusGroupFilterID:
+	default:
+		return nil, fmt.Errorf("不支持的组属性: %s，可选 class 或 filter_id", s.GroupAttribute)
+	}
+	if s.InterimInterval <= 0 {
+		s.InterimInterval = defaultRADIUSInterimInterval
+	}
+	return &radiusProvider{settings: s}, nil
+}
+
+// exchange 依次向各服务器发送请求，超时或网络错误时切换到下一台
+func (p *radiusProvider) exchange(build func(secret []byte) (*radius.Packet, error), accounting bool) (*radius.Packet, error) {
+	timeout := time.Duration(p.settings.Timeout) * time.Second
+
+	var errs []string
+	for _, server := range p.settings.Servers {
+		addr := server.Address
+		if accounting {
+			addr = server.AccountingAddress
+		}
+		packet, err := build([]byte(server.Secret))
+		if err != nil {
+			return nil, err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		response, err := exchangePacket(ctx, packet, addr, !accounting)
+		cancel()
+		if err == nil {
+			return response, nil
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("等待响应超时，请检查地址和共享密钥")
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", addr, err))
+	}
+	return nil, fmt.Errorf("RADIUS 服务器均不可用 (%s)", strings.Join(errs, "; "))
+}
+
+// exchangePacket 发送请求并等待经共享密钥校验的响应，未收到响应时每秒重发一次。
+// radius 库的 Client 只返回解析后的响应，无法按原始字节校验 Message-Authenticator，因此自行收发
+func exchangePacket(ctx context.Context, packet *radius.Packet, addr string, requireMessageAuthenticator bool) (*radius.Packet, error) {
+	wire, err := packet.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", addr)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	conn.Write(wire)
+	go func() {
+		retry := time.NewTicker(radiusRetryInterval)
+		defer retry.Stop()
+		defer conn.Close()
+		for {
+			select {
+			case <-retry.C:
+				conn.Write(wire)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	incoming := make([]byte, radius.MaxPacketLength)
+	for {
+		n, err := conn.Read(incoming)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+
+		// 伪造或过期的响应直接丢弃，继续等待
+		raw := incoming[:n]
+		if !radius.IsAuthenticResponse(raw, wire, packet.Secret) {
+			continue
+		}
+		response, err := radius.Parse(raw, packet.Secret)
+		if err != nil || response.Identifier != packet.Identifier {
+			continue
+		}
+		if requireMessageAuthenticator && !validMessageAuthenticator(raw, packet.Authenticator, packet.Secret) {
+			return nil, errMessageAuthenticator
+		}
+		return response, nil
+	}
+}
+
+// setMessageAuthenticator 按 RFC 3579 计算并设置 Message-Authenticator。
+// 响应包由 Request.Response 生成，Authenticator 字段即为请求认证字，因此请求和响应都可使用
+func setMessageAuthenticator(packet *radius.Packet) error {
+	if err := rfc2869.MessageAuthenticator_Set(packet, make([]byte, md5.Size)); err != nil {
+		return err
+	}
+	wire, err := packet.Encode()
+	if err != nil {
+		return err
+	}
+	copy(wire[4:20], packet.Authenticator[:])
+
+	mac := hmac.New(md5.New, packet.Secret)
+	mac.Write(wire)
+	return rfc2869.MessageAuthenticator_Set(packet, mac.Sum(nil))
+}
+
+// validMessageAuthenticator 校验原始报文中的 Message-Authenticator，必须恰好有一个。
+// 计算时认证字段替换为请求认证字，Message-Authenticator 的值置零
+func validMessageAuthenticator(raw []byte, requestAuthenticator [16]byte, secret []byte) bool {
+	if len(raw) < 20 {
+		return false
+	}
+	data := append([]byte(nil), raw...)
+	copy(data[4:20], requestAuthenticator[:])
+
+	var received []byte
+	for offset := 20; offset < len(data); {
+		if offset+2 > len(data) {
+			return false
+		}
+		length := int(data[offset+1])
+		if length < 2 || offset+length > len(data) {
+			return false
+		}
+		if radius.Type(data[offset]) == rfc2869.MessageAuthenticator_Type {
+			if received != nil || length != 2+md5.Size {
+				return false
+			}
+			value := data[offset+2 : offset+length]
+			received = append([]byte(nil), value...)
+			copy(value, make([]byte, md5.Size))
+		}
+		offset += length
+	}
+	if received == nil {
+		return false
+	}
+
+	mac := hmac.New(md5.New, secret)
+	mac.Write(data)
+	return hmac.Equal(received, mac.Sum(nil))
+}
+
+func (p *radiusProvider) accessRequest(username, password string) (*radius.Packet, error) {
+	return p.exchange(func(secret []byte) (*radius.Packet, error) {
+		packet := radius.New(radius.CodeAccessRequest, secret)
+		if err := rfc2865.UserName_SetString(packet, username); err != nil {
+			return nil, err
+		}
+		if err := rfc2865.UserPassword_Set(packet, padRADIUSPassword(password)); err != nil {
+			return nil, fmt.Errorf("密码过长")
+		}
+		rfc2865.NASIdentifier_SetString(packet, p.settings.NASIdentifier)
+		rfc2865.NASPortType_Set(packet, rfc2865.NASPortType_Value_Virtual)
+		if err := setMessageAuthenticator(packet); err != nil {
+			return nil, err
+		}
+		return packet, nil
+	}, false)
+}
+
+// padRADIUSPassword 按 RFC 2865 将密码用 NUL 补齐到 16 字节的整数倍，
+// 当前版本的 radius 库在明文不足 16 字节时不会自行补齐
+func padRADIUSPassword(password string) []byte {
+	size := (len(password) + 15) / 16 * 16
+	if size == 0 {
+		size = 16
+	}
+	padded := make([]byte, size)
+	copy(padded, password)
+	return padded
+}
+
+// Test 发送一个测试账号的认证请求，收到经共享密钥校验的 Accept 或 Reject 即说明地址和密钥正确
+func (p *radiusProvider) Test() error {
+	_, err := p.accessRequest("edge-server-test", "edge-server-test")
+	return err
+}
+
+// Authenticate 使用 PAP 方式认证；RADIUS 无法区分用户不存在和密码错误，均视为密码错误
+func (p *radiusProvider) Authenticate(username, password string) (*Identity, error) {
+	if password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	response, err := p.accessRequest(username, password)
+	if err != nil {
+		return nil, err
+	}
+	switch response.Code {
+	case radius.CodeAccessAccept:
+	case radius.CodeAccessReject:
+		return nil, ErrInvalidCredentials
+	case radius.CodeAccessChallenge:
+		return nil, fmt.Errorf("RADIUS 服务器要求二次验证 (Access-Challenge)，暂不支持")
+	default:
+		return nil, fmt.Errorf("RADIUS 服务器返回了意外的响应: %s", response.Code)
+	}
+
+	// RADIUS 没有单独的账号标识，服务器一般不区分用户名大小写
+	identity := &Identity{Username: username, ExternalID: strings.ToLower(username)}
+	if p.settings.GroupAttribute == radiusGroupFilterID {
+		identity.Groups, _ = rfc2865.FilterID_GetStrings(response)
+	} else {
+		classes, _ := rfc2865.Class_Gets(response)
+		for _, class := range classes {
+			identity.Groups = append(identity.Groups, string(class))
+		}
+	}
+	return identity, nil
+}
+
+func (p *radiusProvider) AccountingEnabled() bool {
+	return p.settings.Accounting
+}
+
+func (p *radiusProvider) AccountAllUsers() bool {
+	return p.settings.AccountAllUsers
+}
+
+func (p *radiusProvider) InterimInterval() time.Duration {
+	return time.Duration(p.settings.InterimInterval) * time.Second
+}
+
+// Account 发送 Accounting-Request，流量超过 4GB 的部分通过 Gigawords 属性上报
+func (p *radiusProvider) Account(record *AccountingRecord) error {
+	var status rfc2866.AcctStatusType
+	switch record.Status {
+	case AccountingStart:
+		status = rfc2866.AcctStatusType_Value_Start
+	case AccountingInterim:
+		status = rfc2866.AcctStatusType_Value_InterimUpdate
+	case AccountingStop:
+		status = rfc2866.AcctStatusType_Value_Stop
+	default:
+		return fmt.Errorf("未知的计费类型: %s", record.Status)
+	}
+
+	response, err := p.exchange(func(secret []byte) (*radius.Packet, error) {
+		packet := radius.New(radius.CodeAccountingRequest, secret)
+		rfc2866.AcctStatusType_Set(packet, status)
+		rfc2866.AcctSessionID_SetString(packet, record.SessionID)
+		if err := rfc2865.UserName_SetString(packet, record.Username); err != nil {
+			return nil, err
+		}
+		rfc2865.NASIdentifier_SetString(packet, p.settings.NASIdentifier)
+		rfc2865.NASPortType_Set(packet, rfc2865.NASPortType_Value_Virtual)
+		if record.RemoteIP != "" {
+			rfc2865.CallingStationID_SetString(packet, record.RemoteIP)
+		}
+		if ip := net.ParseIP(record.VirtualIP).To4(); ip != nil {
+			rfc2865.FramedIPAddress_Set(packet, ip)
+		}
+		if status == rfc2866.AcctStatusType_Value_Start {
+			return packet, nil
+		}
+
+		rfc2866.AcctSessionTime_Set(packet, rfc2866.AcctSessionTime(record.SessionTime/time.Second))
+		rfc2866.AcctInputOctets_Set(packet, rfc2866.AcctInputOctets(uint32(record.InputOctets)))
+		rfc2866.AcctOutputOctets_Set(packet, rfc2866.AcctOutputOctets(uint32(record.OutputOctets)))
+		rfc2869.AcctInputGigawords_Set(packet, rfc2869.AcctInputGigawords(uint32(record.InputOctets>>32)))
+		rfc2869.AcctOutputGigawords_Set(packet, rfc2869.AcctOutputGigawords(uint32(record.OutputOctets>>32)))
+		if status == rfc2866.AcctStatusType_Value_Stop {
+			cause := rfc2866.AcctTerminateCause_Value_UserRequest
+			switch record.TerminateCause {
+			case TerminateLostCarrier:
+				cause = rfc2866.AcctTerminateCause_Value_LostCarrier
+			case TerminateIdleTimeout:
+				cause = rfc2866.AcctTerminateCause_Value_IdleTimeout
+			case TerminateSessionTimeout:
+				cause = rfc2866.AcctTerminateCause_Value_SessionTimeout
+			case TerminateAdminReset:
+				cause = rfc2866.AcctTerminateCause_Value_AdminReset
+			}
+			rfc2866.AcctTerminateCause_Set(packet, cause)
+		}
+		return packet, nil
+	}, true)
+	if err != nil {
+		return err
+	}
+	if response.Code != radius.CodeAccountingResponse {
+		return fmt.Errorf("RADIUS 服务器返回了意外的响应: %s", response.Code)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"log"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2866"
+)
+
+// RADIUSStubUser 为测试桩中的账号，Classes 作为 Class 属性返回，用于验证组映射
+type RADIUSStubUser struct {
+	Password string
+	Classes  []string
+}
+
+// RunRADIUSStub 启动本地 RADIUS 测试桩，在没有 FreeRADIUS 的环境中验证认证源配置和计费上报。
+// 认证请求按 users 校验，要求携带 Message-Authenticator，响应同样附带；计费请求只记录日志并应答。
+func RunRADIUSStub(authAddr, acctAddr, secret string, users map[string]RADIUSStubUser) error {
+	authServer, acctServer := newRADIUSStubServers(authAddr, acctAddr, secret, users)
+
+	errs := make(chan error, 2)
+	go func() { errs <- authServer.ListenAndServe() }()
+	go func() { errs <- acctServer.ListenAndServe() }()
+	log.Printf("RADIUS 测试桩已启动: 认证 %s，计费 %s，账号数 %d", authAddr, acctAddr, len(users))
+	return <-errs
+}
+
+func newRADIUSStubServers(authAddr, acctAddr, secret string, users map[string]RADIUSStubUser) (*radius.PacketServer, *radius.PacketServer) {
+	secrets := radius.StaticSecretSource([]byte(secret))
+
+	authServer := &radius.PacketServer{
+		Addr:         authAddr,
+		SecretSource: secrets,
+		Handler: radius.HandlerFunc(func(w radius.ResponseWriter, r *radius.Request) {
+			// radius 库按属性类型排序编码，重新编码即可得到客户端发送的原始报文
+			wire, err := r.Packet.Encode()
+			if err != nil || !validMessageAuthenticator(wire, r.Authenticator, r.Secret) {
+				log.Printf("[RADIUS] %s 请求缺少有效的 Message-Authenticator，丢弃", r.RemoteAddr)
+				return
+			}
+
+			username := rfc2865.UserName_GetString(r.Packet)
+			user, exists := users[username]
+			code := radius.CodeAccessAccept
+			if !exists || rfc2865.UserPassword_GetString(r.Packet) != user.Password {
+				code = radius.CodeAccessReject
+			}
+
+			response := r.Response(code)
+			if code == radius.CodeAccessAccept {
+				for _, class := range user.Classes {
+					rfc2865.Class_AddString(response, class)
+				}
+				log.Printf("[RADIUS] %s 认证通过: %s %v", r.RemoteAddr, username, user.Classes)
+			} else {
+				log.Printf("[RADIUS] %s 认证拒绝: %s", r.RemoteAddr, username)
+			}
+			if err := setMessageAuthenticator(response); err != nil {
+				log.Printf("[RADIUS] 生成 Message-Authenticator 失败: %v", err)
+				return
+			}
+			w.Write(response)
+		}),
+	}
+
+	acctServer := &radius.PacketServer{
+		Addr:         acctAddr,
+		SecretSource: secrets,
+		Handler: radius.HandlerFunc(func(w radius.ResponseWriter, r *radius.Request) {
+			log.Printf("[RADIUS] %s 计费 %s: 用户=%s 会话=%s 客户端=%s 虚拟IP=%v 时长=%ds 上传=%d 下载=%d 原因=%v",
+				r.RemoteAddr, rfc2866.AcctStatusType_Get(r.Packet),
+				rfc2865.UserName_GetString(r.Packet), rfc2866.AcctSessionID_GetString(r.Packet),
+				rfc2865.CallingStationID_GetString(r.Packet), rfc2865.FramedIPAddress_Get(r.Packet),
+				rfc2866.AcctSessionTime_Get(r.Packet),
+				rfc2866.AcctInputOctets_Get(r.Packet), rfc2866.AcctOutputOctets_Get(r.Packet),
+				rfc2866.AcctTerminateCause_Get(r.Packet))
+			w.Write(r.Response(radius.CodeAccountingResponse))
+		}),
+	}
+	return authServer, acctServer
+}
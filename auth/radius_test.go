@@ -0,0 +1,338 @@
+package auth
+
+import (
+	"bytes"
+	"edge_server/models"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2866"
+	"layeh.com/radius/rfc2869"
+)
+
+const testRADIUSSecret = "testing123"
+
+var testRADIUSUsers = map[string]RADIUSStubUser{
+	"alice": {Password: "short", Classes: []string{"vpn-users", "admins"}},
+	"bob":   {Password: "a-much-longer-password-than-16-bytes"},
+	"carol": {Password: "exactly16bytes!!"},
+}
+
+func listenUDP(t *testing.T) net.PacketConn {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// startRADIUSStub 在 127.0.0.1 的随机端口上启动测试桩，返回认证和计费地址
+func startRADIUSStub(t *testing.T) (string, string) {
+	t.Helper()
+	authConn, acctConn := listenUDP(t), listenUDP(t)
+	authServer, acctServer := newRADIUSStubServers("", "", testRADIUSSecret, testRADIUSUsers)
+	go authServer.Serve(authConn)
+	go acctServer.Serve(acctConn)
+	return authConn.LocalAddr().String(), acctConn.LocalAddr().String()
+}
+
+// deadUDPAddr 返回一个已关闭的端口，发送到该端口会立即收到 ICMP 不可达
+func deadUDPAddr(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := conn.LocalAddr().String()
+	conn.Close()
+	return addr
+}
+
+func newTestRADIUSProvider(t *testing.T, settings RADIUSSettings) *radiusProvider {
+	t.Helper()
+	if settings.Timeout == 0 {
+		settings.Timeout = 2
+	}
+	raw, _ := json.Marshal(settings)
+	p, err := newRADIUSProvider(raw)
+	if err != nil {
+		t.Fatalf("newRADIUSProvider: %v", err)
+	}
+	return p
+}
+
+func TestPadRADIUSPassword(t *testing.T) {
+	tests := []struct {
+		password string
+		size     int
+	}{
+		{"", 16},
+		{"a", 16},
+		{"exactly16bytes!!", 16},
+		{"seventeen-bytes!!", 32},
+		{strings.Repeat("x", 128), 128},
+	}
+	for _, tt := range tests {
+		padded := padRADIUSPassword(tt.password)
+		if len(padded) != tt.size {
+			t.Errorf("len(pad(%q)) = %d, want %d", tt.password, len(padded), tt.size)
+		}
+		if !bytes.HasPrefix(padded, []byte(tt.password)) || len(bytes.Trim(padded[len(tt.password):], "\x00")) != 0 {
+			t.Errorf("pad(%q) = %q", tt.password, padded)
+		}
+	}
+}
+
+func TestRADIUSAuthenticate(t *testing.T) {
+	authAddr, _ := startRADIUSStub(t)
+	p := newTestRADIUSProvider(t, RADIUSSettings{Servers: []RADIUSServer{{Address: authAddr, Secret: testRADIUSSecret}}})
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		groups   []string
+		wantErr  error
+	}{
+		{name: "短密码", username: "alice", password: "short", groups: []string{"vpn-users", "admins"}},
+		{name: "长密码", username: "bob", password: "a-much-longer-password-than-16-bytes"},
+		{name: "16 字节密码", username: "carol", password: "exactly16bytes!!"},
+		{name: "密码错误", username: "alice", password: "wrong", wantErr: ErrInvalidCredentials},
+		{name: "长密码错误", username: "bob", password: "a-much-longer-password-than-16-bytez", wantErr: ErrInvalidCredentials},
+		{name: "密码前缀", username: "bob", password: "a-much-longer-pa", wantErr: ErrInvalidCredentials},
+		{name: "用户不存在", username: "nobody", password: "x", wantErr: ErrInvalidCredentials},
+		{name: "空密码", username: "alice", password: "", wantErr: ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := p.Authenticate(tt.username, tt.password)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate: %v", err)
+			}
+			if identity.Username != tt.username || identity.ExternalID != tt.username || strings.Join(identity.Groups, ",") != strings.Join(tt.groups, ",") {
+				t.Errorf("identity = %+v", identity)
+			}
+		})
+	}
+}
+
+func TestRADIUSClassGroupMapping(t *testing.T) {
+	authAddr, _ := startRADIUSStub(t)
+	settings, _ := json.Marshal(RADIUSSettings{Timeout: 2, Servers: []RADIUSServer{{Address: authAddr, Secret: testRADIUSSecret}}})
+	provider := &models.AuthProvider{
+		Type:       ProviderRADIUS,
+		Enabled:    true,
+		VPNEnabled: true,
+		Settings:   settings,
+		GroupMappings: []models.GroupMapping{
+			{Group: "vpn-users", UserGroup: "员工"},
+			{Group: "admins", Role: models.RoleOperator},
+		},
+	}
+
+	result, err := authenticateWith(provider, "alice", "short")
+	if err != nil {
+		t.Fatalf("authenticateWith: %v", err)
+	}
+	if result.UserGroup != "员工" || result.Role != models.RoleOperator {
+		t.Errorf("mapped to (%q, %q)", result.UserGroup, result.Role)
+	}
+
+	result, err = authenticateWith(provider, "bob", "a-much-longer-password-than-16-bytes")
+	if err != nil {
+		t.Fatalf("authenticateWith: %v", err)
+	}
+	if result.UserGroup != "" || result.Role != "" {
+		t.Errorf("user without Class mapped to (%q, %q)", result.UserGroup, result.Role)
+	}
+}
+
+func TestRADIUSFailover(t *testing.T) {
+	authAddr, _ := startRADIUSStub(t)
+	silent := listenUDP(t)
+
+	tests := []struct {
+		name  string
+		first string
+		max   time.Duration
+	}{
+		{name: "端口不可达", first: deadUDPAddr(t), max: 500 * time.Millisecond},
+		{name: "无响应超时", first: silent.LocalAddr().String(), max: 1500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestRADIUSProvider(t, RADIUSSettings{Timeout: 1, Servers: []RADIUSServer{
+				{Address: tt.first, Secret: testRADIUSSecret},
+				{Address: authAddr, Secret: testRADIUSSecret},
+			}})
+			start := time.Now()
+			identity, err := p.Authenticate("alice", "short")
+			if err != nil {
+				t.Fatalf("Authenticate: %v", err)
+			}
+			if identity.Username != "alice" {
+				t.Errorf("identity = %+v", identity)
+			}
+			if elapsed := time.Since(start); elapsed > tt.max {
+				t.Errorf("failover took %v", elapsed)
+			}
+		})
+	}
+
+	p := newTestRADIUSProvider(t, RADIUSSettings{Timeout: 1, Servers: []RADIUSServer{
+		{Address: deadUDPAddr(t), Secret: testRADIUSSecret},
+		{Address: silent.LocalAddr().String(), Secret: testRADIUSSecret},
+	}})
+	if _, err := p.Authenticate("alice", "short"); err == nil || !strings.Contains(err.Error(), "RADIUS 服务器均不可用") {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestRADIUSWrongSecret(t *testing.T) {
+	authAddr, _ := startRADIUSStub(t)
+	p := newTestRADIUSProvider(t, RADIUSSettings{Timeout: 1, Servers: []RADIUSServer{{Address: authAddr, Secret: "wrong"}}})
+	if err := p.Test(); err == nil || !strings.Contains(err.Error(), "等待响应超时") {
+		t.Errorf("Test() = %v", err)
+	}
+}
+
+// 旧版服务器的 Access-Accept 不带 Message-Authenticator，必须拒绝
+func TestRADIUSRequiresMessageAuthenticator(t *testing.T) {
+	conn := listenUDP(t)
+	legacy := &radius.PacketServer{
+		SecretSource: radius.StaticSecretSource([]byte(testRADIUSSecret)),
+		Handler: radius.HandlerFunc(func(w radius.ResponseWriter, r *radius.Request) {
+			w.Write(r.Response(radius.CodeAccessAccept))
+		}),
+	}
+	go legacy.Serve(conn)
+
+	p := newTestRADIUSProvider(t, RADIUSSettings{Servers: []RADIUSServer{{Address: conn.LocalAddr().String(), Secret: testRADIUSSecret}}})
+	if _, err := p.Authenticate("alice", "short"); err == nil || !strings.Contains(err.Error(), "Message-Authenticator") {
+		t.Fatalf("error = %v", err)
+	}
+}
+
+func TestMessageAuthenticator(t *testing.T) {
+	request := radius.New(radius.CodeAccessRequest, []byte(testRADIUSSecret))
+	rfc2865.UserName_SetString(request, "alice")
+	if err := setMessageAuthenticator(request); err != nil {
+		t.Fatal(err)
+	}
+	wire, _ := request.Encode()
+	if !validMessageAuthenticator(wire, request.Authenticator, request.Secret) {
+		t.Fatal("request Message-Authenticator not valid")
+	}
+	if validMessageAuthenticator(wire, request.Authenticator, []byte("other")) {
+		t.Error("accepted with wrong secret")
+	}
+
+	response := request.Response(radius.CodeAccessAccept)
+	rfc2865.Class_AddString(response, "admins")
+	if err := setMessageAuthenticator(response); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := response.Encode()
+	if !validMessageAuthenticator(raw, request.Authenticator, request.Secret) {
+		t.Fatal("response Message-Authenticator not valid")
+	}
+
+	tampered := append([]byte(nil), raw...)
+	tampered[len(tampered)-1] ^= 0xff
+	if validMessageAuthenticator(tampered, request.Authenticator, request.Secret) {
+		t.Error("accepted tampered response")
+	}
+
+	response.Del(rfc2869.MessageAuthenticator_Type)
+	missing, _ := response.Encode()
+	if validMessageAuthenticator(missing, request.Authenticator, request.Secret) {
+		t.Error("accepted response without Message-Authenticator")
+	}
+}
+
+func TestRADIUSAccounting(t *testing.T) {
+	authAddr, _ := startRADIUSStub(t)
+	acctConn := listenUDP(t)
+	received := make(chan *radius.Packet, 8)
+	acctServer := &radius.PacketServer{
+		SecretSource: radius.StaticSecretSource([]byte(testRADIUSSecret)),
+		Handler: radius.HandlerFunc(func(w radius.ResponseWriter, r *radius.Request) {
+			received <- r.Packet
+			w.Write(r.Response(radius.CodeAccountingResponse))
+		}),
+	}
+	go acctServer.Serve(acctConn)
+
+	p := newTestRADIUSProvider(t, RADIUSSettings{Accounting: true, Servers: []RADIUSServer{
+		{Address: deadUDPAddr(t), Secret: testRADIUSSecret},
+		{Address: authAddr, AccountingAddress: acctConn.LocalAddr().String(), Secret: testRADIUSSecret},
+	}})
+
+	records := []AccountingRecord{
+		{Status: AccountingStart, SessionID: "s1", Username: "alice", RemoteIP: "203.0.113.5", VirtualIP: "10.10.0.2"},
+		{Status: AccountingInterim, SessionID: "s1", Username: "alice", RemoteIP: "203.0.113.5", VirtualIP: "10.10.0.2",
+			SessionTime: 300 * time.Second, InputOctets: 1024, OutputOctets: 4096},
+		{Status: AccountingStop, SessionID: "s1", Username: "alice", RemoteIP: "203.0.113.5", VirtualIP: "10.10.0.2",
+			SessionTime: 3600 * time.Second, InputOctets: 5<<32 + 7, OutputOctets: 99, TerminateCause: TerminateIdleTimeout},
+	}
+	wantStatus := []rfc2866.AcctStatusType{
+		rfc2866.AcctStatusType_Value_Start,
+		rfc2866.AcctStatusType_Value_InterimUpdate,
+		rfc2866.AcctStatusType_Value_Stop,
+	}
+
+	for i := range records {
+		record := &records[i]
+		if err := p.Account(record); err != nil {
+			t.Fatalf("Account(%s): %v", record.Status, err)
+		}
+		var packet *radius.Packet
+		select {
+		case packet = <-received:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no accounting request for %s", record.Status)
+		}
+
+		if got := rfc2866.AcctStatusType_Get(packet); got != wantStatus[i] {
+			t.Errorf("Acct-Status-Type = %v, want %v", got, wantStatus[i])
+		}
+		if rfc2866.AcctSessionID_GetString(packet) != "s1" || rfc2865.UserName_GetString(packet) != "alice" {
+			t.Errorf("session attributes: %s %s", rfc2866.AcctSessionID_GetString(packet), rfc2865.UserName_GetString(packet))
+		}
+		if rfc2865.CallingStationID_GetString(packet) != "203.0.113.5" || rfc2865.FramedIPAddress_Get(packet).String() != "10.10.0.2" {
+			t.Errorf("address attributes: %s %v", rfc2865.CallingStationID_GetString(packet), rfc2865.FramedIPAddress_Get(packet))
+		}
+		if record.Status == AccountingStart {
+			if _, err := rfc2866.AcctSessionTime_Lookup(packet); err == nil {
+				t.Error("Start carries Acct-Session-Time")
+			}
+			continue
+		}
+
+		input := int64(rfc2869.AcctInputGigawords_Get(packet))<<32 | int64(rfc2866.AcctInputOctets_Get(packet))
+		output := int64(rfc2869.AcctOutputGigawords_Get(packet))<<32 | int64(rfc2866.AcctOutputOctets_Get(packet))
+		if input != record.InputOctets || output != record.OutputOctets {
+			t.Errorf("octets = %d/%d, want %d/%d", input, output, record.InputOctets, record.OutputOctets)
+		}
+		if got := time.Duration(rfc2866.AcctSessionTime_Get(packet)) * time.Second; got != record.SessionTime {
+			t.Errorf("Acct-Session-Time = %v, want %v", got, record.SessionTime)
+		}
+		if record.Status == AccountingStop && rfc2866.AcctTerminateCause_Get(packet) != rfc2866.AcctTerminateCause_Value_IdleTimeout {
+			t.Errorf("Acct-Terminate-Cause = %v", rfc2866.AcctTerminateCause_Get(packet))
+		}
+	}
+}
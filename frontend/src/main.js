@@ -23,6 +23,7 @@ axios.interceptors.response.use(
     if (error.response?.status === 401) {
       localStorage.removeItem('token')
       localStorage.removeItem('username')
+      localStorage.removeItem('role')
       router.push('/login')
     }
     return Promise.reject(error)
@@ -3,18 +3,26 @@ import Login from '../views/Login.vue'
 import Dashboard from '../views/Dashboard.vue'
 import UserGroups from '../views/UserGroups.vue'
 import Users from '../views/Users.vue'
+import Schedules from '../views/Schedules.vue'
 import OnlineUsers from '../views/OnlineUsers.vue'
 import Logs from '../views/Logs.vue'
 import Settings from '../views/Settings.vue'
+import Security from '../views/Security.vue'
+import OTPEnroll from '../views/OTPEnroll.vue'
+import AuthProviders from '../views/AuthProviders.vue'
 
 const routes = [
   { path: '/login', component: Login, meta: { requiresAuth: false } },
+  { path: '/otp-enroll', component: OTPEnroll, meta: { requiresAuth: false } },
   { path: '/', component: Dashboard, meta: { requiresAuth: true } },
   { path: '/groups', component: UserGroups, meta: { requiresAuth: true } },
   { path: '/users', component: Users, meta: { requiresAuth: true } },
+  { path: '/schedules', component: Schedules, meta: { requiresAuth: true } },
   { path: '/online', component: OnlineUsers, meta: { requiresAuth: true } },
   { path: '/logs', component: Logs, meta: { requiresAuth: true } },
-  { path: '/settings', component: Settings, meta: { requiresAuth: true } }
+  { path: '/settings', component: Settings, meta: { requiresAuth: true } },
+  { path: '/auth-providers', component: AuthProviders, meta: { requiresAuth: true } },
+  { path: '/security', component: Security, meta: { requiresAuth: true } }
 ]
 
 const router = createRouter({
@@ -0,0 +1,35 @@
+export function connectRealtime(onMessage) {
+  const protocol = location.protocol === 'https:' ? 'wss' : 'ws'
+  let socket = null
+  let retryTimer = null
+  let closed = false
+
+  const open = () => {
+    const token = localStorage.getItem('token')
+    if (!token) return
+
+    // 浏览器无法为 WebSocket 设置请求头，Token 通过子协议传递
+    socket = new WebSocket(`${protocol}://${location.host}/api/ws`, ['edge-server', token])
+    socket.onmessage = (event) => {
+      try {
+        onMessage(JSON.parse(event.data))
+      } catch (error) {
+        console.error('解析推送消息失败:', error)
+      }
+    }
+    // 1008 表示会话已失效，不再重连
+    socket.onclose = (event) => {
+      if (!closed && event.code !== 1008) {
+        retryTimer = setTimeout(open, 5000)
+      }
+    }
+  }
+
+  open()
+
+  return () => {
+    closed = true
+    if (retryTimer) clearTimeout(retryTimer)
+    if (socket) socket.close()
+  }
+}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"database/sql"
+	"edge_server/middleware"
+	"edge_server/models"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func GetAdmins(c *gin.Context) {
+	rows, err := models.DB.Query(`
+		SELECT id, username, full_name, email, role, enabled, COALESCE(totp_enabled, 0), COALESCE(auth_provider_id, 0),
+		       COALESCE(break_glass, 0), created_at, updated_at
+		FROM admins
+		ORDER BY created_at DESC
+	`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	var admins []models.Admin
+	for rows.Next() {
+		var a models.Admin
+		var fullName, email sql.NullString
+		if err := rows.Scan(&a.ID, &a.Username, &fullName, &email, &a.Role, &a.Enabled, &a.TOTPEnabled, &a.AuthProviderID, &a.BreakGlass, &a.CreatedAt, &a.UpdatedAt); err != nil {
+			continue
+		}
+		a.FullName = fullName.String
+		a.Email = email.String
+		admins = append(admins, a)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": admins})
+}
+
+func CreateAdmin(c *gin.Context) {
+	var admin models.Admin
+	if err := c.ShouldBindJSON(&admin); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if admin.Username == "" || len(admin.Password) < 6 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "用户名不能为空且密码至少6位"})
+		return
+	}
+
+	if !models.IsValidRole(admin.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的角色"})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(admin.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "密码加密失败"})
+		return
+	}
+
+	result, err := models.DB.Exec(`
+		INSERT INTO admins (username, password, full_name, email, role, enabled, break_glass)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, admin.Username, string(hashedPassword), admin.FullName, admin.Email, admin.Role, admin.Enabled, admin.BreakGlass)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, _ := result.LastInsertId()
+	admin.ID = int(id)
+	admin.Password = ""
+	c.JSON(http.StatusOK, gin.H{"data": admin})
+}
+
+func UpdateAdmin(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var admin models.Admin
+	if err := c.ShouldBindJSON(&admin); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !models.IsValidRole(admin.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的角色"})
+		return
+	}
+
+	var username, currentRole string
+	var currentEnabled bool
+	err := models.DB.QueryRow("SELECT username, role, enabled FROM admins WHERE id=?", id).Scan(&username, &currentRole, &currentEnabled)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "管理员不存在"})
+		return
+	}
+
+	losesSuperAdmin := currentRole == models.RoleSuperAdmin && currentEnabled &&
+		(admin.Role != models.RoleSuperAdmin || !admin.Enabled)
+	if losesSuperAdmin && models.CountEnabledSuperAdmins() <= 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "至少需要保留一个启用的超级管理员"})
+		return
+	}
+	staysBreakGlass := admin.BreakGlass && admin.Enabled && admin.Role == models.RoleSuperAdmin
+	if !staysBreakGlass && models.ConsoleSSOOnly() && models.CountBreakGlassAdmins(id) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "已启用仅单点登录，至少需要保留一个启用的应急超级管理员"})
+		return
+	}
+
+	if admin.Password != "" {
+		if len(admin.Password) < 6 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "密码至少6位"})
+			return
+		}
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(admin.Password), bcrypt.DefaultCost)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "密码加密失败"})
+			return
+		}
+		_, err = models.DB.Exec(`
+			UPDATE admins
+			SET password=?, full_name=?, email=?, role=?, enabled=?, break_glass=?, updated_at=CURRENT_TIMESTAMP
+			WHERE id=?
+		`, string(hashedPassword), admin.FullName, admin.Email, admin.Role, admin.Enabled, admin.BreakGlass, id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	} else {
+		_, err := models.DB.Exec(`
+			UPDATE admins
+			SET full_name=?, email=?, role=?, enabled=?, break_glass=?, updated_at=CURRENT_TIMESTAMP
+			WHERE id=?
+		`, admin.FullName, admin.Email, admin.Role, admin.Enabled, admin.BreakGlass, id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if !admin.Enabled {
+		models.DeleteAdminSessionsByUsername(username)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "更新成功"})
+}
+
+func DeleteAdmin(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	if id == c.GetInt("admin_id") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不能删除当前登录的管理员"})
+		return
+	}
+
+	var username, role string
+	var enabled bool
+	err := models.DB.QueryRow("SELECT username, role, enabled FROM admins WHERE id=?", id).Scan(&username, &role, &enabled)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "管理员不存在"})
+		return
+	}
+
+	if role == models.RoleSuperAdmin && enabled && models.CountEnabledSuperAdmins() <= 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "至少需要保留一个启用的超级管理员"})
+		return
+	}
+	if models.ConsoleSSOOnly() && models.CountBreakGlassAdmins(id) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "已启用仅单点登录，至少需要保留一个启用的应急超级管理员"})
+		return
+	}
+
+	if _, err := models.DB.Exec("DELETE FROM admins WHERE id=?", id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	models.DeleteAdminSessionsByUsername(username)
+
+	c.JSON(http.StatusOK, gin.H{"message": "删除成功"})
+}
+
+// ResetAdminTOTP 清除指定管理员的二次验证，用于设备丢失后重新绑定
+func ResetAdminTOTP(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+
+	var username string
+	if err := models.DB.QueryRow("SELECT username FROM admins WHERE id=?", id).Scan(&username); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "管理员不存在"})
+		return
+	}
+
+	if err := models.ResetAdminTOTP(username); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	models.LogAuthEvent(username, c.ClientIP(), models.AuthActionTOTPReset, true,
+		"二次验证已被管理员 "+c.GetString("username")+" 重置")
+
+	c.JSON(http.StatusOK, gin.H{"message": "二次验证已重置，该管理员下次登录后可重新绑定"})
+}
+
+func GetProfile(c *gin.Context) {
+	role := c.GetString("role")
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{
+		"username":    c.GetString("username"),
+		"role":        role,
+		"permissions": middleware.RolePermissions(role),
+	}})
+}
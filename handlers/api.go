@@ -2,12 +2,18 @@ package handlers
 
 import (
 	"bufio"
+	"database/sql"
 	"edge_server/models"
 	"edge_server/vpn"
+	"fmt"
+	"log"
+	"net"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -17,7 +23,10 @@ import (
 
 func GetUserGroups(c *gin.Context) {
 	rows, err := models.DB.Query(`
-		SELECT id, name, description, ip_pool, routes, policies, created_at, updated_at 
+		SELECT id, name, COALESCE(description, ''), COALESCE(ip_pool, ''), COALESCE(routes, ''), COALESCE(no_routes, ''),
+		       COALESCE(dns, ''), COALESCE(split_tunnel, 1), COALESCE(policies, ''), COALESCE(schedule_id, 0),
+		       COALESCE(max_sessions, 0), COALESCE(session_limit_policy, ''), COALESCE(upload_limit, 0), COALESCE(download_limit, 0),
+		       COALESCE(daily_quota, 0), COALESCE(monthly_quota, 0), COALESCE(otp_required, 0), created_at, updated_at 
 		FROM user_groups
 		ORDER BY created_at DESC
 	`)
@@ -30,7 +39,8 @@ func GetUserGroups(c *gin.Context) {
 	var groups []models.UserGroup
 	for rows.Next() {
 		var g models.UserGroup
-		if err := rows.Scan(&g.ID, &g.Name, &g.Description, &g.IPPool, &g.Routes, &g.Policies, &g.CreatedAt, &g.UpdatedAt); err != nil {
+		if err := rows.Scan(&g.ID, &g.Name, &g.Description, &g.IPPool, &g.Routes, &g.NoRoutes,
+			&g.DNS, &g.SplitTunnel, &g.Policies, &g.ScheduleID, &g.MaxSessions, &g.SessionLimitPolicy, &g.UploadLimit, &g.DownloadLimit, &g.DailyQuota, &g.MonthlyQuota, &g.OTPRequired, &g.CreatedAt, &g.UpdatedAt); err != nil {
 			continue
 		}
 		groups = append(groups, g)
@@ -41,15 +51,22 @@ func GetUserGroups(c *gin.Context) {
 
 func CreateUserGroup(c *gin.Context) {
 	var group models.UserGroup
+	group.SplitTunnel = true
 	if err := c.ShouldBindJSON(&group); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	if err := validateUserGroup(&group); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	result, err := models.DB.Exec(`
-		INSERT INTO user_groups (name, description, ip_pool, routes, policies) 
-		VALUES (?, ?, ?, ?, ?)
-	`, group.Name, group.Description, group.IPPool, group.Routes, group.Policies)
+		INSERT INTO user_groups (name, description, ip_pool, routes, no_routes, dns, split_tunnel, policies, schedule_id, max_sessions, session_limit_policy, upload_limit, download_limit, daily_quota, monthly_quota, otp_required) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, group.Name, group.Description, group.IPPool, group.Routes, group.NoRoutes, group.DNS, group.SplitTunnel, group.Policies, group.ScheduleID,
+		group.MaxSessions, group.SessionLimitPolicy, group.UploadLimit, group.DownloadLimit, group.DailyQuota, group.MonthlyQuota, group.OTPRequired)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -57,30 +74,107 @@ func CreateUserGroup(c *gin.Context) {
 
 	id, _ := result.LastInsertId()
 	group.ID = int(id)
+	applyGroupChange("新增用户组 " + group.Name)
 	c.JSON(http.StatusOK, gin.H{"data": group})
 }
 
 func UpdateUserGroup(c *gin.Context) {
 	id := c.Param("id")
 	var group models.UserGroup
+	group.SplitTunnel = true
 	if err := c.ShouldBindJSON(&group); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	if err := validateUserGroup(&group); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	_, err := models.DB.Exec(`
 		UPDATE user_groups 
-		SET name=?, description=?, ip_pool=?, routes=?, policies=?, updated_at=CURRENT_TIMESTAMP 
+		SET name=?, description=?, ip_pool=?, routes=?, no_routes=?, dns=?, split_tunnel=?, policies=?, schedule_id=?, max_sessions=?, session_limit_policy=?, upload_limit=?, download_limit=?, daily_quota=?, monthly_quota=?, otp_required=?, updated_at=CURRENT_TIMESTAMP 
 		WHERE id=?
-	`, group.Name, group.Description, group.IPPool, group.Routes, group.Policies, id)
+	`, group.Name, group.Description, group.IPPool, group.Routes, group.NoRoutes, group.DNS, group.SplitTunnel, group.Policies, group.ScheduleID,
+		group.MaxSessions, group.SessionLimitPolicy, group.UploadLimit, group.DownloadLimit, group.DailyQuota, group.MonthlyQuota, group.OTPRequired, id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	applyGroupChange("更新用户组 " + group.Name)
+
 	c.JSON(http.StatusOK, gin.H{"message": "更新成功"})
 }
 
+func validateUserGroup(group *models.UserGroup) error {
+	if strings.TrimSpace(group.Name) == "" {
+		return fmt.Errorf("用户组名称不能为空")
+	}
+
+	if group.IPPool != "" {
+		if _, _, err := net.ParseCIDR(group.IPPool); err != nil {
+			return fmt.Errorf("IP地址池格式错误: %s", group.IPPool)
+		}
+	}
+
+	for _, route := range vpn.SplitList(group.Routes) {
+		if route == "default" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(route); err != nil {
+			return fmt.Errorf("路由格式错误: %s", route)
+		}
+	}
+
+	for _, route := range vpn.SplitList(group.NoRoutes) {
+		if _, _, err := net.ParseCIDR(route); err != nil {
+			return fmt.Errorf("排除路由格式错误: %s", route)
+		}
+	}
+
+	for _, dns := range vpn.SplitList(group.DNS) {
+		if net.ParseIP(dns) == nil {
+			return fmt.Errorf("DNS地址格式错误: %s", dns)
+		}
+	}
+
+	if err := vpn.ValidatePolicies(group.Policies); err != nil {
+		return err
+	}
+
+	if err := vpn.ValidateSessionLimit(group.MaxSessions, group.SessionLimitPolicy); err != nil {
+		return err
+	}
+
+	if group.UploadLimit < 0 || group.DownloadLimit < 0 {
+		return fmt.Errorf("带宽限制不能为负数")
+	}
+
+	if group.DailyQuota < 0 || group.MonthlyQuota < 0 {
+		return fmt.Errorf("流量配额不能为负数")
+	}
+
+	return validateScheduleID(group.ScheduleID)
+}
+
+// validateScheduleID 检查引用的时间策略是否存在，0 表示不限制
+func validateScheduleID(id int) error {
+	if id == 0 {
+		return nil
+	}
+	if id < 0 {
+		return fmt.Errorf("时间策略不存在: %d", id)
+	}
+	if _, err := models.GetSchedule(id); err == sql.ErrNoRows {
+		return fmt.Errorf("时间策略不存在: %d", id)
+	} else if err != nil {
+		return fmt.Errorf("查询时间策略失败: %v", err)
+	}
+	return nil
+}
+
 func DeleteUserGroup(c *gin.Context) {
 	id := c.Param("id")
 	_, err := models.DB.Exec("DELETE FROM user_groups WHERE id=?", id)
@@ -89,36 +183,118 @@ func DeleteUserGroup(c *gin.Context) {
 		return
 	}
 
+	applyGroupChange("删除用户组 " + id)
+
 	c.JSON(http.StatusOK, gin.H{"message": "删除成功"})
 }
 
-func GetUsers(c *gin.Context) {
+func queryUsers(where string, args ...interface{}) ([]models.User, error) {
 	rows, err := models.DB.Query(`
-		SELECT u.id, u.username, u.full_name, u.email, u.group_id, g.name as group_name, 
-		       u.custom_routes, u.custom_policies, u.enabled, u.created_at, u.updated_at 
+		SELECT u.id, u.username, COALESCE(u.full_name, ''), COALESCE(u.email, ''), u.group_id, COALESCE(g.name, '') as group_name, 
+		       u.custom_routes, u.custom_policies, COALESCE(u.schedule_id, 0), u.valid_from, u.valid_until,
+		       COALESCE(u.max_sessions, 0), COALESCE(u.session_limit_policy, ''), COALESCE(u.upload_limit, 0), COALESCE(u.download_limit, 0),
+		       COALESCE(u.daily_quota, 0), COALESCE(u.monthly_quota, 0), COALESCE(u.otp_enabled, 0),
+		       COALESCE(u.auth_provider_id, 0), COALESCE(p.name, ''), u.enabled, u.created_at, u.updated_at 
 		FROM users u
 		LEFT JOIN user_groups g ON u.group_id = g.id
+		LEFT JOIN auth_providers p ON u.auth_provider_id = p.id
+		`+where+`
 		ORDER BY u.created_at DESC
-	`)
+	`, args...)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		return nil, err
 	}
 	defer rows.Close()
 
 	var users []models.User
 	for rows.Next() {
 		var u models.User
+		var validFrom, validUntil sql.NullTime
 		if err := rows.Scan(&u.ID, &u.Username, &u.FullName, &u.Email, &u.GroupID, &u.GroupName, 
-			&u.CustomRoutes, &u.CustomPolicies, &u.Enabled, &u.CreatedAt, &u.UpdatedAt); err != nil {
+			&u.CustomRoutes, &u.CustomPolicies, &u.ScheduleID, &validFrom, &validUntil, &u.MaxSessions, &u.SessionLimitPolicy, &u.UploadLimit, &u.DownloadLimit, &u.DailyQuota, &u.MonthlyQuota, &u.OTPEnabled, &u.AuthProviderID, &u.AuthProvider, &u.Enabled, &u.CreatedAt, &u.UpdatedAt); err != nil {
 			continue
 		}
+		if validFrom.Valid {
+			u.ValidFrom = &validFrom.Time
+		}
+		if validUntil.Valid {
+			u.ValidUntil = &validUntil.Time
+		}
 		users = append(users, u)
 	}
+	return users, nil
+}
+
+func GetUsers(c *gin.Context) {
+	users, err := queryUsers("")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"data": users})
 }
 
+// GetExpiringUsers 列出未来 N 天内到期的启用账号，便于提前续期
+func GetExpiringUsers(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil || days < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "days 参数无效"})
+		return
+	}
+
+	users, err := queryUsers("WHERE u.enabled=1 AND u.valid_until IS NOT NULL")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	deadline := now.AddDate(0, 0, days)
+	expiring := []models.User{}
+	for _, u := range users {
+		if u.ValidUntil.After(now) && !u.ValidUntil.After(deadline) {
+			expiring = append(expiring, u)
+		}
+	}
+	sort.Slice(expiring, func(i, j int) bool {
+		return expiring[i].ValidUntil.Before(*expiring[j].ValidUntil)
+	})
+
+	c.JSON(http.StatusOK, gin.H{"data": expiring})
+}
+
+// normalizeValidity 校验有效期并统一以 UTC 存储
+func normalizeValidity(user *models.User) error {
+	if user.ValidFrom != nil && user.ValidUntil != nil && !user.ValidFrom.Before(*user.ValidUntil) {
+		return fmt.Errorf("生效时间必须早于到期时间")
+	}
+	if user.ValidFrom != nil {
+		t := user.ValidFrom.UTC()
+		user.ValidFrom = &t
+	}
+	if user.ValidUntil != nil {
+		t := user.ValidUntil.UTC()
+		user.ValidUntil = &t
+	}
+	return nil
+}
+
+// userColumns 返回新增和修改用户时写入的列及对应的值，不含密码
+func userColumns(user *models.User) ([]string, []interface{}) {
+	columns := []string{
+		"username", "full_name", "email", "group_id", "custom_routes", "custom_policies", "schedule_id",
+		"valid_from", "valid_until", "max_sessions", "session_limit_policy", "upload_limit", "download_limit",
+		"daily_quota", "monthly_quota", "enabled",
+	}
+	values := []interface{}{
+		user.Username, user.FullName, user.Email, user.GroupID, user.CustomRoutes, user.CustomPolicies, user.ScheduleID,
+		user.ValidFrom, user.ValidUntil, user.MaxSessions, user.SessionLimitPolicy, user.UploadLimit, user.DownloadLimit,
+		user.DailyQuota, user.MonthlyQuota, user.Enabled,
+	}
+	return columns, values
+}
+
 func CreateUser(c *gin.Context) {
 	var user models.User
 	if err := c.ShouldBindJSON(&user); err != nil {
@@ -126,16 +302,57 @@ func CreateUser(c *gin.Context) {
 		return
 	}
 
+	if user.Password == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "密码不能为空"})
+		return
+	}
+
+	if err := vpn.ValidateUsername(user.Username); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := vpn.ValidateUserOverrides(user.CustomRoutes, user.CustomPolicies); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := normalizeValidity(&user); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := vpn.ValidateSessionLimit(user.MaxSessions, user.SessionLimitPolicy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if user.UploadLimit < 0 || user.DownloadLimit < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "带宽限制不能为负数"})
+		return
+	}
+
+	if user.DailyQuota < 0 || user.MonthlyQuota < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "流量配额不能为负数"})
+		return
+	}
+
+	if err := validateScheduleID(user.ScheduleID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "密码加密失败"})
 		return
 	}
 
-	result, err := models.DB.Exec(`
-		INSERT INTO users (username, password, full_name, email, group_id, custom_routes, custom_policies, enabled) 
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
-	`, user.Username, string(hashedPassword), user.FullName, user.Email, user.GroupID, user.CustomRoutes, user.CustomPolicies, user.Enabled)
+	columns, values := userColumns(&user)
+	columns = append(columns, "password")
+	values = append(values, string(hashedPassword))
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	result, err := models.DB.Exec("INSERT INTO users ("+strings.Join(columns, ", ")+") VALUES ("+placeholders+")", values...)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -143,6 +360,8 @@ func CreateUser(c *gin.Context) {
 
 	id, _ := result.LastInsertId()
 	user.ID = int(id)
+	user.Password = ""
+	syncCredentials()
 	c.JSON(http.StatusOK, gin.H{"data": user})
 }
 
@@ -154,31 +373,71 @@ func UpdateUser(c *gin.Context) {
 		return
 	}
 
+	if err := vpn.ValidateUsername(user.Username); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := vpn.ValidateUserOverrides(user.CustomRoutes, user.CustomPolicies); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := normalizeValidity(&user); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := vpn.ValidateSessionLimit(user.MaxSessions, user.SessionLimitPolicy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if user.UploadLimit < 0 || user.DownloadLimit < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "带宽限制不能为负数"})
+		return
+	}
+
+	if user.DailyQuota < 0 || user.MonthlyQuota < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "流量配额不能为负数"})
+		return
+	}
+
+	if err := validateScheduleID(user.ScheduleID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var previousUsername string
+	if err := models.DB.QueryRow("SELECT username FROM users WHERE id=?", id).Scan(&previousUsername); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+		return
+	}
+
+	columns, values := userColumns(&user)
+	// 密码留空表示不修改
 	if user.Password != "" {
 		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "密码加密失败"})
 			return
 		}
-		_, err = models.DB.Exec(`
-			UPDATE users 
-			SET username=?, password=?, full_name=?, email=?, group_id=?, custom_routes=?, custom_policies=?, enabled=?, updated_at=CURRENT_TIMESTAMP 
-			WHERE id=?
-		`, user.Username, string(hashedPassword), user.FullName, user.Email, user.GroupID, user.CustomRoutes, user.CustomPolicies, user.Enabled, id)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-	} else {
-		_, err := models.DB.Exec(`
-			UPDATE users 
-			SET username=?, full_name=?, email=?, group_id=?, custom_routes=?, custom_policies=?, enabled=?, updated_at=CURRENT_TIMESTAMP 
-			WHERE id=?
-		`, user.Username, user.FullName, user.Email, user.GroupID, user.CustomRoutes, user.CustomPolicies, user.Enabled, id)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
+		columns = append(columns, "password")
+		values = append(values, string(hashedPassword))
+	}
+	assignments := strings.Join(columns, "=?, ") + "=?, updated_at=CURRENT_TIMESTAMP"
+	if _, err := models.DB.Exec("UPDATE users SET "+assignments+" WHERE id=?", append(values, id)...); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	syncCredentials()
+	if !user.Enabled {
+		vpn.RevokeUserSessions(previousUsername, "用户已被禁用")
+	} else if previousUsername != user.Username {
+		vpn.RevokeUserSessions(previousUsername, "用户名已变更")
+	} else if (user.ValidFrom != nil && time.Now().Before(*user.ValidFrom)) || (user.ValidUntil != nil && !time.Now().Before(*user.ValidUntil)) {
+		vpn.RevokeUserSessions(previousUsername, "账号不在有效期内")
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "更新成功"})
@@ -186,19 +445,44 @@ func UpdateUser(c *gin.Context) {
 
 func DeleteUser(c *gin.Context) {
 	id := c.Param("id")
+	var username string
+	if err := models.DB.QueryRow("SELECT username FROM users WHERE id=?", id).Scan(&username); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+		return
+	}
+
 	_, err := models.DB.Exec("DELETE FROM users WHERE id=?", id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	syncCredentials()
+	vpn.RevokeUserSessions(username, "用户已被删除")
+
 	c.JSON(http.StatusOK, gin.H{"message": "删除成功"})
 }
 
+func applyGroupChange(trigger string) {
+	if status := vpn.ApplyConfig(trigger); !status.Success {
+		log.Printf("用户组变更后应用配置失败: %s", status.Error)
+	}
+	vpn.RefreshFirewall()
+}
+
+func syncCredentials() {
+	if err := vpn.SyncCredentials(); err != nil {
+		log.Printf("同步VPN用户凭据失败: %v", err)
+	}
+	vpn.RefreshFirewall()
+}
+
 func GetOnlineUsers(c *gin.Context) {
 	rows, err := models.DB.Query(`
-		SELECT id, username, group_name, mac, virtual_ip, remote_ip, protocol, virtual_dev, mtu, 
-		       upload_speed, download_speed, total_upload, total_download, connected_at 
+		SELECT id, COALESCE(ocserv_id, 0), COALESCE(session_id, ''), username, COALESCE(group_name, ''),
+		       COALESCE(mac, ''), COALESCE(virtual_ip, ''), COALESCE(remote_ip, ''), COALESCE(protocol, ''),
+		       COALESCE(virtual_dev, ''), COALESCE(mtu, 0), upload_speed, download_speed, total_upload, total_download,
+		       COALESCE(user_agent, ''), COALESCE(hostname, ''), connected_at 
 		FROM online_users
 		ORDER BY connected_at DESC
 	`)
@@ -211,9 +495,9 @@ func GetOnlineUsers(c *gin.Context) {
 	var users []models.OnlineUser
 	for rows.Next() {
 		var u models.OnlineUser
-		if err := rows.Scan(&u.ID, &u.Username, &u.GroupName, &u.MAC, &u.VirtualIP, &u.RemoteIP, 
+		if err := rows.Scan(&u.ID, &u.OCServID, &u.SessionID, &u.Username, &u.GroupName, &u.MAC, &u.VirtualIP, &u.RemoteIP, 
 			&u.Protocol, &u.VirtualDev, &u.MTU, &u.UploadSpeed, &u.DownloadSpeed, 
-			&u.TotalUpload, &u.TotalDownload, &u.ConnectedAt); err != nil {
+			&u.TotalUpload, &u.TotalDownload, &u.UserAgent, &u.Hostname, &u.ConnectedAt); err != nil {
 			continue
 		}
 		users = append(users, u)
@@ -225,30 +509,30 @@ func GetOnlineUsers(c *gin.Context) {
 func DisconnectUser(c *gin.Context) {
 	id := c.Param("id")
 	
-	var username string
-	var groupID int
-	var virtualIP string
+	var username, remoteIP string
+	var ocservID int
 	err := models.DB.QueryRow(`
-		SELECT u.username, u.group_id, ou.virtual_ip 
-		FROM online_users ou
-		JOIN users u ON ou.username = u.username
-		WHERE ou.id = ?
-	`, id).Scan(&username, &groupID, &virtualIP)
+		SELECT username, COALESCE(ocserv_id, 0), COALESCE(remote_ip, '') FROM online_users WHERE id = ?
+	`, id).Scan(&username, &ocservID, &remoteIP)
 	
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "未找到在线用户"})
 		return
 	}
 
-	vpn.DisconnectUserByOCCtl(username)
-
-	_, err = models.DB.Exec("DELETE FROM online_users WHERE id=?", id)
+	vpn.MarkDisconnectReason(username, "管理员断开: "+c.GetString("username"))
+	if ocservID > 0 {
+		err = vpn.DisconnectSessionByOCCtl(ocservID)
+	} else {
+		err = vpn.DisconnectUserByOCCtl(username)
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "断开会话失败: " + err.Error()})
 		return
 	}
 
-	models.ReleaseIP(username, groupID)
+	onlineID, _ := strconv.Atoi(id)
+	vpn.CloseOnlineSession(onlineID, ocservID, username, remoteIP)
 
 	c.JSON(http.StatusOK, gin.H{"message": "断开成功"})
 }
@@ -328,10 +612,14 @@ func GetAccessLogs(c *gin.Context) {
 }
 
 func GetSystemStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"data": collectSystemStats()})
+}
+
+func collectSystemStats() models.SystemStats {
 	var onlineCount int
 	models.DB.QueryRow("SELECT COUNT(*) FROM online_users").Scan(&onlineCount)
 
-	stats := models.SystemStats{
+	return models.SystemStats{
 		CPUUsage:           getCPUUsage(),
 		MemoryUsage:        getMemoryUsage(),
 		DiskUsage:          getDiskUsage(),
@@ -339,17 +627,19 @@ func GetSystemStats(c *gin.Context) {
 		OnlineUsers:        onlineCount,
 		Uptime:             getUptime(),
 	}
-
-	c.JSON(http.StatusOK, gin.H{"data": stats})
 }
 
 var (
 	lastCPUTotal uint64
 	lastCPUIdle  uint64
+	cpuMu        sync.Mutex
 	startTime    = time.Now()
 )
 
 func getCPUUsage() float64 {
+	cpuMu.Lock()
+	defer cpuMu.Unlock()
+
 	file, err := os.Open("/proc/stat")
 	if err != nil {
 		return 0.0
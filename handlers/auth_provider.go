@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"edge_server/auth"
+	"edge_server/models"
+	"edge_server/vpn"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// isSecretSetting 判断配置项是否为密码类字段，这类字段不会返回给前端
+func isSecretSetting(key string) bool {
+	key = strings.ToLower(key)
+	return strings.HasSuffix(key, "password") || strings.HasSuffix(key, "secret")
+}
+
+// maskSecrets 清空配置中的密码类字段，包括 RADIUS 服务器列表等嵌套结构
+func maskSecrets(value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if isSecretSetting(key) {
+				v[key] = ""
+			} else {
+				maskSecrets(item)
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			maskSecrets(item)
+		}
+	}
+}
+
+func maskProviderSecrets(p *models.AuthProvider) {
+	var settings map[string]interface{}
+	if json.Unmarshal(p.Settings, &settings) != nil {
+		return
+	}
+	maskSecrets(settings)
+	p.Settings, _ = json.Marshal(settings)
+}
+
+// keepSecrets 将 old 中的密码类字段填回 value 中为空的对应字段。
+// 列表元素带有 address 时按地址对应，避免删除或调整服务器顺序后错用其他服务器的密钥
+func keepSecrets(value, old interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		o, ok := old.(map[string]interface{})
+		if !ok {
+			return
+		}
+		for key, oldItem := range o {
+			item, exists := v[key]
+			if !isSecretSetting(key) {
+				if exists {
+					keepSecrets(item, oldItem)
+				}
+				continue
+			}
+			if !exists || item == "" {
+				v[key] = oldItem
+			}
+		}
+	case []interface{}:
+		o, ok := old.([]interface{})
+		if !ok {
+			return
+		}
+		for i, item := range v {
+			if oldItem := matchSettingItem(item, o, i); oldItem != nil {
+				keepSecrets(item, oldItem)
+			}
+		}
+	}
+}
+
+func matchSettingItem(item interface{}, old []interface{}, index int) interface{} {
+	m, ok := item.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	address, ok := m["address"]
+	if !ok {
+		if index < len(old) {
+			return old[index]
+		}
+		return nil
+	}
+	for _, oldItem := range old {
+		if o, ok := oldItem.(map[string]interface{}); ok && o["address"] == address {
+			return o
+		}
+	}
+	return nil
+}
+
+// keepProviderSecrets 提交的密码类字段为空时沿用已保存的值
+func keepProviderSecrets(p *models.AuthProvider, old *models.AuthProvider) error {
+	var settings, oldSettings map[string]interface{}
+	if len(p.Settings) == 0 {
+		p.Settings = json.RawMessage("{}")
+	}
+	if err := json.Unmarshal(p.Settings, &settings); err != nil {
+		return errors.New("认证源配置格式错误")
+	}
+	if old == nil || json.Unmarshal(old.Settings, &oldSettings) != nil {
+		return nil
+	}
+	keepSecrets(settings, oldSettings)
+	p.Settings, _ = json.Marshal(settings)
+	return nil
+}
+
+// bindAuthProvider 解析并校验请求中的认证源，id 不为 0 时保留已保存的密码
+func bindAuthProvider(c *gin.Context, id int) (*models.AuthProvider, error) {
+	var provider models.AuthProvider
+	if err := c.ShouldBindJSON(&provider); err != nil {
+		return nil, err
+	}
+	provider.ID = id
+
+	var old *models.AuthProvider
+	if id > 0 {
+		var err error
+		if old, err = models.GetAuthProvider(id); err != nil {
+			return nil, errors.New("认证源不存在")
+		}
+	}
+	if err := keepProviderSecrets(&provider, old); err != nil {
+		return nil, err
+	}
+	if err := provider.Validate(); err != nil {
+		return nil, err
+	}
+	if _, err := auth.New(&provider); err != nil {
+		return nil, err
+	}
+	return &provider, nil
+}
+
+func GetAuthProviders(c *gin.Context) {
+	providers, err := models.ListAuthProviders()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for i := range providers {
+		maskProviderSecrets(&providers[i])
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": providers})
+}
+
+func CreateAuthProvider(c *gin.Context) {
+	provider, err := bindAuthProvider(c, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := models.SaveAuthProvider(provider); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	vpn.InvalidateRADIUSAccounters()
+
+	maskProviderSecrets(provider)
+	c.JSON(http.StatusOK, gin.H{"data": provider})
+}
+
+func UpdateAuthProvider(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的ID"})
+		return
+	}
+
+	provider, err := bindAuthProvider(c, id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := models.SaveAuthProvider(provider); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	vpn.InvalidateRADIUSAccounters()
+
+	c.JSON(http.StatusOK, gin.H{"message": "更新成功"})
+}
+
+func DeleteAuthProvider(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的ID"})
+		return
+	}
+
+	if err := models.DeleteAuthProvider(id); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	vpn.InvalidateRADIUSAccounters()
+
+	c.JSON(http.StatusOK, gin.H{"message": "删除成功"})
+}
+
+// TestAuthProvider 使用提交的配置测试认证源，无需先保存；提供测试账号时同时校验账号并返回组映射结果
+func TestAuthProvider(c *gin.Context) {
+	var req struct {
+		models.AuthProvider
+		TestUsername string `json:"test_username"`
+		TestPassword string `json:"test_password"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	provider := req.AuthProvider
+	var old *models.AuthProvider
+	if provider.ID > 0 {
+		old, _ = models.GetAuthProvider(provider.ID)
+	}
+	if err := keepProviderSecrets(&provider, old); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	impl, err := auth.New(&provider)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := impl.Test(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.TestUsername == "" {
+		c.JSON(http.StatusOK, gin.H{"message": "连接成功"})
+		return
+	}
+
+	identity, err := impl.Authenticate(req.TestUsername, req.TestPassword)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "连接成功，但测试账号认证失败: " + err.Error()})
+		return
+	}
+	result := auth.MapIdentity(&provider, identity)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "连接成功，测试账号认证通过",
+		"data": gin.H{
+			"identity":   identity,
+			"user_group": result.UserGroup,
+			"role":       result.Role,
+		},
+	})
+}
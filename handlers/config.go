@@ -2,8 +2,14 @@ package handlers
 
 import (
 	"edge_server/models"
+	"edge_server/vpn"
+	"fmt"
+	"net"
 	"net/http"
+	"regexp"
 	"strconv"
+	"strings"
+	"unicode"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
@@ -27,26 +33,32 @@ func UpdateSystemConfig(c *gin.Context) {
 	}
 
 	validKeys := map[string]bool{
-		"default_ip_pool": true,
-		"default_dns1":    true,
-		"default_dns2":    true,
-		"default_mtu":     true,
-		"max_clients":     true,
-		"idle_timeout":    true,
-		"vpn_domain":      true,
-		"vpn_device":      true,
+		"default_ip_pool":  true,
+		"default_dns1":     true,
+		"default_dns2":     true,
+		"default_mtu":      true,
+		"max_clients":      true,
+		"idle_timeout":     true,
+		"max_same_clients": true,
+		"quota_reset_day":  true,
+		"vpn_domain":       true,
+		"vpn_device":       true,
+		"console_sso_only": true,
 	}
 
 	for key, value := range req {
 		if !validKeys[key] {
 			continue
 		}
+		if err := validateConfigValue(key, value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
 
-		if key == "default_mtu" || key == "max_clients" || key == "idle_timeout" {
-			if _, err := strconv.Atoi(value); err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": key + " 必须是数字"})
-				return
-			}
+	for key, value := range req {
+		if !validKeys[key] {
+			continue
 		}
 
 		if err := models.SetConfig(key, value); err != nil {
@@ -55,7 +67,76 @@ func UpdateSystemConfig(c *gin.Context) {
 		}
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "配置更新成功，重启服务后生效"})
+	username, _ := c.Get("username")
+	status := vpn.ApplyConfig(fmt.Sprintf("系统配置更新 (%v)", username))
+	if !status.Success {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "配置已保存，但应用到VPN服务失败: " + status.Error, "apply": status})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "配置更新成功，已应用到VPN服务", "apply": status})
+}
+
+var (
+	vpnDomainPattern = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?\.)*[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+	vpnDevicePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,15}$`)
+)
+
+// validateConfigValue 校验系统配置项，配置值会写入 ocserv.conf，任何配置都不允许包含换行等控制字符
+func validateConfigValue(key, value string) error {
+	if strings.IndexFunc(value, unicode.IsControl) >= 0 {
+		return fmt.Errorf("%s 不能包含换行或控制字符", key)
+	}
+
+	switch key {
+	case "default_mtu", "max_clients", "idle_timeout", "max_same_clients":
+		if n, err := strconv.Atoi(value); err != nil || n < 0 {
+			return fmt.Errorf("%s 必须是数字", key)
+		}
+	case "quota_reset_day":
+		if n, err := strconv.Atoi(value); err != nil || n < 1 || n > 28 {
+			return fmt.Errorf("月流量配额重置日必须在 1-28 之间")
+		}
+	case "default_ip_pool":
+		if _, _, err := net.ParseCIDR(value); err != nil {
+			return fmt.Errorf("IP地址池格式错误: %s", value)
+		}
+	case "console_sso_only":
+		if value != "0" && value != "1" {
+			return fmt.Errorf("console_sso_only 只能为 0 或 1")
+		}
+		if value == "1" && models.CountBreakGlassAdmins(0) == 0 {
+			return fmt.Errorf("启用仅单点登录前至少需要一个启用的本地应急超级管理员")
+		}
+	case "default_dns1", "default_dns2":
+		if value != "" && net.ParseIP(value) == nil {
+			return fmt.Errorf("DNS地址格式错误: %s", value)
+		}
+	case "vpn_domain":
+		if len(value) > 253 || !vpnDomainPattern.MatchString(value) {
+			return fmt.Errorf("VPN域名格式错误: %s", value)
+		}
+	case "vpn_device":
+		if !vpnDevicePattern.MatchString(value) {
+			return fmt.Errorf("虚拟网卡名称只能包含字母、数字、_ . -，最长 15 个字符")
+		}
+	}
+	return nil
+}
+
+func GetConfigApplyStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"data": vpn.GetApplyStatus()})
+}
+
+func ApplyConfig(c *gin.Context) {
+	username, _ := c.Get("username")
+	status := vpn.ApplyConfig(fmt.Sprintf("手动应用 (%v)", username))
+	if !status.Success {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "应用配置失败: " + status.Error, "data": status})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "配置已应用", "data": status})
 }
 
 func ChangePassword(c *gin.Context) {
@@ -76,13 +157,14 @@ func ChangePassword(c *gin.Context) {
 	}
 
 	var storedPassword string
-	err := models.DB.QueryRow("SELECT password FROM users WHERE username=?", username).Scan(&storedPassword)
+	err := models.DB.QueryRow("SELECT password FROM admins WHERE username=?", username).Scan(&storedPassword)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询用户失败"})
 		return
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(storedPassword), []byte(req.OldPassword)); err != nil {
+		models.LogAuthEvent(username.(string), c.ClientIP(), models.AuthActionPasswordChange, false, "原密码错误")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "原密码错误"})
 		return
 	}
@@ -93,12 +175,14 @@ func ChangePassword(c *gin.Context) {
 		return
 	}
 
-	_, err = models.DB.Exec("UPDATE users SET password=?, updated_at=CURRENT_TIMESTAMP WHERE username=?", 
+	_, err = models.DB.Exec("UPDATE admins SET password=?, updated_at=CURRENT_TIMESTAMP WHERE username=?", 
 		string(hashedPassword), username)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新密码失败"})
 		return
 	}
 
+	models.LogAuthEvent(username.(string), c.ClientIP(), models.AuthActionPasswordChange, true, "密码修改成功")
+
 	c.JSON(http.StatusOK, gin.H{"message": "密码修改成功"})
 }
\ No newline at end of file
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"bytes"
+	"edge_server/models"
+	"edge_server/vpn"
+	"encoding/base64"
+	"image/png"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 自助页面使用 VPN 账号密码认证，失败次数超过限制后暂时拒绝该用户名或来源地址的请求，防止暴力猜测密码。
+// 成功的请求不清零计数，避免知道密码的人借此无限次猜测动态口令
+const (
+	vpnOTPFailureWindow   = 15 * time.Minute
+	vpnOTPMaxUserFailures = 5
+	vpnOTPMaxIPFailures   = 20
+)
+
+type vpnOTPRequest struct {
+	Username   string `json:"username" binding:"required"`
+	Password   string `json:"password" binding:"required"`
+	Code       string `json:"code"`
+	EnrollCode string `json:"enroll_code"`
+}
+
+type otpFailureCounter struct {
+	Count   int
+	ResetAt time.Time
+}
+
+var (
+	otpFailureMu sync.Mutex
+	otpFailures  = make(map[string]*otpFailureCounter)
+)
+
+func otpFailureKeys(username, remoteIP string) (string, string) {
+	return "user:" + username, "ip:" + remoteIP
+}
+
+// vpnOTPThrottled 判断用户名或来源地址的失败次数是否已达上限
+func vpnOTPThrottled(username, remoteIP string) bool {
+	otpFailureMu.Lock()
+	defer otpFailureMu.Unlock()
+
+	now := time.Now()
+	userKey, ipKey := otpFailureKeys(username, remoteIP)
+	if f, exists := otpFailures[userKey]; exists && now.Before(f.ResetAt) && f.Count >= vpnOTPMaxUserFailures {
+		return true
+	}
+	if f, exists := otpFailures[ipKey]; exists && now.Before(f.ResetAt) && f.Count >= vpnOTPMaxIPFailures {
+		return true
+	}
+	return false
+}
+
+func recordVPNOTPFailure(username, remoteIP string) {
+	otpFailureMu.Lock()
+	defer otpFailureMu.Unlock()
+
+	now := time.Now()
+	for key, f := range otpFailures {
+		if !now.Before(f.ResetAt) {
+			delete(otpFailures, key)
+		}
+	}
+	userKey, ipKey := otpFailureKeys(username, remoteIP)
+	for _, key := range []string{userKey, ipKey} {
+		f, exists := otpFailures[key]
+		if !exists {
+			f = &otpFailureCounter{ResetAt: now.Add(vpnOTPFailureWindow)}
+			otpFailures[key] = f
+		}
+		f.Count++
+	}
+}
+
+// bindVPNOTPRequest 解析请求并检查失败次数，返回 false 时已写入响应
+func bindVPNOTPRequest(c *gin.Context, req *vpnOTPRequest) bool {
+	if err := c.ShouldBindJSON(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return false
+	}
+	if vpnOTPThrottled(req.Username, c.ClientIP()) {
+		models.LogAuthEvent(req.Username, c.ClientIP(), models.AuthActionVPNOTPEnroll, false, "失败次数过多，请求被拒绝")
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "失败次数过多，请稍后再试"})
+		return false
+	}
+	return true
+}
+
+// vpnOTPFailed 记录失败的请求并返回错误
+func vpnOTPFailed(c *gin.Context, req *vpnOTPRequest, status int, err error) {
+	recordVPNOTPFailure(req.Username, c.ClientIP())
+	models.LogAuthEvent(req.Username, c.ClientIP(), models.AuthActionVPNOTPEnroll, false, err.Error())
+	c.JSON(status, gin.H{"error": err.Error()})
+}
+
+// GetVPNOTPStatus 供 VPN 用户自助页面查询动态口令绑定状态，使用 VPN 账号密码认证
+func GetVPNOTPStatus(c *gin.Context) {
+	var req vpnOTPRequest
+	if !bindVPNOTPRequest(c, &req) {
+		return
+	}
+
+	enabled, required, err := vpn.UserOTPStatus(req.Username, req.Password)
+	if err != nil {
+		vpnOTPFailed(c, &req, http.StatusForbidden, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"enabled": enabled, "required": required}})
+}
+
+// SetupVPNOTP 生成新的动态口令密钥和二维码，需调用 EnableVPNOTP 确认后才会生效
+func SetupVPNOTP(c *gin.Context) {
+	var req vpnOTPRequest
+	if !bindVPNOTPRequest(c, &req) {
+		return
+	}
+
+	key, err := vpn.BeginOTPEnrollment(req.Username, req.Password, req.Code, req.EnrollCode)
+	if err != nil {
+		vpnOTPFailed(c, &req, http.StatusForbidden, err)
+		return
+	}
+
+	img, err := key.Image(200, 200)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成二维码失败"})
+		return
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成二维码失败"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{
+		"secret":  key.Secret(),
+		"uri":     key.URL(),
+		"qr_code": "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()),
+	}})
+}
+
+// EnableVPNOTP 校验新密钥的首个动态口令后完成绑定
+func EnableVPNOTP(c *gin.Context) {
+	var req vpnOTPRequest
+	if !bindVPNOTPRequest(c, &req) {
+		return
+	}
+	if req.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+
+	if err := vpn.ConfirmOTPEnrollment(req.Username, req.Password, req.Code, req.EnrollCode); err != nil {
+		vpnOTPFailed(c, &req, http.StatusBadRequest, err)
+		return
+	}
+
+	models.LogAuthEvent(req.Username, c.ClientIP(), models.AuthActionVPNOTPEnroll, true, "绑定动态口令")
+	syncCredentials()
+
+	c.JSON(http.StatusOK, gin.H{"message": "动态口令已绑定，连接 VPN 时请在密码后直接输入 6 位动态口令"})
+}
+
+// ResetUserOTP 清除指定用户的动态口令绑定，用于设备丢失后重新绑定，同时发放新的绑定码
+func ResetUserOTP(c *gin.Context) {
+	var username string
+	if err := models.DB.QueryRow("SELECT username FROM users WHERE id=?", c.Param("id")).Scan(&username); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+		return
+	}
+
+	if err := vpn.ResetUserOTP(username); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	models.LogAuthEvent(username, c.ClientIP(), models.AuthActionVPNOTPReset, true,
+		"动态口令已被管理员 "+c.GetString("username")+" 重置")
+	syncCredentials()
+
+	code, err := vpn.IssueOTPEnrollCode(username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "动态口令已重置，但生成绑定码失败: " + err.Error()})
+		return
+	}
+	models.LogAuthEvent(username, c.ClientIP(), models.AuthActionVPNOTPCode, true,
+		"管理员 "+c.GetString("username")+" 发放了绑定码")
+
+	c.JSON(http.StatusOK, gin.H{"message": "动态口令已重置，该用户可使用绑定码重新绑定", "data": gin.H{"enroll_code": code}})
+}
+
+// IssueUserOTPEnrollCode 为尚未绑定动态口令的用户发放一次性绑定码，用户首次绑定时需要输入
+func IssueUserOTPEnrollCode(c *gin.Context) {
+	var username string
+	var enabled bool
+	err := models.DB.QueryRow("SELECT username, COALESCE(otp_enabled, 0) FROM users WHERE id=?", c.Param("id")).Scan(&username, &enabled)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+		return
+	}
+	if enabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "该用户已绑定动态口令，如需重新绑定请先重置"})
+		return
+	}
+
+	code, err := vpn.IssueOTPEnrollCode(username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	models.LogAuthEvent(username, c.ClientIP(), models.AuthActionVPNOTPCode, true,
+		"管理员 "+c.GetString("username")+" 发放了绑定码")
+
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"enroll_code": code}})
+}
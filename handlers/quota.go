@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"edge_server/models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetQuotaUsage 返回用户当前周期的流量配额与用量，可按 username 过滤
+func GetQuotaUsage(c *gin.Context) {
+	usages, err := models.LoadQuotaUsage(time.Now(), c.Query("username"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": usages})
+}
+
+// GetUserTrafficUsage 返回用户最近 N 天的每日流量
+func GetUserTrafficUsage(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil || days < 1 || days > 366 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "days 参数无效"})
+		return
+	}
+
+	var username string
+	if err := models.DB.QueryRow("SELECT username FROM users WHERE id=?", c.Param("id")).Scan(&username); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+		return
+	}
+
+	history, err := models.GetTrafficUsageHistory(username, time.Now().AddDate(0, 0, -(days-1)))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	usages, err := models.LoadQuotaUsage(time.Now(), username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var quota *models.QuotaUsage
+	if len(usages) > 0 {
+		quota = &usages[0]
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": history, "quota": quota})
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"edge_server/models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetSessionHistory(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 500 {
+		pageSize = 20
+	}
+
+	filter := models.SessionHistoryFilter{
+		Username: c.Query("username"),
+		Group:    c.Query("group"),
+		RemoteIP: c.Query("remote_ip"),
+		Limit:    pageSize,
+		Offset:   (page - 1) * pageSize,
+	}
+
+	var err error
+	if filter.Start, err = parseTimeParam(c.Query("start")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "start 时间格式错误"})
+		return
+	}
+	if filter.End, err = parseTimeParam(c.Query("end")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "end 时间格式错误"})
+		return
+	}
+
+	history, total, err := models.QuerySessionHistory(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":     history,
+		"total":    total,
+		"page":     page,
+		"pageSize": pageSize,
+	})
+}
+
+func parseTimeParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(unix, 0), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, strconv.ErrSyntax
+}
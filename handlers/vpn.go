@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"edge_server/vpn"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetVPNStatus(c *gin.Context) {
+	server := vpn.DefaultServer()
+	if server == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "VPN服务未初始化"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": server.Status()})
+}
+
+func StartVPN(c *gin.Context) {
+	controlVPN(c, "启动", func(server *vpn.OCServServer) error { return server.Start() })
+}
+
+func StopVPN(c *gin.Context) {
+	controlVPN(c, "停止", func(server *vpn.OCServServer) error { return server.Stop() })
+}
+
+func RestartVPN(c *gin.Context) {
+	controlVPN(c, "重启", func(server *vpn.OCServServer) error { return server.Restart() })
+}
+
+func controlVPN(c *gin.Context, action string, fn func(*vpn.OCServServer) error) {
+	server := vpn.DefaultServer()
+	if server == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "VPN服务未初始化"})
+		return
+	}
+
+	username, _ := c.Get("username")
+	if err := fn(server); err != nil {
+		log.Printf("管理员 %v %s VPN服务失败: %v", username, action, err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": action + "失败: " + err.Error(), "data": server.Status()})
+		return
+	}
+
+	log.Printf("管理员 %v %s了VPN服务", username, action)
+	c.JSON(http.StatusOK, gin.H{"message": "VPN服务已" + action, "data": server.Status()})
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"edge_server/middleware"
+	"edge_server/vpn"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+type wsMessage struct {
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+type wsClient struct {
+	conn      *websocket.Conn
+	send      chan []byte
+	sessionID int
+}
+
+type wsHub struct {
+	mu      sync.RWMutex
+	clients map[*wsClient]bool
+}
+
+const (
+	wsWriteTimeout  = 10 * time.Second
+	wsPongTimeout   = 60 * time.Second
+	wsPingInterval  = 30 * time.Second
+	wsStatsInterval = 5 * time.Second
+
+	// 会话被注销、管理员被停用或失去权限后，最迟在该间隔内断开推送连接
+	wsSessionCheckInterval = 15 * time.Second
+)
+
+var (
+	hub = &wsHub{clients: make(map[*wsClient]bool)}
+
+	upgrader = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 4096,
+		Subprotocols:    []string{middleware.WebSocketProtocol},
+	}
+)
+
+func StartWebSocketHub() {
+	_, events := vpn.Events.Subscribe(256)
+	ticker := time.NewTicker(wsStatsInterval)
+
+	go func() {
+		for {
+			select {
+			case event := <-events:
+				if hub.count() == 0 {
+					continue
+				}
+				hub.broadcast(wsMessage{Type: string(event.Type), Time: event.Time, Data: event})
+			case <-ticker.C:
+				if hub.count() == 0 {
+					continue
+				}
+				hub.broadcast(wsMessage{Type: "system_stats", Time: time.Now(), Data: collectSystemStats()})
+			}
+		}
+	}()
+}
+
+func (h *wsHub) count() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients)
+}
+
+func (h *wsHub) register(client *wsClient) {
+	h.mu.Lock()
+	h.clients[client] = true
+	h.mu.Unlock()
+}
+
+func (h *wsHub) unregister(client *wsClient) {
+	h.mu.Lock()
+	if _, exists := h.clients[client]; exists {
+		delete(h.clients, client)
+		close(client.send)
+	}
+	h.mu.Unlock()
+}
+
+func (h *wsHub) broadcast(msg wsMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("序列化推送消息失败: %v", err)
+		return
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for client := range h.clients {
+		select {
+		case client.send <- data:
+		default:
+		}
+	}
+}
+
+func ServeWebSocket(c *gin.Context) {
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Printf("WebSocket 升级失败: %v", err)
+		return
+	}
+
+	client := &wsClient{conn: conn, send: make(chan []byte, 64), sessionID: c.GetInt("session_id")}
+	hub.register(client)
+
+	if data, err := json.Marshal(wsMessage{Type: "system_stats", Time: time.Now(), Data: collectSystemStats()}); err == nil {
+		client.send <- data
+	}
+
+	go client.writePump()
+	client.readPump()
+}
+
+func (c *wsClient) readPump() {
+	defer func() {
+		hub.unregister(c)
+		c.conn.Close()
+	}()
+
+	c.conn.SetReadLimit(4096)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+		return nil
+	})
+
+	for {
+		if _, _, err := c.conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func (c *wsClient) writePump() {
+	ticker := time.NewTicker(wsPingInterval)
+	sessionTicker := time.NewTicker(wsSessionCheckInterval)
+	defer func() {
+		ticker.Stop()
+		sessionTicker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case data, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-sessionTicker.C:
+			if !middleware.SessionAllows(c.sessionID, middleware.PermOnlineRead) {
+				c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+				c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "会话已失效"))
+				return
+			}
+		}
+	}
+}
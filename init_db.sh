@@ -37,6 +37,18 @@ CREATE TABLE IF NOT EXISTS users (
     FOREIGN KEY (group_id) REFERENCES user_groups(id)
 );
 
+CREATE TABLE IF NOT EXISTS admins (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username TEXT NOT NULL UNIQUE,
+    password TEXT NOT NULL,
+    full_name TEXT,
+    email TEXT,
+    role TEXT NOT NULL DEFAULT 'auditor',
+    enabled INTEGER DEFAULT 1,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
 CREATE TABLE IF NOT EXISTS online_users (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     username TEXT NOT NULL,
@@ -78,6 +90,7 @@ CREATE TABLE IF NOT EXISTS access_logs (
 );
 
 CREATE INDEX IF NOT EXISTS idx_users_username ON users(username);
+CREATE INDEX IF NOT EXISTS idx_admins_username ON admins(username);
 CREATE INDEX IF NOT EXISTS idx_online_users_username ON online_users(username);
 CREATE INDEX IF NOT EXISTS idx_auth_logs_username ON auth_logs(username);
 CREATE INDEX IF NOT EXISTS idx_auth_logs_created ON auth_logs(created_at);
@@ -87,8 +100,8 @@ CREATE INDEX IF NOT EXISTS idx_access_logs_created ON access_logs(created_at);
 INSERT INTO user_groups (name, description, routes, policies) 
 VALUES ('默认组', '系统默认用户组', '192.168.10.0/24,10.0.0.0/8', '{"allow_internet":true,"allow_lan":true}');
 
-INSERT INTO users (username, password, full_name, email, group_id, enabled) 
-VALUES ('admin', '\$2a\$10\$N9qo8uLOickgx2ZMRZoMye0J8YAR1WjxKRkzBCG.iHXE7BQOBZCVW', '管理员', 'admin@example.com', 1, 1);
+INSERT INTO admins (username, password, full_name, email, role, enabled) 
+VALUES ('admin', '\$2a\$10\$N9qo8uLOickgx2ZMRZoMye0J8YAR1WjxKRkzBCG.iHXE7BQOBZCVW', '管理员', 'admin@example.com', 'super_admin', 1);
 
 EOF
 
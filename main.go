@@ -3,12 +3,16 @@ package main
 import (
 	"bufio"
 	"embed"
+	"edge_server/auth"
 	"edge_server/handlers"
 	"edge_server/middleware"
 	"edge_server/models"
 	"edge_server/vpn"
+	"flag"
+	"io"
 	"io/fs"
 	"log"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -32,6 +36,7 @@ type Config struct {
 	MTU          int
 	MaxClients   int
 	IdleTimeout  int
+	VPNAuthMode  string
 }
 
 func loadConfig(configPath string) (*Config, error) {
@@ -46,6 +51,7 @@ func loadConfig(configPath string) (*Config, error) {
 		MTU:         1400,
 		MaxClients:  100,
 		IdleTimeout: 3600,
+		VPNAuthMode: vpn.AuthModePAM,
 	}
 
 	file, err := os.Open(configPath)
@@ -87,6 +93,8 @@ func loadConfig(configPath string) (*Config, error) {
 				config.VPNPort = value
 			case "db_path":
 				config.DBPath = value
+			case "vpn_auth_mode":
+				config.VPNAuthMode = value
 			}
 		case "ssl":
 			switch key {
@@ -164,12 +172,74 @@ func loadConfigFromDB(config *Config) {
 		config.IPPool, config.DNS, config.MTU, config.MaxClients, config.IdleTimeout)
 }
 
+func runAuthHelper() int {
+	username := os.Getenv("PAM_USER")
+	if username == "" && len(os.Args) > 2 {
+		username = os.Args[2]
+	}
+	remoteIP := os.Getenv("PAM_RHOST")
+
+	if pamType := os.Getenv("PAM_TYPE"); pamType != "" && pamType != "auth" {
+		if err := vpn.CheckUserAccount(username); err != nil {
+			log.Printf("账号检查失败 %s: %v", username, err)
+			return 1
+		}
+		return 0
+	}
+
+	input, err := io.ReadAll(io.LimitReader(os.Stdin, 4096))
+	if err != nil {
+		log.Printf("读取密码失败: %v", err)
+		return 1
+	}
+	password := strings.TrimRight(string(input), "\x00\r\n")
+
+	if err := vpn.AuthenticateUser(username, password, remoteIP); err != nil {
+		log.Printf("VPN认证失败 %s: %v", username, err)
+		return 1
+	}
+	return 0
+}
+
+// runRADIUSStub 启动本地 RADIUS 测试桩，账号参数格式为 用户名:密码[:Class1,Class2]
+func runRADIUSStub(args []string) int {
+	flags := flag.NewFlagSet("radius-stub", flag.ExitOnError)
+	authAddr := flags.String("auth", "127.0.0.1:1812", "认证监听地址")
+	acctAddr := flags.String("acct", "127.0.0.1:1813", "计费监听地址")
+	secret := flags.String("secret", "testing123", "共享密钥")
+	flags.Parse(args)
+
+	users := make(map[string]auth.RADIUSStubUser)
+	for _, arg := range flags.Args() {
+		parts := strings.SplitN(arg, ":", 3)
+		if len(parts) < 2 {
+			log.Printf("账号参数格式错误: %s，应为 用户名:密码[:Class1,Class2]", arg)
+			return 2
+		}
+		user := auth.RADIUSStubUser{Password: parts[1]}
+		if len(parts) == 3 && parts[2] != "" {
+			user.Classes = strings.Split(parts[2], ",")
+		}
+		users[parts[0]] = user
+	}
+
+	if err := auth.RunRADIUSStub(*authAddr, *acctAddr, *secret, users); err != nil {
+		log.Printf("RADIUS 测试桩退出: %v", err)
+		return 1
+	}
+	return 0
+}
+
 func main() {
-	execDir, err := os.Executable()
+	if len(os.Args) > 1 && os.Args[1] == "radius-stub" {
+		os.Exit(runRADIUSStub(os.Args[2:]))
+	}
+
+	execPath, err := os.Executable()
 	if err != nil {
 		log.Fatal("获取执行目录失败:", err)
 	}
-	execDir = filepath.Dir(execDir)
+	execDir := filepath.Dir(execPath)
 
 	configPath := filepath.Join(execDir, "server.conf")
 	config, err := loadConfig(configPath)
@@ -181,6 +251,14 @@ func main() {
 	if err := models.InitDB(dbPath); err != nil {
 		log.Fatal("初始化数据库失败:", err)
 	}
+
+	ocservConfigDir := filepath.Join(execDir, "ocserv_config")
+	if len(os.Args) > 1 && os.Args[1] == "auth" {
+		vpn.UseOcservConfigDir(ocservConfigDir, vpn.AuthModePAM)
+		code := runAuthHelper()
+		models.DB.Close()
+		os.Exit(code)
+	}
 	defer models.DB.Close()
 
 	log.Println("数据库初始化成功")
@@ -189,36 +267,60 @@ func main() {
 
 	middleware.CleanupExpiredSessions()
 	vpn.StartSessionCleanup(config.IdleTimeout)
+	vpn.StartAuthEventLogger(config.VPNAuthMode)
 	vpn.StartOCCtlMonitor()
+	vpn.StartFirewall()
+	vpn.StartSessionLimiter()
+	vpn.StartScheduleEnforcer()
+	vpn.StartExpiryEnforcer()
+	vpn.StartQuotaEnforcer()
+	vpn.StartRADIUSAccounting()
+	handlers.StartWebSocketHub()
+
+	vpnConfig := &vpn.OCServConfig{
+		ServerCert:  filepath.Join(execDir, config.ServerCert),
+		ServerKey:   filepath.Join(execDir, config.ServerKey),
+		ListenAddr:  ":" + config.VPNPort,
+		IPPool:      config.IPPool,
+		DNS:         config.DNS,
+		MTU:         config.MTU,
+		MaxClients:  config.MaxClients,
+		IdleTimeout: config.IdleTimeout,
+		ConfigDir:   ocservConfigDir,
+		AuthMode:    config.VPNAuthMode,
+		AuthHelper:  execPath,
+		Domain:      models.GetConfig("vpn_domain", ""),
+		Device:      models.GetConfig("vpn_device", ""),
+	}
+	vpnServer := vpn.NewOCServServer(vpnConfig)
+	vpn.SetDefaultServer(vpnServer)
 
 	go func() {
-		vpnConfig := &vpn.OCServConfig{
-			ServerCert:  filepath.Join(execDir, config.ServerCert),
-			ServerKey:   filepath.Join(execDir, config.ServerKey),
-			ListenAddr:  ":" + config.VPNPort,
-			IPPool:      config.IPPool,
-			DNS:         config.DNS,
-			MTU:         config.MTU,
-			MaxClients:  config.MaxClients,
-			IdleTimeout: config.IdleTimeout,
-			ConfigDir:   filepath.Join(execDir, "ocserv_config"),
-		}
-		vpnServer := vpn.NewOCServServer(vpnConfig)
 		if err := vpnServer.Start(); err != nil {
 			log.Printf("VPN服务启动失败: %v", err)
 		}
 	}()
 
-	router := gin.Default()
+	router := gin.New()
+	router.Use(middleware.Logger(), gin.Recovery())
 
 	staticFS, _ := fs.Sub(staticFiles, "static")
-	router.GET("/", func(c *gin.Context) {
+	serveIndex := func(c *gin.Context) {
 		data, err := fs.ReadFile(staticFS, "index.html")
 		if err != nil {
 			c.String(404, "Not Found")
 			return
 		}
 		c.Data(200, "text/html; charset=utf-8", data)
+	}
+	router.GET("/", serveIndex)
+	// 前端使用 history 路由，直接打开 /login 等页面（如单点登录回调后的跳转）时返回 index.html
+	router.NoRoute(func(c *gin.Context) {
+		if c.Request.Method != http.MethodGet || strings.HasPrefix(c.Request.URL.Path, "/api/") {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Not Found"})
+			return
+		}
+		serveIndex(c)
 	})
 
 	router.GET("/assets/*filepath", func(c *gin.Context) {
@@ -240,31 +342,80 @@ func main() {
 	})
 
 	router.POST("/api/login", middleware.Login)
+	router.POST("/api/login/totp", middleware.LoginTOTP)
+	router.GET("/api/auth/oidc/providers", middleware.GetSSOProviders)
+	router.GET("/api/auth/oidc/:id/login", middleware.OIDCLogin)
+	router.GET("/api/auth/oidc/callback", middleware.OIDCCallback)
+	router.POST("/api/auth/oidc/complete", middleware.OIDCComplete)
+	router.POST("/api/vpn-otp/status", handlers.GetVPNOTPStatus)
+	router.POST("/api/vpn-otp/setup", handlers.SetupVPNOTP)
+	router.POST("/api/vpn-otp/enable", handlers.EnableVPNOTP)
 
 	api := router.Group("/api")
 	api.Use(middleware.AuthRequired())
 	{
-		api.GET("/groups", handlers.GetUserGroups)
-		api.POST("/groups", handlers.CreateUserGroup)
-		api.PUT("/groups/:id", handlers.UpdateUserGroup)
-		api.DELETE("/groups/:id", handlers.DeleteUserGroup)
-
-		api.GET("/users", handlers.GetUsers)
-		api.POST("/users", handlers.CreateUser)
-		api.PUT("/users/:id", handlers.UpdateUser)
-		api.DELETE("/users/:id", handlers.DeleteUser)
-
-		api.GET("/online", handlers.GetOnlineUsers)
-		api.POST("/online/:id/disconnect", handlers.DisconnectUser)
-
-		api.GET("/logs/auth", handlers.GetAuthLogs)
-		api.GET("/logs/access", handlers.GetAccessLogs)
-
-		api.GET("/stats", handlers.GetSystemStats)
-
-		api.GET("/config", handlers.GetSystemConfig)
-		api.PUT("/config", handlers.UpdateSystemConfig)
-		
+		api.GET("/groups", middleware.RequirePermission(middleware.PermGroupRead), handlers.GetUserGroups)
+		api.POST("/groups", middleware.RequirePermission(middleware.PermGroupWrite), handlers.CreateUserGroup)
+		api.PUT("/groups/:id", middleware.RequirePermission(middleware.PermGroupWrite), handlers.UpdateUserGroup)
+		api.DELETE("/groups/:id", middleware.RequirePermission(middleware.PermGroupWrite), handlers.DeleteUserGroup)
+
+		api.GET("/schedules", middleware.RequirePermission(middleware.PermGroupRead), handlers.GetSchedules)
+		api.POST("/schedules", middleware.RequirePermission(middleware.PermGroupWrite), handlers.CreateSchedule)
+		api.PUT("/schedules/:id", middleware.RequirePermission(middleware.PermGroupWrite), handlers.UpdateSchedule)
+		api.DELETE("/schedules/:id", middleware.RequirePermission(middleware.PermGroupWrite), handlers.DeleteSchedule)
+
+		api.GET("/users", middleware.RequirePermission(middleware.PermUserRead), handlers.GetUsers)
+		api.GET("/users/expiring", middleware.RequirePermission(middleware.PermUserRead), handlers.GetExpiringUsers)
+		api.POST("/users", middleware.RequirePermission(middleware.PermUserWrite), handlers.CreateUser)
+		api.PUT("/users/:id", middleware.RequirePermission(middleware.PermUserWrite), handlers.UpdateUser)
+		api.DELETE("/users/:id", middleware.RequirePermission(middleware.PermUserWrite), handlers.DeleteUser)
+		api.GET("/users/:id/traffic", middleware.RequirePermission(middleware.PermUserRead), handlers.GetUserTrafficUsage)
+		api.POST("/users/:id/otp/reset", middleware.RequirePermission(middleware.PermUserWrite), handlers.ResetUserOTP)
+		api.POST("/users/:id/otp/enroll-code", middleware.RequirePermission(middleware.PermUserWrite), handlers.IssueUserOTPEnrollCode)
+		api.GET("/quotas/usage", middleware.RequirePermission(middleware.PermUserRead), handlers.GetQuotaUsage)
+
+		api.GET("/online", middleware.RequirePermission(middleware.PermOnlineRead), handlers.GetOnlineUsers)
+		api.POST("/online/:id/disconnect", middleware.RequirePermission(middleware.PermOnlineManage), handlers.DisconnectUser)
+
+		api.GET("/sessions/history", middleware.RequirePermission(middleware.PermLogRead), handlers.GetSessionHistory)
+
+		api.GET("/logs/auth", middleware.RequirePermission(middleware.PermLogRead), handlers.GetAuthLogs)
+		api.GET("/logs/access", middleware.RequirePermission(middleware.PermLogRead), handlers.GetAccessLogs)
+
+		api.GET("/stats", middleware.RequirePermission(middleware.PermStatsRead), handlers.GetSystemStats)
+		api.GET("/ws", middleware.RequirePermission(middleware.PermOnlineRead), handlers.ServeWebSocket)
+
+		api.GET("/vpn/status", middleware.RequirePermission(middleware.PermStatsRead), handlers.GetVPNStatus)
+		api.POST("/vpn/start", middleware.RequirePermission(middleware.PermConfigWrite), handlers.StartVPN)
+		api.POST("/vpn/stop", middleware.RequirePermission(middleware.PermConfigWrite), handlers.StopVPN)
+		api.POST("/vpn/restart", middleware.RequirePermission(middleware.PermConfigWrite), handlers.RestartVPN)
+
+		api.GET("/config", middleware.RequirePermission(middleware.PermConfigRead), handlers.GetSystemConfig)
+		api.PUT("/config", middleware.RequirePermission(middleware.PermConfigWrite), handlers.UpdateSystemConfig)
+		api.GET("/config/apply", middleware.RequirePermission(middleware.PermConfigRead), handlers.GetConfigApplyStatus)
+		api.POST("/config/apply", middleware.RequirePermission(middleware.PermConfigWrite), handlers.ApplyConfig)
+
+		api.GET("/auth-providers", middleware.RequirePermission(middleware.PermConfigRead), handlers.GetAuthProviders)
+		api.POST("/auth-providers", middleware.RequirePermission(middleware.PermConfigWrite), handlers.CreateAuthProvider)
+		api.PUT("/auth-providers/:id", middleware.RequirePermission(middleware.PermConfigWrite), handlers.UpdateAuthProvider)
+		api.DELETE("/auth-providers/:id", middleware.RequirePermission(middleware.PermConfigWrite), handlers.DeleteAuthProvider)
+		api.POST("/auth-providers/test", middleware.RequirePermission(middleware.PermConfigWrite), handlers.TestAuthProvider)
+
+		api.GET("/admins", middleware.RequirePermission(middleware.PermAdminManage), handlers.GetAdmins)
+		api.POST("/admins", middleware.RequirePermission(middleware.PermAdminManage), handlers.CreateAdmin)
+		api.PUT("/admins/:id", middleware.RequirePermission(middleware.PermAdminManage), handlers.UpdateAdmin)
+		api.DELETE("/admins/:id", middleware.RequirePermission(middleware.PermAdminManage), handlers.DeleteAdmin)
+		api.POST("/admins/:id/totp/reset", middleware.RequirePermission(middleware.PermAdminManage), handlers.ResetAdminTOTP)
+
+		api.GET("/profile", handlers.GetProfile)
+		api.POST("/logout", middleware.Logout)
+		api.GET("/auth/sessions", middleware.GetMySessions)
+		api.DELETE("/auth/sessions/:id", middleware.RevokeSession)
+		api.GET("/auth/totp", middleware.GetTOTPStatus)
+		api.POST("/auth/totp/setup", middleware.SetupTOTP)
+		api.POST("/auth/totp/enable", middleware.EnableTOTP)
+		api.POST("/auth/totp/disable", middleware.DisableTOTP)
+		api.POST("/auth/totp/recovery-codes", middleware.RegenerateRecoveryCodes)
 		api.POST("/change-password", handlers.ChangePassword)
 	}
 
@@ -2,27 +2,22 @@ package middleware
 
 import (
 	"crypto/rand"
+	"edge_server/auth"
 	"edge_server/models"
 	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
 )
 
-type Session struct {
-	Username  string
-	CreatedAt time.Time
-	ExpiresAt time.Time
-}
-
-var (
-	sessions = make(map[string]*Session)
-	mu       sync.RWMutex
-)
+const sessionTTL = 24 * time.Hour
 
 func generateToken() string {
 	b := make([]byte, 32)
@@ -41,44 +36,208 @@ func Login(c *gin.Context) {
 		return
 	}
 
-	var storedPassword string
-	var enabled bool
-	err := models.DB.QueryRow("SELECT password, enabled FROM users WHERE username=?", req.Username).Scan(&storedPassword, &enabled)
-	if err != nil {
+	remoteIP := c.ClientIP()
+	ssoOnly := models.ConsoleSSOOnly()
+
+	// 首次登录的外部管理员在创建账号时已完成认证，一次性口令不能再校验第二次
+	authenticated := false
+	admin, err := models.GetAdminByUsername(req.Username)
+	if err != nil && ssoOnly {
+		models.LogAuthEvent(req.Username, remoteIP, models.AuthActionWebLogin, false, "已启用仅单点登录")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户名或密码错误"})
 		return
 	}
+	if err != nil {
+		// 本地不存在的账号交给启用了控制台登录的外部认证源
+		admin, err = authenticateExternalAdmin(req.Username, req.Password, nil)
+		if err != nil {
+			models.LogAuthEvent(req.Username, remoteIP, models.AuthActionWebLogin, false, err.Error())
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "用户名或密码错误"})
+			return
+		}
+		authenticated = true
+	}
 
-	if !enabled {
+	if !admin.Enabled {
+		models.LogAuthEvent(req.Username, remoteIP, models.AuthActionWebLogin, false, "用户已被禁用")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户已被禁用"})
 		return
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(storedPassword), []byte(req.Password)); err != nil {
+	// 仅单点登录时只有本地应急管理员可以使用密码登录，用于 IdP 不可用时的紧急处理
+	breakGlass := ssoOnly && admin.BreakGlass && admin.AuthProviderID == 0
+	if ssoOnly && !breakGlass {
+		models.LogAuthEvent(req.Username, remoteIP, models.AuthActionWebLogin, false, "已启用仅单点登录")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户名或密码错误"})
 		return
 	}
 
+	if admin.AuthProviderID > 0 && !authenticated {
+		if admin, err = authenticateExternalAdmin(req.Username, req.Password, admin); err != nil {
+			models.LogAuthEvent(req.Username, remoteIP, models.AuthActionWebLogin, false, err.Error())
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "用户名或密码错误"})
+			return
+		}
+	} else if admin.AuthProviderID == 0 {
+		if err := bcrypt.CompareHashAndPassword([]byte(admin.Password), []byte(req.Password)); err != nil {
+			models.LogAuthEvent(req.Username, remoteIP, models.AuthActionWebLogin, false, "密码错误")
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "用户名或密码错误"})
+			return
+		}
+	}
+	if breakGlass {
+		log.Printf("应急管理员 %s 使用本地密码登录控制台 (%s)", admin.Username, remoteIP)
+	}
+
+	settings, err := models.GetAdminTOTP(admin.Username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询二次验证设置失败"})
+		return
+	}
+	if settings.Enabled {
+		c.JSON(http.StatusOK, gin.H{
+			"mfa_required": true,
+			"mfa_token":    newMFAChallenge(admin.Username, remoteIP),
+		})
+		return
+	}
+
+	if breakGlass {
+		issueSession(c, admin, "应急管理员密码登录")
+		return
+	}
+	issueSession(c, admin, "登录成功")
+}
+
+// authenticateExternalAdmin 通过外部认证源校验管理员，角色按组映射确定，首次登录时自动创建账号。
+// existing 为 nil 时依次尝试所有启用了控制台登录的认证源。
+func authenticateExternalAdmin(username, password string, existing *models.Admin) (*models.Admin, error) {
+	var result *auth.Result
+	var err error
+	if existing != nil {
+		result, err = auth.AuthenticateWith(existing.AuthProviderID, auth.ScopeConsole, username, password)
+	} else {
+		result, err = auth.Authenticate(auth.ScopeConsole, username, password)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return saveExternalAdmin(result)
+}
+
+// saveExternalAdmin 按认证结果创建或更新外部管理员，未映射到角色或用户名已被其他账号占用时拒绝登录。
+// 账号按认证源返回的 ExternalID 识别，登录时输入的用户名大小写不同仍对应同一账号。
+func saveExternalAdmin(result *auth.Result) (*models.Admin, error) {
+	if result.Role == "" {
+		return nil, fmt.Errorf("未在认证源 %s 中映射到管理员角色", result.Provider.Name)
+	}
+
+	admin, err := models.SaveExternalAdmin(result.Identity.ExternalID, result.Identity.Username, result.Identity.FullName, result.Identity.Email, result.Role, result.Provider.ID)
+	if errors.Is(err, models.ErrAdminExists) {
+		return nil, fmt.Errorf("已存在同名管理员 %s", result.Identity.Username)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("同步管理员账号失败: %v", err)
+	}
+	return admin, nil
+}
+
+// issueSession 创建管理会话并返回 Token
+func issueSession(c *gin.Context, admin *models.Admin, message string) {
+	remoteIP := c.ClientIP()
 	token := generateToken()
-	session := &Session{
-		Username:  req.Username,
-		CreatedAt: time.Now(),
-		ExpiresAt: time.Now().Add(24 * time.Hour),
+	if _, err := models.CreateAdminSession(token, admin.Username, remoteIP, c.Request.UserAgent(), sessionTTL); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建会话失败"})
+		return
 	}
 
-	mu.Lock()
-	sessions[token] = session
-	mu.Unlock()
+	models.LogAuthEvent(admin.Username, remoteIP, models.AuthActionWebLogin, true, message)
 
 	c.JSON(http.StatusOK, gin.H{
-		"token":    token,
-		"username": req.Username,
+		"token":       token,
+		"username":    admin.Username,
+		"role":        admin.Role,
+		"permissions": RolePermissions(admin.Role),
 	})
 }
 
+func Logout(c *gin.Context) {
+	if err := models.DeleteAdminSession(c.GetInt("session_id")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	models.LogAuthEvent(c.GetString("username"), c.ClientIP(), models.AuthActionWebLogout, true, "退出登录")
+
+	c.JSON(http.StatusOK, gin.H{"message": "已退出登录"})
+}
+
+func GetMySessions(c *gin.Context) {
+	sessions, err := models.ListAdminSessions(c.GetString("username"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	currentID := c.GetInt("session_id")
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentID
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": sessions})
+}
+
+func RevokeSession(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的会话ID"})
+		return
+	}
+
+	owner, err := models.GetAdminSessionOwner(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "会话不存在"})
+		return
+	}
+
+	if owner != c.GetString("username") && !HasPermission(c.GetString("role"), PermAdminManage) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "权限不足"})
+		return
+	}
+
+	if err := models.DeleteAdminSession(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "会话已撤销"})
+}
+
+// WebSocketProtocol 为实时推送使用的子协议。浏览器的 WebSocket 无法设置 Authorization 头，
+// Token 作为第二个子协议随 Sec-WebSocket-Protocol 发送，避免出现在地址栏和访问日志中
+const WebSocketProtocol = "edge-server"
+
+func webSocketToken(c *gin.Context) string {
+	var protocols []string
+	for _, value := range c.Request.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(value, ",") {
+			protocols = append(protocols, strings.TrimSpace(protocol))
+		}
+	}
+	if len(protocols) != 2 || protocols[0] != WebSocketProtocol {
+		return ""
+	}
+	return protocols[1]
+}
+
 func AuthRequired() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
+		if authHeader == "" && c.IsWebsocket() {
+			if token := webSocketToken(c); token != "" {
+				authHeader = "Bearer " + token
+			}
+		}
 		if authHeader == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权访问"})
 			c.Abort()
@@ -92,42 +251,60 @@ func AuthRequired() gin.HandlerFunc {
 			return
 		}
 
-		mu.RLock()
-		session, exists := sessions[token]
-		mu.RUnlock()
-
-		if !exists {
+		session, err := models.GetAdminSessionByToken(token)
+		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的Token"})
 			c.Abort()
 			return
 		}
 
 		if time.Now().After(session.ExpiresAt) {
-			mu.Lock()
-			delete(sessions, token)
-			mu.Unlock()
+			models.DeleteAdminSession(session.ID)
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token已过期"})
 			c.Abort()
 			return
 		}
 
-		c.Set("username", session.Username)
+		admin, err := models.GetAdminByUsername(session.Username)
+		if err != nil || !admin.Enabled {
+			models.DeleteAdminSession(session.ID)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "账号不可用"})
+			c.Abort()
+			return
+		}
+
+		models.TouchAdminSession(session.ID)
+
+		c.Set("username", admin.Username)
+		c.Set("admin_id", admin.ID)
+		c.Set("role", admin.Role)
+		c.Set("session_id", session.ID)
 		c.Next()
 	}
 }
 
+// SessionAllows 检查会话仍然有效且管理员仍具有指定权限，用于 WebSocket 等长连接在建立后定期复查
+func SessionAllows(sessionID int, perm Permission) bool {
+	session, err := models.GetAdminSession(sessionID)
+	if err != nil || time.Now().After(session.ExpiresAt) {
+		return false
+	}
+	admin, err := models.GetAdminByUsername(session.Username)
+	if err != nil || !admin.Enabled {
+		return false
+	}
+	return HasPermission(admin.Role, perm)
+}
+
 func CleanupExpiredSessions() {
 	ticker := time.NewTicker(1 * time.Hour)
 	go func() {
 		for range ticker.C {
-			mu.Lock()
-			now := time.Now()
-			for token, session := range sessions {
-				if now.After(session.ExpiresAt) {
-					delete(sessions, token)
-				}
+			if n, err := models.DeleteExpiredAdminSessions(); err != nil {
+				log.Printf("清理过期会话失败: %v", err)
+			} else if n > 0 {
+				log.Printf("已清理 %d 个过期会话", n)
 			}
-			mu.Unlock()
 		}
 	}()
 }
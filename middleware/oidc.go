@@ -0,0 +1,239 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"edge_server/auth"
+	"edge_server/models"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcStateTTL    = 10 * time.Minute
+	oidcTicketTTL   = time.Minute
+	oidcStateCookie = "oidc_state"
+	oidcCookiePath  = "/api/auth/oidc"
+)
+
+// oidcLogin 为跳转到 IdP 后等待回调的登录，state 同时写入浏览器 Cookie，防止回调被其他浏览器冒用
+type oidcLogin struct {
+	ProviderID int
+	Nonce      string
+	Verifier   string
+	ExpiresAt  time.Time
+}
+
+// oidcTicket 为回调成功后交给前端换取会话的一次性凭据，避免会话 Token 出现在地址栏
+type oidcTicket struct {
+	Username  string
+	RemoteIP  string
+	ExpiresAt time.Time
+}
+
+var (
+	oidcMu      sync.Mutex
+	oidcLogins  = make(map[string]*oidcLogin)
+	oidcTickets = make(map[string]*oidcTicket)
+)
+
+func takeOIDCLogin(state string) *oidcLogin {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+
+	login, exists := oidcLogins[models.HashSessionToken(state)]
+	if !exists {
+		return nil
+	}
+	delete(oidcLogins, models.HashSessionToken(state))
+	if time.Now().After(login.ExpiresAt) {
+		return nil
+	}
+	return login
+}
+
+func newOIDCTicket(username, remoteIP string) string {
+	ticket := generateToken()
+
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+
+	now := time.Now()
+	for key, t := range oidcTickets {
+		if now.After(t.ExpiresAt) {
+			delete(oidcTickets, key)
+		}
+	}
+	oidcTickets[models.HashSessionToken(ticket)] = &oidcTicket{
+		Username:  username,
+		RemoteIP:  remoteIP,
+		ExpiresAt: now.Add(oidcTicketTTL),
+	}
+	return ticket
+}
+
+func oidcLoginProvider(id int) (*models.AuthProvider, bool) {
+	p, err := models.GetAuthProvider(id)
+	if err != nil || p.Type != auth.ProviderOIDC || !p.Enabled || !p.ConsoleEnabled {
+		return nil, false
+	}
+	return p, true
+}
+
+// redirectLoginError 回到登录页并显示错误，登录页本身不需要认证
+func redirectLoginError(c *gin.Context, message string) {
+	c.Redirect(http.StatusFound, "/login?sso_error="+url.QueryEscape(message))
+}
+
+// GetSSOProviders 返回登录页可用的单点登录入口
+func GetSSOProviders(c *gin.Context) {
+	providers, err := models.ListAuthProviders()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	entries := []gin.H{}
+	for _, p := range providers {
+		if p.Type == auth.ProviderOIDC && p.Enabled && p.ConsoleEnabled {
+			entries = append(entries, gin.H{"id": p.ID, "name": p.Name})
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"data": entries, "sso_only": models.ConsoleSSOOnly()})
+}
+
+// OIDCLogin 生成 state、nonce 和 PKCE 校验码后跳转到 IdP
+func OIDCLogin(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	provider, ok := oidcLoginProvider(id)
+	if !ok {
+		redirectLoginError(c, "单点登录入口不可用")
+		return
+	}
+
+	state := generateToken()
+	login := &oidcLogin{
+		ProviderID: provider.ID,
+		Nonce:      generateToken(),
+		Verifier:   oauth2.GenerateVerifier(),
+		ExpiresAt:  time.Now().Add(oidcStateTTL),
+	}
+	authURL, err := auth.OIDCAuthCodeURL(provider, state, login.Nonce, login.Verifier)
+	if err != nil {
+		log.Printf("OIDC 认证源 %s 不可用: %v", provider.Name, err)
+		redirectLoginError(c, "单点登录服务暂不可用")
+		return
+	}
+
+	oidcMu.Lock()
+	now := time.Now()
+	for key, l := range oidcLogins {
+		if now.After(l.ExpiresAt) {
+			delete(oidcLogins, key)
+		}
+	}
+	oidcLogins[models.HashSessionToken(state)] = login
+	oidcMu.Unlock()
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, int(oidcStateTTL/time.Second), oidcCookiePath, "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback 处理 IdP 回调：校验 state、换取并验证 ID Token、按声明映射角色并同步管理员账号
+func OIDCCallback(c *gin.Context) {
+	remoteIP := c.ClientIP()
+	state := c.Query("state")
+	cookie, _ := c.Cookie(oidcStateCookie)
+	c.SetCookie(oidcStateCookie, "", -1, oidcCookiePath, "", c.Request.TLS != nil, true)
+
+	if errCode := c.Query("error"); errCode != "" {
+		takeOIDCLogin(state)
+		redirectLoginError(c, "IdP 拒绝了登录请求: "+errCode)
+		return
+	}
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(cookie)) != 1 {
+		redirectLoginError(c, "登录请求已失效，请重新登录")
+		return
+	}
+	login := takeOIDCLogin(state)
+	if login == nil {
+		redirectLoginError(c, "登录请求已过期，请重新登录")
+		return
+	}
+	provider, ok := oidcLoginProvider(login.ProviderID)
+	if !ok {
+		redirectLoginError(c, "单点登录入口不可用")
+		return
+	}
+
+	result, err := auth.OIDCExchange(provider, c.Query("code"), login.Nonce, login.Verifier)
+	if err != nil {
+		log.Printf("OIDC 登录失败 [%s]: %v", provider.Name, err)
+		models.LogAuthEvent("", remoteIP, models.AuthActionWebLogin, false, "单点登录失败: "+err.Error())
+		redirectLoginError(c, "单点登录失败")
+		return
+	}
+
+	admin, err := saveExternalAdmin(result)
+	if err != nil {
+		models.LogAuthEvent(result.Identity.Username, remoteIP, models.AuthActionWebLogin, false, err.Error())
+		redirectLoginError(c, err.Error())
+		return
+	}
+	if !admin.Enabled {
+		models.LogAuthEvent(admin.Username, remoteIP, models.AuthActionWebLogin, false, "用户已被禁用")
+		redirectLoginError(c, "用户已被禁用")
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/login?sso_ticket="+url.QueryEscape(newOIDCTicket(admin.Username, remoteIP)))
+}
+
+// OIDCComplete 用回调得到的一次性凭据换取会话，启用了二次验证的管理员仍需输入验证码
+func OIDCComplete(c *gin.Context) {
+	var req struct {
+		Ticket string `json:"ticket"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Ticket == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+
+	remoteIP := c.ClientIP()
+	oidcMu.Lock()
+	ticket, exists := oidcTickets[models.HashSessionToken(req.Ticket)]
+	delete(oidcTickets, models.HashSessionToken(req.Ticket))
+	oidcMu.Unlock()
+	if !exists || time.Now().After(ticket.ExpiresAt) || ticket.RemoteIP != remoteIP {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "单点登录凭据无效或已过期，请重新登录"})
+		return
+	}
+
+	admin, err := models.GetAdminByUsername(ticket.Username)
+	if err != nil || !admin.Enabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "账号不可用"})
+		return
+	}
+
+	settings, err := models.GetAdminTOTP(admin.Username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询二次验证设置失败"})
+		return
+	}
+	if settings.Enabled {
+		c.JSON(http.StatusOK, gin.H{
+			"mfa_required": true,
+			"mfa_token":    newMFAChallenge(admin.Username, remoteIP),
+		})
+		return
+	}
+
+	issueSession(c, admin, "单点登录成功")
+}
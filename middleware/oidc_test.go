@@ -0,0 +1,399 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"edge_server/auth"
+	"edge_server/models"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	testOIDCClientID    = "edge-console"
+	testOIDCRedirectURL = "https://console.example.com/api/auth/oidc/callback"
+)
+
+// oidcTestIssuer 为测试用的 IdP，签发 RS256 ID Token，换取令牌时校验 PKCE 和回调地址
+type oidcTestIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]oidcTestGrant
+}
+
+type oidcTestGrant struct {
+	subject     string
+	claims      map[string]interface{}
+	nonce       string
+	challenge   string
+	redirectURI string
+}
+
+func newOIDCTestIssuer(t *testing.T) *oidcTestIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &oidcTestIssuer{key: key, grants: make(map[string]oidcTestGrant)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                s.URL,
+			"authorization_endpoint":                s.URL + "/authorize",
+			"token_endpoint":                        s.URL + "/token",
+			"jwks_uri":                              s.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", s.token)
+
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+// authorize 模拟用户在 IdP 登录并同意授权，返回回调携带的授权码和 state
+func (s *oidcTestIssuer) authorize(t *testing.T, authURL, subject string, claims map[string]interface{}) (string, string) {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil || !strings.HasPrefix(authURL, s.URL+"/authorize?") {
+		t.Fatalf("auth URL = %q", authURL)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" || q.Get("nonce") == "" || q.Get("state") == "" {
+		t.Fatalf("auth URL without state, nonce or PKCE: %s", authURL)
+	}
+
+	code := generateToken()
+	s.mu.Lock()
+	s.grants[code] = oidcTestGrant{
+		subject:     subject,
+		claims:      claims,
+		nonce:       q.Get("nonce"),
+		challenge:   q.Get("code_challenge"),
+		redirectURI: q.Get("redirect_uri"),
+	}
+	s.mu.Unlock()
+	return code, q.Get("state")
+}
+
+func (s *oidcTestIssuer) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	s.mu.Lock()
+	grant, exists := s.grants[r.PostForm.Get("code")]
+	delete(s.grants, r.PostForm.Get("code"))
+	s.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	w.Header().Set("Content-Type", "application/json")
+	if !exists || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge || r.PostForm.Get("redirect_uri") != grant.redirectURI {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":   s.URL,
+		"sub":   grant.subject,
+		"aud":   testOIDCClientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(5 * time.Minute).Unix(),
+		"nonce": grant.nonce,
+	}
+	for key, value := range grant.claims {
+		claims[key] = value
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "access-" + grant.subject,
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     s.sign(claims),
+	})
+}
+
+func (s *oidcTestIssuer) sign(claims map[string]interface{}) string {
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","kid":"test","typ":"JWT"}`)) +
+		"." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, _ := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+type oidcTestEnv struct {
+	issuer     *oidcTestIssuer
+	router     *gin.Engine
+	providerID int
+}
+
+func newOIDCTestEnv(t *testing.T) *oidcTestEnv {
+	t.Helper()
+	if err := models.InitDB(filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	t.Cleanup(func() { models.DB.Close() })
+
+	issuer := newOIDCTestIssuer(t)
+	settings, _ := json.Marshal(auth.OIDCSettings{
+		Issuer:       issuer.URL,
+		ClientID:     testOIDCClientID,
+		ClientSecret: "client-secret",
+		RedirectURL:  testOIDCRedirectURL,
+	})
+	provider := &models.AuthProvider{
+		Name:           "sso",
+		Type:           auth.ProviderOIDC,
+		Enabled:        true,
+		ConsoleEnabled: true,
+		Settings:       settings,
+		GroupMappings:  []models.GroupMapping{{Group: "console-admins", Role: models.RoleOperator}},
+	}
+	if err := models.SaveAuthProvider(provider); err != nil {
+		t.Fatalf("SaveAuthProvider: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/auth/oidc/:id/login", OIDCLogin)
+	router.GET("/api/auth/oidc/callback", OIDCCallback)
+	router.POST("/api/auth/oidc/complete", OIDCComplete)
+	router.POST("/api/auth/totp", LoginTOTP)
+	return &oidcTestEnv{issuer: issuer, router: router, providerID: provider.ID}
+}
+
+func (e *oidcTestEnv) serve(req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	e.router.ServeHTTP(w, req)
+	return w
+}
+
+// start 访问单点登录入口，返回 IdP 授权地址和 state Cookie
+func (e *oidcTestEnv) start(t *testing.T) (string, *http.Cookie) {
+	t.Helper()
+	w := e.serve(httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/auth/oidc/%d/login", e.providerID), nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login status = %d", w.Code)
+	}
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == oidcStateCookie {
+			return w.Header().Get("Location"), cookie
+		}
+	}
+	t.Fatal("login did not set the state cookie")
+	return "", nil
+}
+
+// callback 模拟浏览器从 IdP 跳回，返回回调后跳转到的登录页地址
+func (e *oidcTestEnv) callback(t *testing.T, code, state string, cookie *http.Cookie) url.Values {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/oidc/callback?"+url.Values{"code": {code}, "state": {state}}.Encode(), nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	w := e.serve(req)
+	location, err := url.Parse(w.Header().Get("Location"))
+	if w.Code != http.StatusFound || err != nil || location.Path != "/login" {
+		t.Fatalf("callback = %d %q", w.Code, w.Header().Get("Location"))
+	}
+	return location.Query()
+}
+
+// login 完成一次 IdP 登录，返回回调得到的一次性凭据
+func (e *oidcTestEnv) login(t *testing.T, subject string, claims map[string]interface{}) string {
+	t.Helper()
+	authURL, cookie := e.start(t)
+	code, state := e.issuer.authorize(t, authURL, subject, claims)
+	result := e.callback(t, code, state, cookie)
+	if result.Get("sso_ticket") == "" {
+		t.Fatalf("callback error: %s", result.Get("sso_error"))
+	}
+	return result.Get("sso_ticket")
+}
+
+func (e *oidcTestEnv) postJSON(path, remoteAddr string, body interface{}) (int, map[string]interface{}) {
+	raw, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(string(raw)))
+	req.Header.Set("Content-Type", "application/json")
+	if remoteAddr != "" {
+		req.RemoteAddr = remoteAddr
+	}
+	w := e.serve(req)
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w.Code, resp
+}
+
+var aliceClaims = map[string]interface{}{
+	"preferred_username": "alice",
+	"name":               "Alice Liu",
+	"groups":             []string{"console-admins"},
+}
+
+func TestOIDCLogin(t *testing.T) {
+	e := newOIDCTestEnv(t)
+
+	// 回调地址取自认证源配置，伪造的 Host 和转发头不影响
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/auth/oidc/%d/login", e.providerID), nil)
+	req.Host = "evil.example.net"
+	req.Header.Set("X-Forwarded-Proto", "http")
+	req.Header.Set("X-Forwarded-Host", "evil.example.net")
+	w := e.serve(req)
+	authURL, err := url.Parse(w.Header().Get("Location"))
+	if w.Code != http.StatusFound || err != nil {
+		t.Fatalf("login = %d %q", w.Code, w.Header().Get("Location"))
+	}
+	if got := authURL.Query().Get("redirect_uri"); got != testOIDCRedirectURL {
+		t.Errorf("redirect_uri = %q, want %q", got, testOIDCRedirectURL)
+	}
+
+	ticket := e.login(t, "1001", aliceClaims)
+	status, resp := e.postJSON("/api/auth/oidc/complete", "", gin.H{"ticket": ticket})
+	if status != http.StatusOK || resp["token"] == nil || resp["username"] != "alice" || resp["role"] != models.RoleOperator {
+		t.Fatalf("complete = %d %v", status, resp)
+	}
+	var externalID string
+	models.DB.QueryRow("SELECT external_id FROM admins WHERE username='alice'").Scan(&externalID)
+	if externalID != e.issuer.URL+"#1001" {
+		t.Errorf("external_id = %q", externalID)
+	}
+
+	// 凭据只能使用一次
+	if status, resp := e.postJSON("/api/auth/oidc/complete", "", gin.H{"ticket": ticket}); status != http.StatusBadRequest {
+		t.Errorf("reused ticket = %d %v", status, resp)
+	}
+	// 凭据只能由完成回调的客户端使用
+	ticket = e.login(t, "1001", aliceClaims)
+	if status, resp := e.postJSON("/api/auth/oidc/complete", "198.51.100.7:4321", gin.H{"ticket": ticket}); status != http.StatusBadRequest {
+		t.Errorf("ticket from another address = %d %v", status, resp)
+	}
+}
+
+func TestOIDCCallbackRejects(t *testing.T) {
+	e := newOIDCTestEnv(t)
+
+	t.Run("缺少 state Cookie", func(t *testing.T) {
+		authURL, _ := e.start(t)
+		code, state := e.issuer.authorize(t, authURL, "1001", aliceClaims)
+		if got := e.callback(t, code, state, nil).Get("sso_error"); !strings.Contains(got, "已失效") {
+			t.Errorf("sso_error = %q", got)
+		}
+	})
+
+	t.Run("state 与 Cookie 不一致", func(t *testing.T) {
+		authURL, _ := e.start(t)
+		_, otherCookie := e.start(t)
+		code, state := e.issuer.authorize(t, authURL, "1001", aliceClaims)
+		if got := e.callback(t, code, state, otherCookie).Get("sso_error"); !strings.Contains(got, "已失效") {
+			t.Errorf("sso_error = %q", got)
+		}
+	})
+
+	t.Run("state 重复使用", func(t *testing.T) {
+		authURL, cookie := e.start(t)
+		code, state := e.issuer.authorize(t, authURL, "1001", aliceClaims)
+		if got := e.callback(t, code, state, cookie); got.Get("sso_ticket") == "" {
+			t.Fatalf("first callback: %v", got)
+		}
+		if got := e.callback(t, code, state, cookie).Get("sso_error"); !strings.Contains(got, "已过期") {
+			t.Errorf("sso_error = %q", got)
+		}
+	})
+
+	// 截获的授权码换到另一次登录中使用时 PKCE 校验码不匹配，IdP 拒绝换取令牌
+	t.Run("授权码属于另一次登录", func(t *testing.T) {
+		victimURL, _ := e.start(t)
+		attackerURL, attackerCookie := e.start(t)
+		code, _ := e.issuer.authorize(t, victimURL, "1001", aliceClaims)
+		_, state := e.issuer.authorize(t, attackerURL, "2002", aliceClaims)
+		if got := e.callback(t, code, state, attackerCookie).Get("sso_error"); got != "单点登录失败" {
+			t.Errorf("sso_error = %q", got)
+		}
+	})
+
+	t.Run("nonce 不匹配", func(t *testing.T) {
+		authURL, cookie := e.start(t)
+		claims := map[string]interface{}{"nonce": "replayed", "preferred_username": "alice", "groups": []string{"console-admins"}}
+		code, state := e.issuer.authorize(t, authURL, "1001", claims)
+		if got := e.callback(t, code, state, cookie).Get("sso_error"); got != "单点登录失败" {
+			t.Errorf("sso_error = %q", got)
+		}
+	})
+
+	t.Run("未映射到管理员角色", func(t *testing.T) {
+		authURL, cookie := e.start(t)
+		code, state := e.issuer.authorize(t, authURL, "3003", map[string]interface{}{"preferred_username": "mallory"})
+		if got := e.callback(t, code, state, cookie).Get("sso_error"); !strings.Contains(got, "未在认证源") {
+			t.Errorf("sso_error = %q", got)
+		}
+		if _, err := models.GetAdminByUsername("mallory"); err == nil {
+			t.Error("unmapped user was created")
+		}
+	})
+}
+
+func TestOIDCCompleteRequiresTOTP(t *testing.T) {
+	e := newOIDCTestEnv(t)
+
+	if status, resp := e.postJSON("/api/auth/oidc/complete", "", gin.H{"ticket": e.login(t, "1001", aliceClaims)}); status != http.StatusOK {
+		t.Fatalf("first login = %d %v", status, resp)
+	}
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: "edge-server", AccountName: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := models.SetAdminTOTPSecret("alice", key.Secret()); err != nil {
+		t.Fatal(err)
+	}
+	if err := models.EnableAdminTOTP("alice", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// 单点登录不能绕过管理员自己启用的二次验证
+	status, resp := e.postJSON("/api/auth/oidc/complete", "", gin.H{"ticket": e.login(t, "1001", aliceClaims)})
+	if status != http.StatusOK || resp["mfa_required"] != true || resp["token"] != nil {
+		t.Fatalf("complete = %d %v", status, resp)
+	}
+	mfaToken, _ := resp["mfa_token"].(string)
+
+	if status, resp := e.postJSON("/api/auth/totp", "", gin.H{"mfa_token": mfaToken, "code": "000000"}); status != http.StatusUnauthorized {
+		t.Errorf("wrong code = %d %v", status, resp)
+	}
+	code, _ := totp.GenerateCode(key.Secret(), time.Now())
+	status, resp = e.postJSON("/api/auth/totp", "", gin.H{"mfa_token": mfaToken, "code": code})
+	if status != http.StatusOK || resp["token"] == nil || resp["username"] != "alice" {
+		t.Errorf("totp = %d %v", status, resp)
+	}
+}
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"edge_server/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Permission string
+
+const (
+	PermGroupRead    Permission = "group:read"
+	PermGroupWrite   Permission = "group:write"
+	PermUserRead     Permission = "user:read"
+	PermUserWrite    Permission = "user:write"
+	PermOnlineRead   Permission = "online:read"
+	PermOnlineManage Permission = "online:manage"
+	PermLogRead      Permission = "log:read"
+	PermStatsRead    Permission = "stats:read"
+	PermConfigRead   Permission = "config:read"
+	PermConfigWrite  Permission = "config:write"
+	PermAdminManage  Permission = "admin:manage"
+)
+
+var rolePermissions = map[string][]Permission{
+	models.RoleSuperAdmin: {
+		PermGroupRead, PermGroupWrite,
+		PermUserRead, PermUserWrite,
+		PermOnlineRead, PermOnlineManage,
+		PermLogRead, PermStatsRead,
+		PermConfigRead, PermConfigWrite,
+		PermAdminManage,
+	},
+	models.RoleOperator: {
+		PermGroupRead, PermGroupWrite,
+		PermUserRead, PermUserWrite,
+		PermOnlineRead, PermOnlineManage,
+		PermLogRead, PermStatsRead,
+		PermConfigRead,
+	},
+	models.RoleAuditor: {
+		PermGroupRead, PermUserRead,
+		PermOnlineRead, PermLogRead,
+		PermStatsRead, PermConfigRead,
+	},
+	models.RoleHelpdesk: {
+		PermGroupRead, PermUserRead,
+		PermOnlineRead, PermOnlineManage,
+		PermLogRead, PermStatsRead,
+	},
+}
+
+func HasPermission(role string, perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+func RolePermissions(role string) []Permission {
+	return rolePermissions[role]
+}
+
+func RequirePermission(perm Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !HasPermission(c.GetString("role"), perm) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "权限不足"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
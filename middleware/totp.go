@@ -0,0 +1,370 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"edge_server/models"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"image/png"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	totpIssuer         = "Edge Server"
+	totpPeriod         = 30
+	mfaChallengeTTL    = 5 * time.Minute
+	mfaMaxAttempts     = 5
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10
+)
+
+// mfaChallenge 为密码验证通过后等待 TOTP 验证的登录
+type mfaChallenge struct {
+	Username  string
+	RemoteIP  string
+	ExpiresAt time.Time
+	Attempts  int
+}
+
+var (
+	mfaMu         sync.Mutex
+	mfaChallenges = make(map[string]*mfaChallenge)
+)
+
+func newMFAChallenge(username, remoteIP string) string {
+	token := generateToken()
+
+	mfaMu.Lock()
+	defer mfaMu.Unlock()
+
+	now := time.Now()
+	for key, challenge := range mfaChallenges {
+		if now.After(challenge.ExpiresAt) {
+			delete(mfaChallenges, key)
+		}
+	}
+	mfaChallenges[models.HashSessionToken(token)] = &mfaChallenge{
+		Username:  username,
+		RemoteIP:  remoteIP,
+		ExpiresAt: now.Add(mfaChallengeTTL),
+	}
+	return token
+}
+
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateRecoveryCodes() (codes, hashes []string) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, recoveryCodeLength/2)
+		rand.Read(b)
+		code := hex.EncodeToString(b)
+		code = code[:5] + "-" + code[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes
+}
+
+// verifyTOTPCode 校验验证码并记录使用过的时间步，同一验证码不能重复使用
+func verifyTOTPCode(username string, settings *models.AdminTOTP, code string) bool {
+	code = strings.TrimSpace(code)
+	if settings.Secret == "" || len(code) != 6 {
+		return false
+	}
+
+	now := time.Now()
+	current := now.Unix() / totpPeriod
+	for _, offset := range []int64{0, -1, 1} {
+		step := current + offset
+		if step <= settings.LastStep {
+			continue
+		}
+		expected, err := totp.GenerateCodeCustom(settings.Secret, time.Unix(step*totpPeriod, 0), totp.ValidateOpts{
+			Period:    totpPeriod,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err != nil {
+			return false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			claimed, err := models.ClaimAdminTOTPStep(username, step)
+			if err != nil {
+				log.Printf("记录管理员 %s 的二次验证时间步失败: %v", username, err)
+				return false
+			}
+			if !claimed {
+				return false
+			}
+			settings.LastStep = step
+			return true
+		}
+	}
+	return false
+}
+
+// useRecoveryCode 校验恢复码，成功后该恢复码作废
+func useRecoveryCode(username string, settings *models.AdminTOTP, code string) bool {
+	remaining, claimed, err := models.ClaimAdminRecoveryCode(username, hashRecoveryCode(code))
+	if err != nil {
+		log.Printf("作废管理员 %s 的恢复码失败: %v", username, err)
+		return false
+	}
+	if !claimed {
+		return false
+	}
+	settings.RecoveryCodes = remaining
+	return true
+}
+
+// LoginTOTP 为登录的第二步，校验 TOTP 验证码或恢复码后签发会话
+func LoginTOTP(c *gin.Context) {
+	var req struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.MFAToken == "" || req.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+
+	remoteIP := c.ClientIP()
+	key := models.HashSessionToken(req.MFAToken)
+
+	mfaMu.Lock()
+	challenge, exists := mfaChallenges[key]
+	if exists && (time.Now().After(challenge.ExpiresAt) || challenge.RemoteIP != remoteIP) {
+		delete(mfaChallenges, key)
+		exists = false
+	}
+	if exists {
+		challenge.Attempts++
+		if challenge.Attempts > mfaMaxAttempts {
+			delete(mfaChallenges, key)
+			exists = false
+		}
+	}
+	mfaMu.Unlock()
+
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "登录已失效，请重新输入密码"})
+		return
+	}
+
+	admin, err := models.GetAdminByUsername(challenge.Username)
+	if err != nil || !admin.Enabled {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "账号不可用"})
+		return
+	}
+	settings, err := models.GetAdminTOTP(admin.Username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询二次验证设置失败"})
+		return
+	}
+
+	method := "TOTP"
+	ok := verifyTOTPCode(admin.Username, settings, req.Code)
+	if !ok && useRecoveryCode(admin.Username, settings, req.Code) {
+		ok = true
+		method = fmt.Sprintf("恢复码，剩余 %d 个", len(settings.RecoveryCodes))
+	}
+	if !ok {
+		models.LogAuthEvent(admin.Username, remoteIP, models.AuthActionWebLogin, false, "二次验证码错误")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "验证码错误"})
+		return
+	}
+
+	mfaMu.Lock()
+	delete(mfaChallenges, key)
+	mfaMu.Unlock()
+
+	issueSession(c, admin, "登录成功 ("+method+")")
+}
+
+func GetTOTPStatus(c *gin.Context) {
+	settings, err := models.GetAdminTOTP(c.GetString("username"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{
+		"enabled":                  settings.Enabled,
+		"recovery_codes_remaining": len(settings.RecoveryCodes),
+	}})
+}
+
+// SetupTOTP 生成新的密钥和二维码，需调用 EnableTOTP 确认后才会生效
+func SetupTOTP(c *gin.Context) {
+	username := c.GetString("username")
+	settings, err := models.GetAdminTOTP(username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if settings.Enabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "二次验证已启用，如需更换设备请先停用"})
+		return
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      totpIssuer,
+		AccountName: username,
+		Period:      totpPeriod,
+		Digits:      otp.DigitsSix,
+		Algorithm:   otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成密钥失败"})
+		return
+	}
+
+	img, err := key.Image(200, 200)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成二维码失败"})
+		return
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成二维码失败"})
+		return
+	}
+
+	if err := models.SetAdminTOTPSecret(username, key.Secret()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{
+		"secret":  key.Secret(),
+		"uri":     key.URL(),
+		"qr_code": "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()),
+	}})
+}
+
+// EnableTOTP 校验首个验证码后启用二次验证，并返回一次性显示的恢复码
+func EnableTOTP(c *gin.Context) {
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+
+	username := c.GetString("username")
+	settings, err := models.GetAdminTOTP(username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if settings.Enabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "二次验证已启用"})
+		return
+	}
+	if settings.Secret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请先生成密钥"})
+		return
+	}
+	if !verifyTOTPCode(username, settings, req.Code) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "验证码错误"})
+		return
+	}
+
+	codes, hashes := generateRecoveryCodes()
+	if err := models.EnableAdminTOTP(username, hashes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	models.LogAuthEvent(username, c.ClientIP(), models.AuthActionTOTPEnroll, true, "启用二次验证")
+
+	c.JSON(http.StatusOK, gin.H{"message": "二次验证已启用", "recovery_codes": codes})
+}
+
+// RegenerateRecoveryCodes 使旧恢复码全部失效并生成新的一组
+func RegenerateRecoveryCodes(c *gin.Context) {
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+
+	username := c.GetString("username")
+	settings, err := models.GetAdminTOTP(username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !settings.Enabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "二次验证未启用"})
+		return
+	}
+	if !verifyTOTPCode(username, settings, req.Code) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "验证码错误"})
+		return
+	}
+
+	codes, hashes := generateRecoveryCodes()
+	if err := models.SetAdminRecoveryCodes(username, hashes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "恢复码已重新生成", "recovery_codes": codes})
+}
+
+// DisableTOTP 停用当前账号的二次验证，需要提供当前的验证码或一个恢复码。
+// 外部认证源和单点登录的管理员没有本地密码，因此不以登录密码作为凭证
+func DisableTOTP(c *gin.Context) {
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+		return
+	}
+
+	username := c.GetString("username")
+	settings, err := models.GetAdminTOTP(username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !settings.Enabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "二次验证未启用"})
+		return
+	}
+	if !verifyTOTPCode(username, settings, req.Code) && !useRecoveryCode(username, settings, req.Code) {
+		models.LogAuthEvent(username, c.ClientIP(), models.AuthActionTOTPReset, false, "停用二次验证: 验证码错误")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "验证码错误"})
+		return
+	}
+
+	if err := models.ResetAdminTOTP(username); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	models.LogAuthEvent(username, c.ClientIP(), models.AuthActionTOTPReset, true, "停用二次验证")
+
+	c.JSON(http.StatusOK, gin.H{"message": "二次验证已停用"})
+}
@@ -0,0 +1,126 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const (
+	RoleSuperAdmin = "super_admin"
+	RoleOperator   = "operator"
+	RoleAuditor    = "auditor"
+	RoleHelpdesk   = "helpdesk"
+)
+
+type Admin struct {
+	ID             int       `json:"id"`
+	Username       string    `json:"username"`
+	Password       string    `json:"password,omitempty"`
+	FullName       string    `json:"full_name"`
+	Email          string    `json:"email"`
+	Role           string    `json:"role"`
+	Enabled        bool      `json:"enabled"`
+	TOTPEnabled    bool      `json:"totp_enabled"`
+	AuthProviderID int       `json:"auth_provider_id"`
+	BreakGlass     bool      `json:"break_glass"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+func IsValidRole(role string) bool {
+	switch role {
+	case RoleSuperAdmin, RoleOperator, RoleAuditor, RoleHelpdesk:
+		return true
+	}
+	return false
+}
+
+// ErrAdminExists 表示外部管理员的用户名已被本地或其他外部账号占用
+var ErrAdminExists = errors.New("已存在同名管理员")
+
+func GetAdminByUsername(username string) (*Admin, error) {
+	return getAdmin("username=?", username)
+}
+
+func getAdmin(condition string, args ...interface{}) (*Admin, error) {
+	var a Admin
+	var fullName, email sql.NullString
+	err := DB.QueryRow(`
+		SELECT id, username, password, full_name, email, role, enabled, COALESCE(auth_provider_id, 0), COALESCE(break_glass, 0),
+		       created_at, updated_at
+		FROM admins WHERE `+condition, args...).Scan(&a.ID, &a.Username, &a.Password, &fullName, &email, &a.Role, &a.Enabled, &a.AuthProviderID, &a.BreakGlass,
+		&a.CreatedAt, &a.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	a.FullName = fullName.String
+	a.Email = email.String
+	return &a, nil
+}
+
+func CountEnabledSuperAdmins() int {
+	var count int
+	DB.QueryRow("SELECT COUNT(*) FROM admins WHERE role=? AND enabled=1", RoleSuperAdmin).Scan(&count)
+	return count
+}
+
+// ConsoleSSOOnly 返回控制台是否只允许单点登录，此时只有应急管理员可以使用本地密码登录
+func ConsoleSSOOnly() bool {
+	return GetConfig("console_sso_only", "0") == "1"
+}
+
+// CountBreakGlassAdmins 返回启用的本地应急超级管理员数量，excludeID 用于排除正在修改或删除的账号
+func CountBreakGlassAdmins(excludeID int) int {
+	var count int
+	DB.QueryRow(`
+		SELECT COUNT(*) FROM admins
+		WHERE COALESCE(break_glass, 0)=1 AND enabled=1 AND role=? AND COALESCE(auth_provider_id, 0)=0 AND id<>?
+	`, RoleSuperAdmin, excludeID).Scan(&count)
+	return count
+}
+
+// SaveExternalAdmin 创建或更新由外部认证源管理的管理员，角色以认证源的组映射为准。
+// 账号按认证源和 externalID 识别，用户名只在首次创建时使用，之后不随外部声明变化；
+// 用户名已被其他账号占用时返回 ErrAdminExists，不会接管该账号。
+// 旧版本升级的 LDAP/RADIUS 管理员以用户名作为外部标识，以该用户名（不区分大小写）登录时替换为认证源返回的标识。
+func SaveExternalAdmin(externalID, username, fullName, email, role string, providerID int) (*Admin, error) {
+	result, err := DB.Exec(`
+		UPDATE admins SET full_name=?, email=?, role=?, updated_at=CURRENT_TIMESTAMP
+		WHERE auth_provider_id=? AND external_id=?
+	`, fullName, email, role, providerID, externalID)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		result, err = DB.Exec(`
+			UPDATE admins SET full_name=?, email=?, role=?, external_id=?, updated_at=CURRENT_TIMESTAMP
+			WHERE id=(
+				SELECT id FROM admins WHERE auth_provider_id=? AND external_id=username AND LOWER(username)=LOWER(?)
+				ORDER BY username=? DESC, id LIMIT 1
+			)
+		`, fullName, email, role, externalID, providerID, username, username)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		return getAdmin("auth_provider_id=? AND external_id=?", providerID, externalID)
+	}
+
+	_, err = DB.Exec(`
+		INSERT INTO admins (username, password, full_name, email, role, enabled, auth_provider_id, external_id)
+		VALUES (?, '!external', ?, ?, ?, 1, ?, ?)
+	`, username, fullName, email, role, providerID, externalID)
+	if err != nil {
+		// 同一账号并发首次登录时另一请求已创建
+		if admin, lookupErr := getAdmin("auth_provider_id=? AND external_id=?", providerID, externalID); lookupErr == nil {
+			return admin, nil
+		}
+		if _, lookupErr := GetAdminByUsername(username); lookupErr == nil {
+			return nil, ErrAdminExists
+		}
+		return nil, err
+	}
+	return getAdmin("auth_provider_id=? AND external_id=?", providerID, externalID)
+}
@@ -0,0 +1,112 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+type AdminSession struct {
+	ID         int       `json:"id"`
+	Username   string    `json:"username"`
+	RemoteIP   string    `json:"remote_ip"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+func HashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func CreateAdminSession(token, username, remoteIP, userAgent string, ttl time.Duration) (int, error) {
+	now := time.Now().UTC()
+	result, err := DB.Exec(`
+		INSERT INTO admin_sessions (token_hash, username, remote_ip, user_agent, created_at, last_seen_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, HashSessionToken(token), username, remoteIP, userAgent, now, now, now.Add(ttl))
+	if err != nil {
+		return 0, err
+	}
+	id, _ := result.LastInsertId()
+	return int(id), nil
+}
+
+func GetAdminSessionByToken(token string) (*AdminSession, error) {
+	var s AdminSession
+	err := DB.QueryRow(`
+		SELECT id, username, remote_ip, user_agent, created_at, last_seen_at, expires_at
+		FROM admin_sessions WHERE token_hash=?
+	`, HashSessionToken(token)).Scan(&s.ID, &s.Username, &s.RemoteIP, &s.UserAgent, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func GetAdminSession(id int) (*AdminSession, error) {
+	var s AdminSession
+	err := DB.QueryRow(`
+		SELECT id, username, remote_ip, user_agent, created_at, last_seen_at, expires_at
+		FROM admin_sessions WHERE id=?
+	`, id).Scan(&s.ID, &s.Username, &s.RemoteIP, &s.UserAgent, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func TouchAdminSession(id int) {
+	DB.Exec("UPDATE admin_sessions SET last_seen_at=? WHERE id=?", time.Now().UTC(), id)
+}
+
+func ListAdminSessions(username string) ([]AdminSession, error) {
+	rows, err := DB.Query(`
+		SELECT id, username, remote_ip, user_agent, created_at, last_seen_at, expires_at
+		FROM admin_sessions
+		WHERE username=? AND expires_at > ?
+		ORDER BY last_seen_at DESC
+	`, username, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []AdminSession
+	for rows.Next() {
+		var s AdminSession
+		if err := rows.Scan(&s.ID, &s.Username, &s.RemoteIP, &s.UserAgent, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt); err != nil {
+			continue
+		}
+		sessions = append(sessions, s)
+	}
+
+	return sessions, nil
+}
+
+func GetAdminSessionOwner(id int) (string, error) {
+	var username string
+	err := DB.QueryRow("SELECT username FROM admin_sessions WHERE id=?", id).Scan(&username)
+	return username, err
+}
+
+func DeleteAdminSession(id int) error {
+	_, err := DB.Exec("DELETE FROM admin_sessions WHERE id=?", id)
+	return err
+}
+
+func DeleteAdminSessionsByUsername(username string) error {
+	_, err := DB.Exec("DELETE FROM admin_sessions WHERE username=?", username)
+	return err
+}
+
+func DeleteExpiredAdminSessions() (int64, error) {
+	result, err := DB.Exec("DELETE FROM admin_sessions WHERE expires_at <= ?", time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
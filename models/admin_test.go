@@ -0,0 +1,246 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestSaveExternalAdmin(t *testing.T) {
+	if err := InitDB(filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	defer DB.Close()
+
+	const issuer = "https://idp.example.com"
+	created, err := SaveExternalAdmin(issuer+"#1001", "alice", "Alice", "alice@example.com", RoleAuditor, 1)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if created.Username != "alice" || created.AuthProviderID != 1 || created.Role != RoleAuditor {
+		t.Fatalf("created = %+v", created)
+	}
+
+	// 用户在 IdP 中修改了 preferred_username，仍对应原账号，用户名不变
+	renamed, err := SaveExternalAdmin(issuer+"#1001", "alice2", "Alice Liu", "alice@example.com", RoleOperator, 1)
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if renamed.ID != created.ID || renamed.Username != "alice" || renamed.FullName != "Alice Liu" || renamed.Role != RoleOperator {
+		t.Errorf("renamed = %+v", renamed)
+	}
+
+	// 其他用户把 preferred_username 改成 alice，不能接管已有账号
+	if _, err := SaveExternalAdmin(issuer+"#2002", "alice", "Mallory", "", RoleSuperAdmin, 1); !errors.Is(err, ErrAdminExists) {
+		t.Errorf("takeover by another subject: err = %v", err)
+	}
+	if _, err := SaveExternalAdmin(issuer+"#1001", "alice", "Alice", "", RoleSuperAdmin, 2); !errors.Is(err, ErrAdminExists) {
+		t.Errorf("takeover from another provider: err = %v", err)
+	}
+	if _, err := DB.Exec("INSERT INTO admins (username, password, role) VALUES ('root', 'x', ?)", RoleSuperAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SaveExternalAdmin(issuer+"#3003", "root", "", "", RoleSuperAdmin, 1); !errors.Is(err, ErrAdminExists) {
+		t.Errorf("takeover of local admin: err = %v", err)
+	}
+
+	admin, err := GetAdminByUsername("alice")
+	if err != nil || admin.ID != created.ID || admin.FullName != "Alice Liu" || admin.Role != RoleOperator {
+		t.Errorf("alice after takeover attempts = %+v, %v", admin, err)
+	}
+
+	// 同一认证源的另一个账号正常创建
+	other, err := SaveExternalAdmin(issuer+"#2002", "bob", "Bob", "", RoleAuditor, 1)
+	if err != nil || other.Username != "bob" || other.ID == created.ID {
+		t.Errorf("other = %+v, %v", other, err)
+	}
+}
+
+func TestMigrateExternalAdmins(t *testing.T) {
+	if err := InitDB(filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	defer DB.Close()
+
+	if _, err := DB.Exec(`
+		INSERT INTO auth_providers (id, name, type) VALUES (1, 'ldap', 'ldap'), (2, 'sso', 'oidc');
+		INSERT INTO admins (username, password, role, auth_provider_id) VALUES
+			('ldap-admin', '!external', 'auditor', 1), ('sso-admin', '!external', 'auditor', 2), ('local', 'x', 'auditor', 0);
+	`); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateTables(); err != nil {
+		t.Fatalf("migrateTables: %v", err)
+	}
+
+	want := map[string]string{"ldap-admin": "ldap-admin", "sso-admin": "", "local": ""}
+	for username, externalID := range want {
+		var got string
+		if err := DB.QueryRow("SELECT external_id FROM admins WHERE username=?", username).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != externalID {
+			t.Errorf("%s external_id = %q, want %q", username, got, externalID)
+		}
+	}
+
+	// 旧版 OIDC 管理员没有 sub，不能按用户名认领
+	if _, err := SaveExternalAdmin("https://idp.example.com#1", "sso-admin", "", "", RoleAuditor, 2); !errors.Is(err, ErrAdminExists) {
+		t.Errorf("legacy OIDC admin claimed: err = %v", err)
+	}
+
+	// 旧版 LDAP 管理员以相同用户名登录时改为按 DN 识别
+	const dn = "uid=ldap-admin,ou=people,dc=example,dc=com"
+	legacy, err := GetAdminByUsername("ldap-admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bob, err := SaveExternalAdmin("uid=bob,ou=people,dc=example,dc=com", "bob", "", "", RoleOperator, 1); err != nil || bob.ID == legacy.ID {
+		t.Fatalf("bob = %+v, %v", bob, err)
+	}
+
+	admin, err := SaveExternalAdmin(dn, "Ldap-Admin", "", "", RoleOperator, 1)
+	if err != nil || admin.ID != legacy.ID || admin.Username != "ldap-admin" || admin.Role != RoleOperator {
+		t.Fatalf("legacy LDAP admin = %+v, %v", admin, err)
+	}
+	if admin, err := SaveExternalAdmin(dn, "LDAP-ADMIN", "", "", RoleAuditor, 1); err != nil || admin.ID != legacy.ID {
+		t.Errorf("login with different case = %+v, %v", admin, err)
+	}
+}
+
+func TestSeedAdmin(t *testing.T) {
+	const legacySchema = `
+		CREATE TABLE users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT NOT NULL UNIQUE,
+			password TEXT NOT NULL,
+			full_name TEXT,
+			email TEXT,
+			group_id INTEGER,
+			custom_routes TEXT,
+			custom_policies TEXT,
+			enabled INTEGER DEFAULT 1,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`
+	const operatorHash = "$2a$10$abcdefghijklmnopqrstuuJ0cP6Vd5y1yZ1b8qf1H5m2Yx0l9mEJe"
+
+	tests := []struct {
+		name   string
+		setup  string
+		want   string
+		random bool
+	}{
+		{name: "全新安装", want: defaultAdminPasswordHash},
+		{
+			name:  "升级沿用旧密码",
+			setup: legacySchema + `INSERT INTO users (username, password) VALUES ('admin', '` + operatorHash + `'), ('bob', 'x');`,
+			want:  operatorHash,
+		},
+		{
+			name:  "升级时旧密码为默认密码",
+			setup: legacySchema + `INSERT INTO users (username, password) VALUES ('admin', '` + defaultAdminPasswordHash + `');`,
+			want:  defaultAdminPasswordHash,
+		},
+		{
+			name:   "升级前已没有 admin",
+			setup:  legacySchema + `INSERT INTO users (username, password) VALUES ('bob', 'x');`,
+			random: true,
+		},
+		{
+			// 此前的版本升级后 admins 表写入了默认密码
+			name: "修复已写入默认密码的管理员",
+			setup: legacySchema + `INSERT INTO users (username, password) VALUES ('admin', '` + operatorHash + `');
+				CREATE TABLE admins (id INTEGER PRIMARY KEY AUTOINCREMENT, username TEXT NOT NULL UNIQUE, password TEXT NOT NULL,
+					full_name TEXT, email TEXT, role TEXT NOT NULL DEFAULT 'auditor', enabled INTEGER DEFAULT 1,
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP, updated_at DATETIME DEFAULT CURRENT_TIMESTAMP);
+				INSERT INTO admins (username, password, role) VALUES ('admin', '` + defaultAdminPasswordHash + `', 'super_admin');`,
+			want: operatorHash,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.db")
+			if tt.setup != "" {
+				db, err := sql.Open("sqlite3", path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := db.Exec(tt.setup); err != nil {
+					t.Fatal(err)
+				}
+				db.Close()
+			}
+			if err := InitDB(path); err != nil {
+				t.Fatalf("InitDB: %v", err)
+			}
+			defer DB.Close()
+
+			admin, err := GetAdminByUsername("admin")
+			if err != nil {
+				t.Fatalf("GetAdminByUsername: %v", err)
+			}
+			if admin.Role != RoleSuperAdmin || !admin.Enabled {
+				t.Errorf("admin = %+v", admin)
+			}
+			if tt.random {
+				if admin.Password == defaultAdminPasswordHash || !strings.HasPrefix(admin.Password, "$2a$") {
+					t.Errorf("password = %q, want random bcrypt hash", admin.Password)
+				}
+				return
+			}
+			if admin.Password != tt.want {
+				t.Errorf("password = %q, want %q", admin.Password, tt.want)
+			}
+		})
+	}
+}
+
+func TestClaimAdminRecoveryCode(t *testing.T) {
+	if err := InitDB(filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	defer DB.Close()
+
+	if err := EnableAdminTOTP("admin", []string{"h1", "h2", "h3"}); err != nil {
+		t.Fatal(err)
+	}
+
+	remaining, claimed, err := ClaimAdminRecoveryCode("admin", "h2")
+	if err != nil || !claimed || strings.Join(remaining, ",") != "h1,h3" {
+		t.Fatalf("claim h2 = %v, %v, %v", remaining, claimed, err)
+	}
+	if _, claimed, err := ClaimAdminRecoveryCode("admin", "h2"); err != nil || claimed {
+		t.Fatalf("reuse h2 = %v, %v", claimed, err)
+	}
+	if _, claimed, err := ClaimAdminRecoveryCode("admin", "unknown"); err != nil || claimed {
+		t.Fatalf("unknown code = %v, %v", claimed, err)
+	}
+
+	// 并发使用同一恢复码，只有一个请求成功
+	results := make(chan bool, 8)
+	for i := 0; i < cap(results); i++ {
+		go func() {
+			_, claimed, _ := ClaimAdminRecoveryCode("admin", "h1")
+			results <- claimed
+		}()
+	}
+	succeeded := 0
+	for i := 0; i < cap(results); i++ {
+		if <-results {
+			succeeded++
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%d concurrent claims succeeded, want 1", succeeded)
+	}
+
+	settings, err := GetAdminTOTP("admin")
+	if err != nil || strings.Join(settings.RecoveryCodes, ",") != "h3" {
+		t.Errorf("recovery codes = %v, %v", settings.RecoveryCodes, err)
+	}
+}
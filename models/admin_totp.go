@@ -0,0 +1,133 @@
+package models
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+)
+
+// AdminTOTP 为管理员的二次验证设置，RecoveryCodes 保存恢复码的哈希
+type AdminTOTP struct {
+	Secret        string
+	Enabled       bool
+	RecoveryCodes []string
+	LastStep      int64
+}
+
+func GetAdminTOTP(username string) (*AdminTOTP, error) {
+	var t AdminTOTP
+	var codes string
+	err := DB.QueryRow(`
+		SELECT COALESCE(totp_secret, ''), COALESCE(totp_enabled, 0), COALESCE(totp_recovery_codes, ''), COALESCE(totp_last_step, 0)
+		FROM admins WHERE username=?
+	`, username).Scan(&t.Secret, &t.Enabled, &codes, &t.LastStep)
+	if err != nil {
+		return nil, err
+	}
+	if codes != "" {
+		if err := json.Unmarshal([]byte(codes), &t.RecoveryCodes); err != nil {
+			return nil, fmt.Errorf("恢复码数据损坏: %v", err)
+		}
+	}
+	return &t, nil
+}
+
+// SetAdminTOTPSecret 保存待确认的密钥，确认前不生效
+func SetAdminTOTPSecret(username, secret string) error {
+	_, err := DB.Exec(`
+		UPDATE admins SET totp_secret=?, totp_enabled=0, totp_recovery_codes='', totp_last_step=0, updated_at=CURRENT_TIMESTAMP
+		WHERE username=?
+	`, secret, username)
+	return err
+}
+
+func EnableAdminTOTP(username string, recoveryCodes []string) error {
+	codes, err := json.Marshal(recoveryCodes)
+	if err != nil {
+		return err
+	}
+	_, err = DB.Exec(`
+		UPDATE admins SET totp_enabled=1, totp_recovery_codes=?, updated_at=CURRENT_TIMESTAMP WHERE username=?
+	`, string(codes), username)
+	return err
+}
+
+func SetAdminRecoveryCodes(username string, recoveryCodes []string) error {
+	codes, err := json.Marshal(recoveryCodes)
+	if err != nil {
+		return err
+	}
+	_, err = DB.Exec("UPDATE admins SET totp_recovery_codes=? WHERE username=?", string(codes), username)
+	return err
+}
+
+// ClaimAdminRecoveryCode 作废哈希为 hash 的恢复码并返回剩余的恢复码。
+// 以读取到的恢复码列表作为更新条件，并发使用同一恢复码时只有一个请求返回 true
+func ClaimAdminRecoveryCode(username, hash string) ([]string, bool, error) {
+	for attempt := 0; attempt < 3; attempt++ {
+		var stored string
+		err := DB.QueryRow("SELECT COALESCE(totp_recovery_codes, '') FROM admins WHERE username=?", username).Scan(&stored)
+		if err != nil {
+			return nil, false, err
+		}
+		var codes []string
+		if stored != "" {
+			if err := json.Unmarshal([]byte(stored), &codes); err != nil {
+				return nil, false, fmt.Errorf("恢复码数据损坏: %v", err)
+			}
+		}
+
+		index := -1
+		for i, code := range codes {
+			if subtle.ConstantTimeCompare([]byte(code), []byte(hash)) == 1 {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return codes, false, nil
+		}
+
+		remaining := append(append([]string{}, codes[:index]...), codes[index+1:]...)
+		updated, err := json.Marshal(remaining)
+		if err != nil {
+			return nil, false, err
+		}
+		result, err := DB.Exec(`
+			UPDATE admins SET totp_recovery_codes=? WHERE username=? AND COALESCE(totp_recovery_codes, '')=?
+		`, string(updated), username, stored)
+		if err != nil {
+			return nil, false, err
+		}
+		if n, _ := result.RowsAffected(); n == 1 {
+			return remaining, true, nil
+		}
+		// 恢复码在读取后被其他请求修改，重新读取后再判断
+	}
+	return nil, false, nil
+}
+
+// ClaimAdminTOTPStep 记录最近一次使用的时间步，防止验证码被重放。
+// 只有时间步大于已记录的值时才更新，并发提交同一验证码时只有一个请求返回 true
+func ClaimAdminTOTPStep(username string, step int64) (bool, error) {
+	result, err := DB.Exec(`
+		UPDATE admins SET totp_last_step=? WHERE username=? AND COALESCE(totp_last_step, 0)<?
+	`, step, username, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// ResetAdminTOTP 清除管理员的二次验证设置，用于设备丢失后重新绑定
+func ResetAdminTOTP(username string) error {
+	_, err := DB.Exec(`
+		UPDATE admins SET totp_secret='', totp_enabled=0, totp_recovery_codes='', totp_last_step=0, updated_at=CURRENT_TIMESTAMP
+		WHERE username=?
+	`, username)
+	return err
+}
@@ -0,0 +1,32 @@
+package models
+
+import "log"
+
+const (
+	AuthActionWebLogin       = "web_login"
+	AuthActionWebLogout      = "web_logout"
+	AuthActionPasswordChange = "password_change"
+	AuthActionVPNLogin       = "vpn_login"
+	AuthActionVPNConnect     = "vpn_connect"
+	AuthActionVPNDisconnect  = "vpn_disconnect"
+	AuthActionAccountExpired = "account_expired"
+	AuthActionTOTPEnroll     = "totp_enroll"
+	AuthActionTOTPReset      = "totp_reset"
+	AuthActionVPNOTPEnroll   = "vpn_otp_enroll"
+	AuthActionVPNOTPReset    = "vpn_otp_reset"
+	AuthActionVPNOTPCode     = "vpn_otp_enroll_code"
+)
+
+func AddAuthLog(username, remoteIP, action string, success bool, message string) error {
+	_, err := DB.Exec(`
+		INSERT INTO auth_logs (username, remote_ip, action, success, message)
+		VALUES (?, ?, ?, ?, ?)
+	`, username, remoteIP, action, success, message)
+	return err
+}
+
+func LogAuthEvent(username, remoteIP, action string, success bool, message string) {
+	if err := AddAuthLog(username, remoteIP, action, success, message); err != nil {
+		log.Printf("记录认证日志失败: %v", err)
+	}
+}
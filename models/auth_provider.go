@@ -0,0 +1,136 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// GroupMapping 将外部认证源返回的组映射到本地用户组和管理员角色。
+// Group 可填写完整 DN 或其 CN，比较时不区分大小写；UserGroup、Role 留空表示不映射。
+type GroupMapping struct {
+	Group     string `json:"group"`
+	UserGroup string `json:"user_group"`
+	Role      string `json:"role"`
+}
+
+// AuthProvider 为外部认证源，Settings 为各类型自己的配置
+type AuthProvider struct {
+	ID             int             `json:"id"`
+	Name           string          `json:"name"`
+	Type           string          `json:"type"`
+	Enabled        bool            `json:"enabled"`
+	Priority       int             `json:"priority"`
+	VPNEnabled     bool            `json:"vpn_enabled"`
+	ConsoleEnabled bool            `json:"console_enabled"`
+	Settings       json.RawMessage `json:"settings"`
+	GroupMappings  []GroupMapping  `json:"group_mappings"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+func (p *AuthProvider) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("认证源名称不能为空")
+	}
+	for _, m := range p.GroupMappings {
+		if m.Group == "" {
+			return fmt.Errorf("组映射的目录组不能为空")
+		}
+		if m.Role != "" && !IsValidRole(m.Role) {
+			return fmt.Errorf("无效的角色: %s", m.Role)
+		}
+	}
+	return nil
+}
+
+func scanAuthProvider(scanner interface{ Scan(...interface{}) error }) (*AuthProvider, error) {
+	var p AuthProvider
+	var settings, mappings string
+	if err := scanner.Scan(&p.ID, &p.Name, &p.Type, &p.Enabled, &p.Priority, &p.VPNEnabled, &p.ConsoleEnabled,
+		&settings, &mappings, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if settings == "" {
+		settings = "{}"
+	}
+	p.Settings = json.RawMessage(settings)
+	if mappings != "" {
+		if err := json.Unmarshal([]byte(mappings), &p.GroupMappings); err != nil {
+			return nil, fmt.Errorf("认证源 %s 组映射数据损坏: %v", p.Name, err)
+		}
+	}
+	return &p, nil
+}
+
+const authProviderColumns = `id, name, type, enabled, priority, vpn_enabled, console_enabled,
+	COALESCE(settings, ''), COALESCE(group_mappings, ''), created_at, updated_at`
+
+func GetAuthProvider(id int) (*AuthProvider, error) {
+	return scanAuthProvider(DB.QueryRow("SELECT "+authProviderColumns+" FROM auth_providers WHERE id=?", id))
+}
+
+// ListAuthProviders 按优先级返回认证源，数值小的优先
+func ListAuthProviders() ([]AuthProvider, error) {
+	rows, err := DB.Query("SELECT " + authProviderColumns + " FROM auth_providers ORDER BY priority, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var providers []AuthProvider
+	for rows.Next() {
+		p, err := scanAuthProvider(rows)
+		if err != nil {
+			continue
+		}
+		providers = append(providers, *p)
+	}
+	return providers, nil
+}
+
+func SaveAuthProvider(p *AuthProvider) error {
+	mappings, err := json.Marshal(p.GroupMappings)
+	if err != nil {
+		return err
+	}
+	settings := string(p.Settings)
+	if settings == "" {
+		settings = "{}"
+	}
+
+	if p.ID == 0 {
+		result, err := DB.Exec(`
+			INSERT INTO auth_providers (name, type, enabled, priority, vpn_enabled, console_enabled, settings, group_mappings)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		`, p.Name, p.Type, p.Enabled, p.Priority, p.VPNEnabled, p.ConsoleEnabled, settings, string(mappings))
+		if err != nil {
+			return err
+		}
+		id, _ := result.LastInsertId()
+		p.ID = int(id)
+		return nil
+	}
+
+	_, err = DB.Exec(`
+		UPDATE auth_providers SET name=?, type=?, enabled=?, priority=?, vpn_enabled=?, console_enabled=?, settings=?, group_mappings=?,
+		       updated_at=CURRENT_TIMESTAMP
+		WHERE id=?
+	`, p.Name, p.Type, p.Enabled, p.Priority, p.VPNEnabled, p.ConsoleEnabled, settings, string(mappings), p.ID)
+	return err
+}
+
+// DeleteAuthProvider 删除认证源，仍有用户或管理员使用该认证源时拒绝删除
+func DeleteAuthProvider(id int) error {
+	var count int
+	if err := DB.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM users WHERE auth_provider_id=?) + (SELECT COUNT(*) FROM admins WHERE auth_provider_id=?)
+	`, id, id).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("仍有 %d 个账号使用该认证源，无法删除", count)
+	}
+	_, err := DB.Exec("DELETE FROM auth_providers WHERE id=?", id)
+	return err
+}
@@ -1,17 +1,37 @@
 package models
 
 import (
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
 	"time"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
+// defaultAdminPasswordHash 为初始化时写入的默认管理员密码 (admin123)
+const defaultAdminPasswordHash = "$2a$10$N9qo8uLOickgx2ZMRZoMye0J8YAR1WjxKRkzBCG.iHXE7BQOBZCVW"
+
 type UserGroup struct {
 	ID          int       `json:"id"`
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
 	IPPool      string    `json:"ip_pool"`
 	Routes      string    `json:"routes"`
+	NoRoutes    string    `json:"no_routes"`
+	DNS         string    `json:"dns"`
+	SplitTunnel bool      `json:"split_tunnel"`
 	Policies    string    `json:"policies"`
+	ScheduleID  int       `json:"schedule_id"`
+	MaxSessions int       `json:"max_sessions"`
+	SessionLimitPolicy string `json:"session_limit_policy"`
+	UploadLimit   int64   `json:"upload_limit"`
+	DownloadLimit int64   `json:"download_limit"`
+	DailyQuota    int64   `json:"daily_quota"`
+	MonthlyQuota  int64   `json:"monthly_quota"`
+	OTPRequired   bool    `json:"otp_required"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
@@ -19,13 +39,25 @@ type UserGroup struct {
 type User struct {
 	ID          int       `json:"id"`
 	Username    string    `json:"username"`
-	Password    string    `json:"-"`
+	Password    string    `json:"password,omitempty"`
 	FullName    string    `json:"full_name"`
 	Email       string    `json:"email"`
 	GroupID     int       `json:"group_id"`
 	GroupName   string    `json:"group_name"`
 	CustomRoutes string   `json:"custom_routes"`
 	CustomPolicies string `json:"custom_policies"`
+	ScheduleID  int       `json:"schedule_id"`
+	ValidFrom   *time.Time `json:"valid_from"`
+	ValidUntil  *time.Time `json:"valid_until"`
+	MaxSessions int       `json:"max_sessions"`
+	SessionLimitPolicy string `json:"session_limit_policy"`
+	UploadLimit   int64   `json:"upload_limit"`
+	DownloadLimit int64   `json:"download_limit"`
+	DailyQuota    int64   `json:"daily_quota"`
+	MonthlyQuota  int64   `json:"monthly_quota"`
+	OTPEnabled    bool    `json:"otp_enabled"`
+	AuthProviderID int    `json:"auth_provider_id"`
+	AuthProvider  string  `json:"auth_provider"`
 	Enabled     bool      `json:"enabled"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
@@ -33,6 +65,8 @@ type User struct {
 
 type OnlineUser struct {
 	ID           int       `json:"id"`
+	OCServID     int       `json:"ocserv_id"`
+	SessionID    string    `json:"session_id"`
 	Username     string    `json:"username"`
 	GroupName    string    `json:"group_name"`
 	MAC          string    `json:"mac"`
@@ -45,6 +79,8 @@ type OnlineUser struct {
 	DownloadSpeed int64    `json:"download_speed"`
 	TotalUpload  int64     `json:"total_upload"`
 	TotalDownload int64    `json:"total_download"`
+	UserAgent    string    `json:"user_agent"`
+	Hostname     string    `json:"hostname"`
 	ConnectedAt  time.Time `json:"connected_at"`
 }
 
@@ -97,10 +133,30 @@ func InitDB(dbPath string) error {
 		return err
 	}
 
+	if err := migrateTables(); err != nil {
+		return err
+	}
+
 	if err := initDefaultData(); err != nil {
 		return err
 	}
 
+	return disableLegacyAdminUser()
+}
+
+// disableLegacyAdminUser 停用旧版本在 users 表中创建的 admin 账号。
+// 管理员已迁移到 admins 表，该账号仍使用默认密码时会作为 VPN 账号写入 ocpasswd。
+func disableLegacyAdminUser() error {
+	res, err := DB.Exec(`
+		UPDATE users SET enabled=0, updated_at=CURRENT_TIMESTAMP
+		WHERE username='admin' AND password=? AND enabled=1
+	`, defaultAdminPasswordHash)
+	if err != nil {
+		return fmt.Errorf("停用默认 VPN 账号 admin 失败: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("警告: VPN 用户 admin 仍使用默认密码，已自动停用；如需使用请修改密码后重新启用")
+	}
 	return nil
 }
 
@@ -112,7 +168,18 @@ func createTables() error {
 		description TEXT,
 		ip_pool TEXT,
 		routes TEXT,
+		no_routes TEXT,
+		dns TEXT,
+		split_tunnel INTEGER DEFAULT 1,
 		policies TEXT,
+		schedule_id INTEGER DEFAULT 0,
+		max_sessions INTEGER DEFAULT 0,
+		session_limit_policy TEXT DEFAULT '',
+		upload_limit INTEGER DEFAULT 0,
+		download_limit INTEGER DEFAULT 0,
+		daily_quota INTEGER DEFAULT 0,
+		monthly_quota INTEGER DEFAULT 0,
+		otp_required INTEGER DEFAULT 0,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
@@ -126,12 +193,93 @@ func createTables() error {
 		group_id INTEGER,
 		custom_routes TEXT,
 		custom_policies TEXT,
+		schedule_id INTEGER DEFAULT 0,
+		valid_from DATETIME,
+		valid_until DATETIME,
+		max_sessions INTEGER DEFAULT 0,
+		session_limit_policy TEXT DEFAULT '',
+		upload_limit INTEGER DEFAULT 0,
+		download_limit INTEGER DEFAULT 0,
+		daily_quota INTEGER DEFAULT 0,
+		monthly_quota INTEGER DEFAULT 0,
+		otp_secret TEXT DEFAULT '',
+		otp_pending_secret TEXT DEFAULT '',
+		otp_enabled INTEGER DEFAULT 0,
+		otp_last_step INTEGER DEFAULT 0,
+		otp_enroll_code TEXT DEFAULT '',
+		otp_enroll_expires DATETIME,
+		auth_provider_id INTEGER DEFAULT 0,
+		external_id TEXT DEFAULT '',
 		enabled INTEGER DEFAULT 1,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		FOREIGN KEY (group_id) REFERENCES user_groups(id)
 	);
 
+	CREATE TABLE IF NOT EXISTS access_schedules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE,
+		description TEXT,
+		timezone TEXT NOT NULL DEFAULT 'Local',
+		windows TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS auth_providers (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE,
+		type TEXT NOT NULL,
+		enabled INTEGER DEFAULT 1,
+		priority INTEGER DEFAULT 0,
+		vpn_enabled INTEGER DEFAULT 1,
+		console_enabled INTEGER DEFAULT 0,
+		settings TEXT,
+		group_mappings TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS traffic_usage (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		username TEXT NOT NULL,
+		day TEXT NOT NULL,
+		upload_bytes INTEGER DEFAULT 0,
+		download_bytes INTEGER DEFAULT 0,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(username, day)
+	);
+
+	CREATE TABLE IF NOT EXISTS admins (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		username TEXT NOT NULL UNIQUE,
+		password TEXT NOT NULL,
+		full_name TEXT,
+		email TEXT,
+		role TEXT NOT NULL DEFAULT 'auditor',
+		enabled INTEGER DEFAULT 1,
+		totp_secret TEXT DEFAULT '',
+		totp_enabled INTEGER DEFAULT 0,
+		totp_recovery_codes TEXT DEFAULT '',
+		totp_last_step INTEGER DEFAULT 0,
+		auth_provider_id INTEGER DEFAULT 0,
+		external_id TEXT DEFAULT '',
+		break_glass INTEGER DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS admin_sessions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		token_hash TEXT NOT NULL UNIQUE,
+		username TEXT NOT NULL,
+		remote_ip TEXT,
+		user_agent TEXT,
+		created_at DATETIME,
+		last_seen_at DATETIME,
+		expires_at DATETIME
+	);
+
 	CREATE TABLE IF NOT EXISTS ip_allocations (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		group_id INTEGER NOT NULL,
@@ -144,6 +292,8 @@ func createTables() error {
 
 	CREATE TABLE IF NOT EXISTS online_users (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		ocserv_id INTEGER,
+		session_id TEXT,
 		username TEXT NOT NULL,
 		group_name TEXT,
 		mac TEXT,
@@ -156,9 +306,29 @@ func createTables() error {
 		download_speed INTEGER DEFAULT 0,
 		total_upload INTEGER DEFAULT 0,
 		total_download INTEGER DEFAULT 0,
+		user_agent TEXT,
+		hostname TEXT,
 		connected_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
 
+	CREATE TABLE IF NOT EXISTS session_history (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		ocserv_id INTEGER DEFAULT 0,
+		session_id TEXT DEFAULT '',
+		username TEXT NOT NULL,
+		group_name TEXT DEFAULT '',
+		virtual_ip TEXT DEFAULT '',
+		remote_ip TEXT DEFAULT '',
+		protocol TEXT DEFAULT '',
+		user_agent TEXT DEFAULT '',
+		bytes_in INTEGER DEFAULT 0,
+		bytes_out INTEGER DEFAULT 0,
+		connected_at DATETIME,
+		disconnected_at DATETIME,
+		duration INTEGER DEFAULT 0,
+		disconnect_reason TEXT DEFAULT ''
+	);
+
 	CREATE TABLE IF NOT EXISTS auth_logs (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		username TEXT NOT NULL,
@@ -191,8 +361,13 @@ func createTables() error {
 	);
 
 	CREATE INDEX IF NOT EXISTS idx_users_username ON users(username);
+	CREATE INDEX IF NOT EXISTS idx_admins_username ON admins(username);
+	CREATE INDEX IF NOT EXISTS idx_admin_sessions_username ON admin_sessions(username);
+	CREATE INDEX IF NOT EXISTS idx_admin_sessions_expires ON admin_sessions(expires_at);
 	CREATE INDEX IF NOT EXISTS idx_ip_allocations_username ON ip_allocations(username);
 	CREATE INDEX IF NOT EXISTS idx_online_users_username ON online_users(username);
+	CREATE INDEX IF NOT EXISTS idx_session_history_username ON session_history(username);
+	CREATE INDEX IF NOT EXISTS idx_session_history_disconnected ON session_history(disconnected_at);
 	CREATE INDEX IF NOT EXISTS idx_auth_logs_username ON auth_logs(username);
 	CREATE INDEX IF NOT EXISTS idx_auth_logs_created ON auth_logs(created_at);
 	CREATE INDEX IF NOT EXISTS idx_access_logs_username ON access_logs(username);
@@ -204,6 +379,155 @@ func createTables() error {
 	return err
 }
 
+func migrateTables() error {
+	columns := []struct {
+		Table      string
+		Column     string
+		Definition string
+	}{
+		{"online_users", "ocserv_id", "INTEGER"},
+		{"online_users", "session_id", "TEXT"},
+		{"online_users", "user_agent", "TEXT"},
+		{"online_users", "hostname", "TEXT"},
+		{"user_groups", "no_routes", "TEXT"},
+		{"user_groups", "dns", "TEXT"},
+		{"user_groups", "split_tunnel", "INTEGER DEFAULT 1"},
+		{"user_groups", "schedule_id", "INTEGER DEFAULT 0"},
+		{"users", "schedule_id", "INTEGER DEFAULT 0"},
+		{"users", "valid_from", "DATETIME"},
+		{"users", "valid_until", "DATETIME"},
+		{"user_groups", "max_sessions", "INTEGER DEFAULT 0"},
+		{"user_groups", "session_limit_policy", "TEXT DEFAULT ''"},
+		{"users", "max_sessions", "INTEGER DEFAULT 0"},
+		{"users", "session_limit_policy", "TEXT DEFAULT ''"},
+		{"user_groups", "upload_limit", "INTEGER DEFAULT 0"},
+		{"user_groups", "download_limit", "INTEGER DEFAULT 0"},
+		{"users", "upload_limit", "INTEGER DEFAULT 0"},
+		{"users", "download_limit", "INTEGER DEFAULT 0"},
+		{"user_groups", "daily_quota", "INTEGER DEFAULT 0"},
+		{"user_groups", "monthly_quota", "INTEGER DEFAULT 0"},
+		{"users", "daily_quota", "INTEGER DEFAULT 0"},
+		{"users", "monthly_quota", "INTEGER DEFAULT 0"},
+		{"admins", "totp_secret", "TEXT DEFAULT ''"},
+		{"admins", "totp_enabled", "INTEGER DEFAULT 0"},
+		{"admins", "totp_recovery_codes", "TEXT DEFAULT ''"},
+		{"admins", "totp_last_step", "INTEGER DEFAULT 0"},
+		{"user_groups", "otp_required", "INTEGER DEFAULT 0"},
+		{"users", "otp_secret", "TEXT DEFAULT ''"},
+		{"users", "otp_pending_secret", "TEXT DEFAULT ''"},
+		{"users", "otp_enabled", "INTEGER DEFAULT 0"},
+		{"users", "otp_last_step", "INTEGER DEFAULT 0"},
+		{"users", "otp_enroll_code", "TEXT DEFAULT ''"},
+		{"users", "otp_enroll_expires", "DATETIME"},
+		{"users", "auth_provider_id", "INTEGER DEFAULT 0"},
+		{"users", "external_id", "TEXT DEFAULT ''"},
+		{"admins", "auth_provider_id", "INTEGER DEFAULT 0"},
+		{"admins", "break_glass", "INTEGER DEFAULT 0"},
+		{"admins", "external_id", "TEXT DEFAULT ''"},
+	}
+
+	for _, col := range columns {
+		if err := addColumnIfNotExists(col.Table, col.Column, col.Definition); err != nil {
+			return fmt.Errorf("迁移 %s.%s 失败: %v", col.Table, col.Column, err)
+		}
+	}
+
+	// 旧版本按用户名识别外部管理员，LDAP/RADIUS 管理员暂以用户名作为外部标识，
+	// 下次登录时由 SaveExternalAdmin 替换为认证源返回的标识；OIDC 管理员缺少 sub，需删除后重新登录
+	_, err := DB.Exec(`
+		CREATE INDEX IF NOT EXISTS idx_online_users_ocserv_id ON online_users(ocserv_id);
+		UPDATE admins SET external_id=username
+		WHERE COALESCE(auth_provider_id, 0)<>0 AND COALESCE(external_id, '')=''
+		  AND auth_provider_id IN (SELECT id FROM auth_providers WHERE type<>'oidc');
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_admins_external_id ON admins(auth_provider_id, external_id) WHERE external_id<>'';
+	`)
+	return err
+}
+
+func addColumnIfNotExists(table, column, definition string) error {
+	rows, err := DB.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return err
+	}
+
+	exists := false
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			rows.Close()
+			return err
+		}
+		if name == column {
+			exists = true
+		}
+	}
+	rows.Close()
+
+	if exists {
+		return nil
+	}
+
+	_, err = DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// seedAdmin 在 admins 表为空时创建超级管理员 admin。
+// 旧版本的控制台账号保存在 users 表中，升级时沿用其中 admin 的密码；只有全新安装才使用默认密码，
+// 升级前已没有 admin 账号时生成随机密码并打印到日志。
+func seedAdmin() error {
+	var password string
+	err := DB.QueryRow("SELECT password FROM users WHERE username='admin'").Scan(&password)
+	switch {
+	case err == nil:
+		log.Printf("已将旧版本的管理员账号 admin 迁移到管理员表，密码保持不变")
+	case err == sql.ErrNoRows:
+		var users int
+		if err := DB.QueryRow("SELECT COUNT(*) FROM users").Scan(&users); err != nil {
+			return err
+		}
+		password = defaultAdminPasswordHash
+		if users > 0 {
+			buf := make([]byte, 12)
+			if _, err := rand.Read(buf); err != nil {
+				return err
+			}
+			plain := hex.EncodeToString(buf)
+			hash, err := bcrypt.GenerateFromPassword([]byte(plain), bcrypt.DefaultCost)
+			if err != nil {
+				return err
+			}
+			password = string(hash)
+			log.Printf("警告: 未找到旧版本的管理员账号，已创建超级管理员 admin，初始密码: %s，请登录后立即修改", plain)
+		}
+	default:
+		return err
+	}
+
+	_, err = DB.Exec(`
+		INSERT INTO admins (username, password, full_name, email, role, enabled)
+		VALUES ('admin', ?, '管理员', 'admin@example.com', ?, 1)
+	`, password, RoleSuperAdmin)
+	return err
+}
+
+// restoreLegacyAdminPassword 修复此前升级时 admins 表写入默认密码、丢失原管理员密码的情况
+func restoreLegacyAdminPassword() error {
+	res, err := DB.Exec(`
+		UPDATE admins SET password=(SELECT password FROM users WHERE username='admin'), updated_at=CURRENT_TIMESTAMP
+		WHERE username='admin' AND password=? AND COALESCE(auth_provider_id, 0)=0
+		  AND EXISTS (SELECT 1 FROM users WHERE username='admin' AND password<>?)
+	`, defaultAdminPasswordHash, defaultAdminPasswordHash)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("已恢复管理员 admin 在旧版本中设置的密码")
+	}
+	return nil
+}
+
 func initDefaultData() error {
 	var count int
 	err := DB.QueryRow("SELECT COUNT(*) FROM user_groups").Scan(&count)
@@ -221,19 +545,17 @@ func initDefaultData() error {
 		}
 	}
 
-	err = DB.QueryRow("SELECT COUNT(*) FROM users").Scan(&count)
+	err = DB.QueryRow("SELECT COUNT(*) FROM admins").Scan(&count)
 	if err != nil {
 		return err
 	}
 
 	if count == 0 {
-		_, err = DB.Exec(`
-			INSERT INTO users (username, password, full_name, email, group_id, enabled) 
-			VALUES ('admin', '$2a$10$N9qo8uLOickgx2ZMRZoMye0J8YAR1WjxKRkzBCG.iHXE7BQOBZCVW', '管理员', 'admin@example.com', 1, 1)
-		`)
-		if err != nil {
+		if err := seedAdmin(); err != nil {
 			return err
 		}
+	} else if err := restoreLegacyAdminPassword(); err != nil {
+		return err
 	}
 
 	err = DB.QueryRow("SELECT COUNT(*) FROM system_config").Scan(&count)
@@ -253,6 +575,8 @@ func initDefaultData() error {
 			{"default_mtu", "1400", "默认MTU值"},
 			{"max_clients", "100", "最大客户端连接数"},
 			{"idle_timeout", "3600", "空闲超时时间(秒)"},
+			{"max_same_clients", "2", "单个用户默认最大同时在线数"},
+			{"quota_reset_day", "1", "月流量配额重置日(1-28)"},
 			{"vpn_domain", "edge-vpn.local", "VPN域名"},
 			{"vpn_device", "vpns", "VPN虚拟网卡名称"},
 		}
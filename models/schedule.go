@@ -0,0 +1,212 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ScheduleWindow 为一个允许访问的时段，Days 取值 1(周一) 至 7(周日)。
+// Start 晚于 End 时表示跨越午夜，例如 22:00-06:00。
+type ScheduleWindow struct {
+	Days  []int  `json:"days"`
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+type AccessSchedule struct {
+	ID          int              `json:"id"`
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	Timezone    string           `json:"timezone"`
+	Windows     []ScheduleWindow `json:"windows"`
+	CreatedAt   time.Time        `json:"created_at"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+}
+
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("时间格式错误: %s，应为 HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (s *AccessSchedule) Validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("时间策略名称不能为空")
+	}
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		return fmt.Errorf("时区无效: %s", s.Timezone)
+	}
+	if len(s.Windows) == 0 {
+		return fmt.Errorf("至少需要一个允许访问的时段")
+	}
+	for _, w := range s.Windows {
+		if len(w.Days) == 0 {
+			return fmt.Errorf("时段必须指定星期")
+		}
+		for _, d := range w.Days {
+			if d < 1 || d > 7 {
+				return fmt.Errorf("星期取值应为 1-7: %d", d)
+			}
+		}
+		start, err := parseClock(w.Start)
+		if err != nil {
+			return err
+		}
+		end, err := parseClock(w.End)
+		if err != nil {
+			return err
+		}
+		if start == end {
+			return fmt.Errorf("时段开始与结束时间不能相同")
+		}
+	}
+	return nil
+}
+
+func isoWeekday(t time.Time) int {
+	if t.Weekday() == time.Sunday {
+		return 7
+	}
+	return int(t.Weekday())
+}
+
+func containsDay(days []int, day int) bool {
+	for _, d := range days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows 判断给定时刻是否处于允许访问的时段内
+func (s *AccessSchedule) Allows(t time.Time) bool {
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		loc = time.Local
+	}
+	local := t.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	today := isoWeekday(local)
+	yesterday := isoWeekday(local.AddDate(0, 0, -1))
+
+	for _, w := range s.Windows {
+		start, err1 := parseClock(w.Start)
+		end, err2 := parseClock(w.End)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		if start < end {
+			if containsDay(w.Days, today) && minute >= start && minute < end {
+				return true
+			}
+			continue
+		}
+		if containsDay(w.Days, today) && minute >= start {
+			return true
+		}
+		if containsDay(w.Days, yesterday) && minute < end {
+			return true
+		}
+	}
+	return false
+}
+
+func scanSchedule(scanner interface{ Scan(...interface{}) error }) (*AccessSchedule, error) {
+	var s AccessSchedule
+	var windows string
+	if err := scanner.Scan(&s.ID, &s.Name, &s.Description, &s.Timezone, &windows, &s.CreatedAt, &s.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(windows), &s.Windows); err != nil {
+		return nil, fmt.Errorf("时间策略 %s 数据损坏: %v", s.Name, err)
+	}
+	return &s, nil
+}
+
+const scheduleColumns = "id, name, COALESCE(description, ''), timezone, windows, created_at, updated_at"
+
+func GetSchedule(id int) (*AccessSchedule, error) {
+	return scanSchedule(DB.QueryRow("SELECT "+scheduleColumns+" FROM access_schedules WHERE id=?", id))
+}
+
+func ListSchedules() ([]AccessSchedule, error) {
+	rows, err := DB.Query("SELECT " + scheduleColumns + " FROM access_schedules ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var schedules []AccessSchedule
+	for rows.Next() {
+		s, err := scanSchedule(rows)
+		if err != nil {
+			continue
+		}
+		schedules = append(schedules, *s)
+	}
+	return schedules, nil
+}
+
+func SaveSchedule(s *AccessSchedule) error {
+	windows, err := json.Marshal(s.Windows)
+	if err != nil {
+		return err
+	}
+
+	if s.ID == 0 {
+		result, err := DB.Exec(`
+			INSERT INTO access_schedules (name, description, timezone, windows) VALUES (?, ?, ?, ?)
+		`, s.Name, s.Description, s.Timezone, string(windows))
+		if err != nil {
+			return err
+		}
+		id, _ := result.LastInsertId()
+		s.ID = int(id)
+		return nil
+	}
+
+	_, err = DB.Exec(`
+		UPDATE access_schedules SET name=?, description=?, timezone=?, windows=?, updated_at=CURRENT_TIMESTAMP WHERE id=?
+	`, s.Name, s.Description, s.Timezone, string(windows), s.ID)
+	return err
+}
+
+// DeleteSchedule 删除时间策略，并解除引用它的用户组与用户
+func DeleteSchedule(id int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	for _, stmt := range []string{
+		"UPDATE user_groups SET schedule_id=0 WHERE schedule_id=?",
+		"UPDATE users SET schedule_id=0 WHERE schedule_id=?",
+		"DELETE FROM access_schedules WHERE id=?",
+	} {
+		if _, err := tx.Exec(stmt, id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetEffectiveSchedule 返回用户生效的时间策略，用户自身的设置优先于用户组，均未设置时返回 nil
+func GetEffectiveSchedule(username string) (*AccessSchedule, error) {
+	var scheduleID int
+	err := DB.QueryRow(`
+		SELECT CASE WHEN COALESCE(u.schedule_id, 0) > 0 THEN u.schedule_id ELSE COALESCE(g.schedule_id, 0) END
+		FROM users u LEFT JOIN user_groups g ON g.id = u.group_id
+		WHERE u.username=?
+	`, username).Scan(&scheduleID)
+	if err != nil {
+		return nil, err
+	}
+	if scheduleID == 0 {
+		return nil, nil
+	}
+	return GetSchedule(scheduleID)
+}
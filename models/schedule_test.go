@@ -0,0 +1,96 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAccessScheduleAllows(t *testing.T) {
+	workdays := []int{1, 2, 3, 4, 5}
+	office := &AccessSchedule{
+		Timezone: "UTC",
+		Windows:  []ScheduleWindow{{Days: workdays, Start: "09:00", End: "18:00"}},
+	}
+	// 周一至周五晚间开始，跨越午夜到次日早上
+	overnight := &AccessSchedule{
+		Timezone: "UTC",
+		Windows:  []ScheduleWindow{{Days: workdays, Start: "22:00", End: "06:00"}},
+	}
+	shanghai := &AccessSchedule{
+		Timezone: "Asia/Shanghai",
+		Windows:  []ScheduleWindow{{Days: []int{1}, Start: "09:00", End: "10:00"}},
+	}
+	weekend := &AccessSchedule{
+		Timezone: "UTC",
+		Windows: []ScheduleWindow{
+			{Days: []int{6}, Start: "10:00", End: "12:00"},
+			{Days: []int{7}, Start: "23:30", End: "00:30"},
+		},
+	}
+
+	// 2026-10-19 为周一
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 10, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name     string
+		schedule *AccessSchedule
+		t        time.Time
+		want     bool
+	}{
+		{name: "工作时间内", schedule: office, t: at(19, 9, 0), want: true},
+		{name: "结束时刻不含", schedule: office, t: at(19, 18, 0), want: false},
+		{name: "工作时间前", schedule: office, t: at(19, 8, 59), want: false},
+		{name: "周六不在星期内", schedule: office, t: at(24, 10, 0), want: false},
+		{name: "周五", schedule: office, t: at(23, 17, 59), want: true},
+
+		{name: "跨午夜当天晚上", schedule: overnight, t: at(19, 23, 0), want: true},
+		{name: "跨午夜次日凌晨", schedule: overnight, t: at(20, 5, 59), want: true},
+		{name: "跨午夜结束时刻不含", schedule: overnight, t: at(20, 6, 0), want: false},
+		{name: "跨午夜白天", schedule: overnight, t: at(20, 12, 0), want: false},
+		{name: "周五晚延续到周六凌晨", schedule: overnight, t: at(24, 1, 0), want: true},
+		{name: "周六晚不在星期内", schedule: overnight, t: at(24, 23, 0), want: false},
+		{name: "周一凌晨属于周日晚的时段", schedule: overnight, t: at(19, 1, 0), want: false},
+
+		{name: "按时区换算", schedule: shanghai, t: at(19, 1, 30), want: true},
+		{name: "按时区换算后超出时段", schedule: shanghai, t: at(19, 9, 30), want: false},
+		{name: "UTC 周日晚为上海周一早", schedule: shanghai, t: at(18, 1, 0), want: false},
+
+		{name: "多个时段", schedule: weekend, t: at(24, 11, 0), want: true},
+		{name: "周日跨午夜", schedule: weekend, t: at(25, 23, 45), want: true},
+		{name: "周日跨午夜到周一", schedule: weekend, t: at(26, 0, 15), want: true},
+		{name: "周一白天", schedule: weekend, t: at(26, 11, 0), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.schedule.Allows(tt.t); got != tt.want {
+				t.Errorf("Allows(%s) = %v, want %v", tt.t.Format("Mon 2006-01-02 15:04 MST"), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccessScheduleValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule AccessSchedule
+		wantErr  bool
+	}{
+		{name: "有效", schedule: AccessSchedule{Name: "夜班", Timezone: "Asia/Shanghai", Windows: []ScheduleWindow{{Days: []int{1, 7}, Start: "22:00", End: "06:00"}}}},
+		{name: "缺少名称", schedule: AccessSchedule{Timezone: "UTC", Windows: []ScheduleWindow{{Days: []int{1}, Start: "09:00", End: "18:00"}}}, wantErr: true},
+		{name: "时区无效", schedule: AccessSchedule{Name: "a", Timezone: "Mars/Base", Windows: []ScheduleWindow{{Days: []int{1}, Start: "09:00", End: "18:00"}}}, wantErr: true},
+		{name: "没有时段", schedule: AccessSchedule{Name: "a", Timezone: "UTC"}, wantErr: true},
+		{name: "星期越界", schedule: AccessSchedule{Name: "a", Timezone: "UTC", Windows: []ScheduleWindow{{Days: []int{0}, Start: "09:00", End: "18:00"}}}, wantErr: true},
+		{name: "时间格式错误", schedule: AccessSchedule{Name: "a", Timezone: "UTC", Windows: []ScheduleWindow{{Days: []int{1}, Start: "9", End: "18:00"}}}, wantErr: true},
+		{name: "开始等于结束", schedule: AccessSchedule{Name: "a", Timezone: "UTC", Windows: []ScheduleWindow{{Days: []int{1}, Start: "09:00", End: "09:00"}}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.schedule.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+type SessionHistory struct {
+	ID               int       `json:"id"`
+	OCServID         int       `json:"ocserv_id"`
+	SessionID        string    `json:"session_id"`
+	Username         string    `json:"username"`
+	GroupName        string    `json:"group_name"`
+	VirtualIP        string    `json:"virtual_ip"`
+	RemoteIP         string    `json:"remote_ip"`
+	Protocol         string    `json:"protocol"`
+	UserAgent        string    `json:"user_agent"`
+	BytesIn          int64     `json:"bytes_in"`
+	BytesOut         int64     `json:"bytes_out"`
+	ConnectedAt      time.Time `json:"connected_at"`
+	DisconnectedAt   time.Time `json:"disconnected_at"`
+	Duration         int64     `json:"duration"`
+	DisconnectReason string    `json:"disconnect_reason"`
+}
+
+type SessionHistoryFilter struct {
+	Username string
+	Group    string
+	RemoteIP string
+	Start    time.Time
+	End      time.Time
+	Limit    int
+	Offset   int
+}
+
+func ArchiveOnlineSession(onlineID int, reason string, bytesIn, bytesOut int64) error {
+	var h SessionHistory
+	err := DB.QueryRow(`
+		SELECT COALESCE(ocserv_id, 0), COALESCE(session_id, ''), username, COALESCE(group_name, ''),
+		       COALESCE(virtual_ip, ''), COALESCE(remote_ip, ''), COALESCE(protocol, ''), COALESCE(user_agent, ''),
+		       total_upload, total_download, connected_at
+		FROM online_users WHERE id=?
+	`, onlineID).Scan(&h.OCServID, &h.SessionID, &h.Username, &h.GroupName, &h.VirtualIP, &h.RemoteIP,
+		&h.Protocol, &h.UserAgent, &h.BytesIn, &h.BytesOut, &h.ConnectedAt)
+	if err != nil {
+		return err
+	}
+
+	if bytesIn > h.BytesIn {
+		h.BytesIn = bytesIn
+	}
+	if bytesOut > h.BytesOut {
+		h.BytesOut = bytesOut
+	}
+
+	h.DisconnectedAt = time.Now().UTC()
+	h.Duration = int64(h.DisconnectedAt.Sub(h.ConnectedAt).Seconds())
+	if h.Duration < 0 {
+		h.Duration = 0
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO session_history
+		(ocserv_id, session_id, username, group_name, virtual_ip, remote_ip, protocol, user_agent,
+		 bytes_in, bytes_out, connected_at, disconnected_at, duration, disconnect_reason)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, h.OCServID, h.SessionID, h.Username, h.GroupName, h.VirtualIP, h.RemoteIP, h.Protocol, h.UserAgent,
+		h.BytesIn, h.BytesOut, h.ConnectedAt.UTC(), h.DisconnectedAt, h.Duration, reason)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM online_users WHERE id=?", onlineID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func QuerySessionHistory(filter SessionHistoryFilter) ([]SessionHistory, int, error) {
+	var conditions []string
+	var args []interface{}
+
+	if filter.Username != "" {
+		conditions = append(conditions, "username=?")
+		args = append(args, filter.Username)
+	}
+	if filter.Group != "" {
+		conditions = append(conditions, "group_name=?")
+		args = append(args, filter.Group)
+	}
+	if filter.RemoteIP != "" {
+		conditions = append(conditions, "remote_ip=?")
+		args = append(args, filter.RemoteIP)
+	}
+	if !filter.Start.IsZero() {
+		conditions = append(conditions, "disconnected_at >= ?")
+		args = append(args, filter.Start.UTC())
+	}
+	if !filter.End.IsZero() {
+		conditions = append(conditions, "connected_at <= ?")
+		args = append(args, filter.End.UTC())
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := DB.QueryRow("SELECT COUNT(*) FROM session_history "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := DB.Query(`
+		SELECT id, ocserv_id, session_id, username, group_name, virtual_ip, remote_ip, protocol, user_agent,
+		       bytes_in, bytes_out, connected_at, disconnected_at, duration, disconnect_reason
+		FROM session_history `+where+`
+		ORDER BY disconnected_at DESC
+		LIMIT ? OFFSET ?
+	`, append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var history []SessionHistory
+	for rows.Next() {
+		var h SessionHistory
+		if err := rows.Scan(&h.ID, &h.OCServID, &h.SessionID, &h.Username, &h.GroupName, &h.VirtualIP, &h.RemoteIP,
+			&h.Protocol, &h.UserAgent, &h.BytesIn, &h.BytesOut, &h.ConnectedAt, &h.DisconnectedAt,
+			&h.Duration, &h.DisconnectReason); err != nil {
+			continue
+		}
+		history = append(history, h)
+	}
+
+	return history, total, nil
+}
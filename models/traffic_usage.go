@@ -0,0 +1,117 @@
+package models
+
+import "time"
+
+const trafficDayFormat = "2006-01-02"
+
+// TrafficUsage 为用户某一天的累计流量，day 按服务器本地时区划分
+type TrafficUsage struct {
+	Username      string `json:"username"`
+	Day           string `json:"day"`
+	UploadBytes   int64  `json:"upload_bytes"`
+	DownloadBytes int64  `json:"download_bytes"`
+}
+
+// QuotaUsage 为用户当前周期的配额与用量，配额为 0 表示不限制
+type QuotaUsage struct {
+	Username       string    `json:"username"`
+	GroupName      string    `json:"group_name"`
+	DailyQuota     int64     `json:"daily_quota"`
+	MonthlyQuota   int64     `json:"monthly_quota"`
+	DailyUsed      int64     `json:"daily_used"`
+	MonthlyUsed    int64     `json:"monthly_used"`
+	DailyResetAt   time.Time `json:"daily_reset_at"`
+	MonthlyResetAt time.Time `json:"monthly_reset_at"`
+	Exceeded       bool      `json:"exceeded"`
+	ExceededReason string    `json:"exceeded_reason,omitempty"`
+}
+
+func AddTrafficUsage(username string, at time.Time, upload, download int64) error {
+	_, err := DB.Exec(`
+		INSERT INTO traffic_usage (username, day, upload_bytes, download_bytes) VALUES (?, ?, ?, ?)
+		ON CONFLICT(username, day) DO UPDATE SET
+			upload_bytes = upload_bytes + excluded.upload_bytes,
+			download_bytes = download_bytes + excluded.download_bytes,
+			updated_at = CURRENT_TIMESTAMP
+	`, username, at.Format(trafficDayFormat), upload, download)
+	return err
+}
+
+// QuotaPeriods 返回当前日周期与月周期的起止时间，月周期从系统配置 quota_reset_day 当天零点开始
+func QuotaPeriods(now time.Time) (dayStart, dayEnd, monthStart, monthEnd time.Time) {
+	resetDay := GetConfigInt("quota_reset_day", 1)
+	if resetDay < 1 || resetDay > 28 {
+		resetDay = 1
+	}
+
+	dayStart = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	dayEnd = dayStart.AddDate(0, 0, 1)
+
+	monthStart = time.Date(now.Year(), now.Month(), resetDay, 0, 0, 0, 0, now.Location())
+	if now.Day() < resetDay {
+		monthStart = monthStart.AddDate(0, -1, 0)
+	}
+	monthEnd = monthStart.AddDate(0, 1, 0)
+	return
+}
+
+// LoadQuotaUsage 查询用户的生效配额与当前周期用量，用户设置优先于用户组；username 为空时返回所有用户
+func LoadQuotaUsage(now time.Time, username string) ([]QuotaUsage, error) {
+	dayStart, dayEnd, monthStart, monthEnd := QuotaPeriods(now)
+
+	rows, err := DB.Query(`
+		SELECT u.username, COALESCE(g.name, ''),
+		       CASE WHEN COALESCE(u.daily_quota, 0) > 0 THEN u.daily_quota ELSE COALESCE(g.daily_quota, 0) END,
+		       CASE WHEN COALESCE(u.monthly_quota, 0) > 0 THEN u.monthly_quota ELSE COALESCE(g.monthly_quota, 0) END,
+		       COALESCE((SELECT SUM(upload_bytes + download_bytes) FROM traffic_usage t WHERE t.username = u.username AND t.day = ?), 0),
+		       COALESCE((SELECT SUM(upload_bytes + download_bytes) FROM traffic_usage t WHERE t.username = u.username AND t.day >= ?), 0)
+		FROM users u LEFT JOIN user_groups g ON g.id = u.group_id
+		WHERE ? = '' OR u.username = ?
+		ORDER BY u.username
+	`, dayStart.Format(trafficDayFormat), monthStart.Format(trafficDayFormat), username, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usages := []QuotaUsage{}
+	for rows.Next() {
+		var q QuotaUsage
+		if err := rows.Scan(&q.Username, &q.GroupName, &q.DailyQuota, &q.MonthlyQuota, &q.DailyUsed, &q.MonthlyUsed); err != nil {
+			continue
+		}
+		q.DailyResetAt = dayEnd
+		q.MonthlyResetAt = monthEnd
+		if q.DailyQuota > 0 && q.DailyUsed >= q.DailyQuota {
+			q.Exceeded = true
+			q.ExceededReason = "今日流量配额已用尽"
+		}
+		if q.MonthlyQuota > 0 && q.MonthlyUsed >= q.MonthlyQuota {
+			q.Exceeded = true
+			q.ExceededReason = "本月流量配额已用尽"
+		}
+		usages = append(usages, q)
+	}
+	return usages, rows.Err()
+}
+
+func GetTrafficUsageHistory(username string, since time.Time) ([]TrafficUsage, error) {
+	rows, err := DB.Query(`
+		SELECT username, day, upload_bytes, download_bytes FROM traffic_usage
+		WHERE username=? AND day >= ? ORDER BY day
+	`, username, since.Format(trafficDayFormat))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []TrafficUsage{}
+	for rows.Next() {
+		var t TrafficUsage
+		if err := rows.Scan(&t.Username, &t.Day, &t.UploadBytes, &t.DownloadBytes); err != nil {
+			continue
+		}
+		history = append(history, t)
+	}
+	return history, rows.Err()
+}
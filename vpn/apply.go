@@ -0,0 +1,152 @@
+package vpn
+
+import (
+	"edge_server/models"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ApplyMethodFiles   = "files"
+	ApplyMethodReload  = "reload"
+	ApplyMethodRestart = "restart"
+)
+
+type ApplyStatus struct {
+	InProgress bool      `json:"in_progress"`
+	Success    bool      `json:"success"`
+	Method     string    `json:"method"`
+	Trigger    string    `json:"trigger"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+var (
+	defaultServer *OCServServer
+	serverMu      sync.RWMutex
+	applyMu       sync.Mutex
+	applyStatus   ApplyStatus
+	applyStatusMu sync.RWMutex
+)
+
+func SetDefaultServer(s *OCServServer) {
+	serverMu.Lock()
+	defaultServer = s
+	serverMu.Unlock()
+}
+
+func DefaultServer() *OCServServer {
+	serverMu.RLock()
+	defer serverMu.RUnlock()
+	return defaultServer
+}
+
+func GetApplyStatus() ApplyStatus {
+	applyStatusMu.RLock()
+	defer applyStatusMu.RUnlock()
+	return applyStatus
+}
+
+func setApplyStatus(status ApplyStatus) {
+	applyStatusMu.Lock()
+	applyStatus = status
+	applyStatusMu.Unlock()
+}
+
+func loadSettingsFromDB(cfg *OCServConfig) {
+	cfg.IPPool = models.GetConfig("default_ip_pool", cfg.IPPool)
+
+	dns1 := models.GetConfig("default_dns1", "")
+	dns2 := models.GetConfig("default_dns2", "")
+	if dns1 != "" || dns2 != "" {
+		var dns []string
+		for _, d := range []string{dns1, dns2} {
+			if d != "" {
+				dns = append(dns, d)
+			}
+		}
+		cfg.DNS = dns
+	}
+
+	if mtu := models.GetConfigInt("default_mtu", cfg.MTU); mtu > 0 {
+		cfg.MTU = mtu
+	}
+	if maxClients := models.GetConfigInt("max_clients", cfg.MaxClients); maxClients > 0 {
+		cfg.MaxClients = maxClients
+	}
+	if idleTimeout := models.GetConfigInt("idle_timeout", cfg.IdleTimeout); idleTimeout > 0 {
+		cfg.IdleTimeout = idleTimeout
+	}
+	cfg.Domain = models.GetConfig("vpn_domain", cfg.Domain)
+	cfg.Device = models.GetConfig("vpn_device", cfg.Device)
+}
+
+// ApplyConfig 根据数据库中的最新配置重新生成 ocserv 相关文件并使其生效。
+// 端口或网卡变更时需要重启 ocserv，其余配置通过 occtl reload 热加载。
+func ApplyConfig(trigger string) ApplyStatus {
+	applyMu.Lock()
+	defer applyMu.Unlock()
+
+	status := ApplyStatus{InProgress: true, Trigger: trigger, StartedAt: time.Now()}
+	setApplyStatus(status)
+
+	method, err := applyToServer(DefaultServer())
+
+	status.InProgress = false
+	status.Method = method
+	status.FinishedAt = time.Now()
+	status.Success = err == nil
+	if err != nil {
+		status.Error = err.Error()
+		log.Printf("应用VPN配置失败 (%s): %v", trigger, err)
+	} else {
+		log.Printf("VPN配置已应用 (%s, 方式: %s)", trigger, method)
+	}
+	setApplyStatus(status)
+
+	return status
+}
+
+func applyToServer(s *OCServServer) (string, error) {
+	if s == nil {
+		if err := SyncCredentials(); err != nil {
+			return ApplyMethodFiles, err
+		}
+		return ApplyMethodFiles, nil
+	}
+
+	s.mu.Lock()
+	previous := *s.config
+	loadSettingsFromDB(s.config)
+	needRestart := previous.ListenAddr != s.config.ListenAddr || previous.Device != s.config.Device
+
+	if err := s.prepareConfig(); err != nil {
+		*s.config = previous
+		s.mu.Unlock()
+		return ApplyMethodFiles, err
+	}
+	running := s.running
+	idleTimeout := s.config.IdleTimeout
+	s.mu.Unlock()
+
+	SetIdleTimeout(idleTimeout)
+
+	if !running {
+		return ApplyMethodFiles, nil
+	}
+
+	if needRestart {
+		return ApplyMethodRestart, s.Restart()
+	}
+
+	output, err := exec.Command("occtl", "reload").CombinedOutput()
+	if err != nil {
+		return ApplyMethodReload, fmt.Errorf("occtl reload 失败: %s", strings.TrimSpace(string(output)))
+	}
+	return ApplyMethodReload, nil
+}
@@ -0,0 +1,91 @@
+package vpn
+
+import (
+	"database/sql"
+	"edge_server/models"
+	"fmt"
+	"time"
+)
+
+const (
+	AuthModePlain = "plain"
+	AuthModePAM   = "pam"
+)
+
+func AuthenticateUser(username, password, remoteIP string) error {
+	err := checkUserCredentials(username, password)
+
+	message := "认证成功"
+	if err != nil {
+		message = err.Error()
+	}
+	models.LogAuthEvent(username, remoteIP, models.AuthActionVPNLogin, err == nil, message)
+
+	return err
+}
+
+func CheckUserAccount(username string) error {
+	var enabled bool
+	err := models.DB.QueryRow("SELECT enabled FROM users WHERE username=?", username).Scan(&enabled)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("用户不存在")
+	}
+	if err != nil {
+		return fmt.Errorf("查询用户失败: %v", err)
+	}
+	if !enabled {
+		return fmt.Errorf("用户已被禁用")
+	}
+	return checkAccountRestrictions(username, time.Now())
+}
+
+// checkAccountRestrictions 检查密码之外的账户限制，认证与 PAM account 阶段共用
+func checkAccountRestrictions(username string, now time.Time) error {
+	if err := checkUserValidity(username, now); err != nil {
+		return err
+	}
+	if err := checkUserSchedule(username, now); err != nil {
+		return err
+	}
+	if err := checkUserQuota(username, now); err != nil {
+		return err
+	}
+	if err := checkSessionLimit(username); err != nil {
+		return err
+	}
+	return nil
+}
+
+func checkUserCredentials(username, password string) error {
+	if username == "" || password == "" {
+		return fmt.Errorf("用户名或密码为空")
+	}
+
+	var enabled bool
+	err := models.DB.QueryRow("SELECT enabled FROM users WHERE username=?", username).Scan(&enabled)
+	if err == sql.ErrNoRows {
+		// 本地不存在的用户交给外部认证源，认证成功后自动创建本地记录
+		if err := provisionExternalUser(username, password); err != nil {
+			return err
+		}
+		if err := checkPasswordWithOTP(username, password, func(string) error { return nil }); err != nil {
+			return err
+		}
+		return checkAccountRestrictions(username, time.Now())
+	}
+	if err != nil {
+		return fmt.Errorf("查询用户失败: %v", err)
+	}
+
+	if !enabled {
+		return fmt.Errorf("用户已被禁用")
+	}
+
+	if err := checkPasswordWithOTP(username, password, func(pw string) error {
+		return verifyUserPassword(username, pw)
+	}); err != nil {
+		return err
+	}
+
+	return checkAccountRestrictions(username, time.Now())
+}
@@ -0,0 +1,136 @@
+package vpn
+
+import (
+	"bytes"
+	"database/sql"
+	"edge_server/models"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const passwdPath = "/run/ocserv/ocpasswd"
+
+var (
+	credentialMu    sync.Mutex
+	usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._@-]+$`)
+)
+
+// ValidateUsername 校验 VPN 用户名，用户名按 用户名:组:密码 的格式逐行写入 ocpasswd，不允许冒号、换行等字符
+func ValidateUsername(username string) error {
+	if !usernamePattern.MatchString(username) {
+		return fmt.Errorf("用户名只能包含字母、数字和 . _ @ -")
+	}
+	return nil
+}
+
+// GeneratePasswordFile 通过临时文件加重命名的方式原子地重写 ocpasswd，ocserv 不会读到写了一半的文件
+func GeneratePasswordFile(path string, userConfigs map[string]bool) error {
+	now := time.Now()
+	blocked := scheduleBlockedUsers(now)
+	for username := range quotaExceededUsers(now) {
+		blocked[username] = true
+	}
+	for username := range otpRequiredUsers() {
+		blocked[username] = true
+	}
+	for username := range externalUsers() {
+		blocked[username] = true
+	}
+
+	rows, err := models.DB.Query(`
+		SELECT u.username, u.password, COALESCE(g.id, 0), u.valid_from, u.valid_until
+		FROM users u LEFT JOIN user_groups g ON g.id = u.group_id
+		WHERE u.enabled=1
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var buf bytes.Buffer
+	for rows.Next() {
+		var username, password string
+		var groupID int
+		var validFrom, validUntil sql.NullTime
+		if err := rows.Scan(&username, &password, &groupID, &validFrom, &validUntil); err != nil || blocked[username] {
+			continue
+		}
+		if accountValidity(validFrom, validUntil, now) != nil {
+			continue
+		}
+		if ValidateUsername(username) != nil || strings.ContainsAny(password, ":\r\n") {
+			log.Printf("用户 %q 的用户名或密码包含非法字符，不写入 ocpasswd", username)
+			continue
+		}
+		group := "*"
+		if groupID > 0 && !userConfigs[username] {
+			group = GroupConfigName(groupID)
+		}
+		fmt.Fprintf(&buf, "%s:%s:%s\n", username, group, password)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, buf.Bytes(), 0600)
+}
+
+// SyncCredentials 在用户增删改后刷新 config-per-user 文件和 ocpasswd
+func SyncCredentials() error {
+	credentialMu.Lock()
+	defer credentialMu.Unlock()
+
+	userConfigMu.Lock()
+	configDir, authMode := ocservConfigDir, ocservAuthMode
+	userConfigMu.Unlock()
+
+	// ocserv 尚未生成过配置时无需同步，启动时会完整生成
+	if configDir == "" {
+		return nil
+	}
+
+	groups, err := LoadGroupConfigs()
+	if err != nil {
+		return err
+	}
+	return regenerateUserCredentials(configDir, authMode, groups)
+}
+
+// regenerateUserCredentials 生成 config-per-user 文件后重写 ocpasswd。
+// ocpasswd 中的用户组字段取决于用户是否有单独的配置文件，两者必须一起生成，调用方需持有 credentialMu
+func regenerateUserCredentials(configDir, authMode string, groups map[int]*GroupConfig) error {
+	userConfigs, err := GenerateUserConfigs(configDir, authMode, groups)
+	if err != nil {
+		return err
+	}
+
+	if err := GeneratePasswordFile(passwdPath, userConfigs); err != nil {
+		return fmt.Errorf("生成密码文件失败: %v", err)
+	}
+	return nil
+}
+
+// RevokeUserSessions 断开被禁用或删除用户的所有 VPN 会话
+func RevokeUserSessions(username, reason string) {
+	var online int
+	models.DB.QueryRow("SELECT COUNT(*) FROM online_users WHERE username=?", username).Scan(&online)
+	if online == 0 {
+		return
+	}
+
+	MarkDisconnectReason(username, reason)
+	if err := DisconnectUserByOCCtl(username); err != nil {
+		log.Printf("断开用户 %s 的会话失败: %v", username, err)
+		return
+	}
+	log.Printf("已断开用户 %s 的会话: %s", username, reason)
+}
@@ -0,0 +1,120 @@
+package vpn
+
+import (
+	"edge_server/models"
+	"log"
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventUserAuthenticated EventType = "user_authenticated"
+	EventAuthFailed        EventType = "auth_failed"
+	EventSessionOpened     EventType = "session_opened"
+	EventDTLSEstablished   EventType = "dtls_established"
+	EventSessionClosed     EventType = "session_closed"
+	EventIPBanned          EventType = "ip_banned"
+	EventOnlineUserAdded   EventType = "online_user_added"
+	EventOnlineUserRemoved EventType = "online_user_removed"
+	EventTrafficUpdate     EventType = "traffic_update"
+)
+
+type SessionTraffic struct {
+	OCServID      int    `json:"ocserv_id"`
+	Username      string `json:"username"`
+	UploadSpeed   int64  `json:"upload_speed"`
+	DownloadSpeed int64  `json:"download_speed"`
+	TotalUpload   int64  `json:"total_upload"`
+	TotalDownload int64  `json:"total_download"`
+}
+
+type Event struct {
+	Type      EventType        `json:"type"`
+	Time      time.Time        `json:"time"`
+	Username  string           `json:"username,omitempty"`
+	RemoteIP  string           `json:"remote_ip,omitempty"`
+	VirtualIP string           `json:"virtual_ip,omitempty"`
+	OCServID  int              `json:"ocserv_id,omitempty"`
+	SessionID string           `json:"session_id,omitempty"`
+	Reason    string           `json:"reason,omitempty"`
+	BytesRX   int64            `json:"bytes_rx,omitempty"`
+	BytesTX   int64            `json:"bytes_tx,omitempty"`
+	MTU       int              `json:"mtu,omitempty"`
+	Traffic   []SessionTraffic `json:"traffic,omitempty"`
+	Raw       string           `json:"-"`
+}
+
+type EventBus struct {
+	mu          sync.RWMutex
+	subscribers map[int]chan Event
+	nextID      int
+}
+
+var Events = NewEventBus()
+
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subscribers: make(map[int]chan Event),
+	}
+}
+
+func (b *EventBus) Subscribe(buffer int) (int, <-chan Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	ch := make(chan Event, buffer)
+	b.subscribers[b.nextID] = ch
+	return b.nextID, ch
+}
+
+func (b *EventBus) Unsubscribe(id int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if ch, exists := b.subscribers[id]; exists {
+		close(ch)
+		delete(b.subscribers, id)
+	}
+}
+
+func (b *EventBus) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for id, ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			log.Printf("事件订阅者 %d 队列已满，丢弃事件: %s", id, event.Type)
+		}
+	}
+}
+
+func StartAuthEventLogger(authMode string) {
+	_, events := Events.Subscribe(256)
+	go func() {
+		for event := range events {
+			switch event.Type {
+			case EventSessionOpened:
+				models.LogAuthEvent(event.Username, event.RemoteIP, models.AuthActionVPNConnect, true, "VPN会话已建立")
+			case EventSessionClosed:
+				models.LogAuthEvent(event.Username, event.RemoteIP, models.AuthActionVPNDisconnect, true, "VPN会话已断开: "+event.Reason)
+			case EventUserAuthenticated:
+				if authMode != AuthModePAM {
+					models.LogAuthEvent(event.Username, event.RemoteIP, models.AuthActionVPNLogin, true, "认证成功")
+				}
+			case EventAuthFailed:
+				if authMode != AuthModePAM {
+					models.LogAuthEvent(event.Username, event.RemoteIP, models.AuthActionVPNLogin, false, "认证失败")
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,106 @@
+package vpn
+
+import (
+	"database/sql"
+	"edge_server/models"
+	"fmt"
+	"log"
+	"time"
+)
+
+const expiryCheckInterval = time.Minute
+
+// accountValidity 检查账号在给定时刻是否处于有效期内，未设置的一端不做限制
+func accountValidity(validFrom, validUntil sql.NullTime, now time.Time) error {
+	if validFrom.Valid && now.Before(validFrom.Time) {
+		return fmt.Errorf("账号尚未生效，生效时间 %s", validFrom.Time.Local().Format("2006-01-02 15:04"))
+	}
+	if validUntil.Valid && !now.Before(validUntil.Time) {
+		return fmt.Errorf("账号已过期，到期时间 %s", validUntil.Time.Local().Format("2006-01-02 15:04"))
+	}
+	return nil
+}
+
+func checkUserValidity(username string, now time.Time) error {
+	var validFrom, validUntil sql.NullTime
+	err := models.DB.QueryRow("SELECT valid_from, valid_until FROM users WHERE username=?", username).Scan(&validFrom, &validUntil)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("查询账号有效期失败: %v", err)
+	}
+	return accountValidity(validFrom, validUntil, now)
+}
+
+// disableExpiredUsers 禁用已过期的账号，返回被禁用的用户名；同时返回尚未生效的用户集合
+func disableExpiredUsers(now time.Time) ([]string, map[string]bool) {
+	pending := make(map[string]bool)
+
+	rows, err := models.DB.Query(`
+		SELECT id, username, valid_from, valid_until FROM users
+		WHERE enabled=1 AND (valid_from IS NOT NULL OR valid_until IS NOT NULL)
+	`)
+	if err != nil {
+		log.Printf("查询账号有效期失败: %v", err)
+		return nil, pending
+	}
+
+	type expiredUser struct {
+		ID       int
+		Username string
+		Until    time.Time
+	}
+	var expired []expiredUser
+	for rows.Next() {
+		var id int
+		var username string
+		var validFrom, validUntil sql.NullTime
+		if err := rows.Scan(&id, &username, &validFrom, &validUntil); err != nil {
+			continue
+		}
+		if validUntil.Valid && !now.Before(validUntil.Time) {
+			expired = append(expired, expiredUser{ID: id, Username: username, Until: validUntil.Time})
+		} else if validFrom.Valid && now.Before(validFrom.Time) {
+			pending[username] = true
+		}
+	}
+	rows.Close()
+
+	var disabled []string
+	for _, u := range expired {
+		if _, err := models.DB.Exec("UPDATE users SET enabled=0, updated_at=CURRENT_TIMESTAMP WHERE id=?", u.ID); err != nil {
+			log.Printf("禁用过期账号 %s 失败: %v", u.Username, err)
+			continue
+		}
+		message := fmt.Sprintf("账号已于 %s 到期，已自动禁用", u.Until.Local().Format("2006-01-02 15:04"))
+		log.Printf("用户 %s %s", u.Username, message)
+		models.LogAuthEvent(u.Username, "", models.AuthActionAccountExpired, true, message)
+		disabled = append(disabled, u.Username)
+	}
+	return disabled, pending
+}
+
+// StartExpiryEnforcer 定期禁用到期账号并断开其会话，账号生效时刷新 ocpasswd
+func StartExpiryEnforcer() {
+	check := func(now time.Time, lastPending map[string]bool) map[string]bool {
+		disabled, pending := disableExpiredUsers(now)
+		if len(disabled) > 0 || lastPending == nil || !sameUserSet(pending, lastPending) {
+			if err := SyncCredentials(); err != nil {
+				log.Printf("按账号有效期同步凭据失败: %v", err)
+			}
+		}
+		for _, username := range disabled {
+			RevokeUserSessions(username, "账号已过期")
+		}
+		return pending
+	}
+
+	pending := check(time.Now(), nil)
+	ticker := time.NewTicker(expiryCheckInterval)
+	go func() {
+		for now := range ticker.C {
+			pending = check(now, pending)
+		}
+	}()
+}
@@ -0,0 +1,159 @@
+package vpn
+
+import (
+	"database/sql"
+	"edge_server/auth"
+	"edge_server/models"
+	"errors"
+	"fmt"
+	"log"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// externalPasswordPlaceholder 不是合法的 bcrypt 哈希，外部认证用户无法使用本地密码登录
+const externalPasswordPlaceholder = "!external"
+
+// UseOcservConfigDir 供 auth 子命令设置 ocserv 配置目录，使自动创建的用户能立即生成 config-per-user 文件
+func UseOcservConfigDir(configDir, authMode string) {
+	userConfigMu.Lock()
+	defer userConfigMu.Unlock()
+	ocservConfigDir = configDir
+	ocservAuthMode = authMode
+}
+
+func externalAuthError(err error) error {
+	switch {
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		return fmt.Errorf("密码错误")
+	case errors.Is(err, auth.ErrUserNotFound):
+		return fmt.Errorf("用户不存在")
+	}
+	return err
+}
+
+// mappedGroupID 返回组映射得到的本地用户组，认证源配置了用户组映射但用户不属于任何映射组时拒绝登录
+func mappedGroupID(result *auth.Result) (int, bool, error) {
+	if result.UserGroup == "" {
+		for _, m := range result.Provider.GroupMappings {
+			if m.UserGroup != "" {
+				return 0, false, fmt.Errorf("用户不属于认证源 %s 中已映射的组", result.Provider.Name)
+			}
+		}
+		return 0, false, nil
+	}
+
+	var groupID int
+	err := models.DB.QueryRow("SELECT id FROM user_groups WHERE name=?", result.UserGroup).Scan(&groupID)
+	if err != nil {
+		return 0, false, fmt.Errorf("映射的用户组 %s 不存在", result.UserGroup)
+	}
+	return groupID, true, nil
+}
+
+// verifyUserPassword 校验本地用户的密码，绑定了认证源的用户交给对应认证源校验并同步用户信息
+func verifyUserPassword(username, password string) error {
+	var storedPassword string
+	var providerID int
+	err := models.DB.QueryRow("SELECT password, COALESCE(auth_provider_id, 0) FROM users WHERE username=?", username).
+		Scan(&storedPassword, &providerID)
+	if err != nil {
+		return fmt.Errorf("查询用户失败: %v", err)
+	}
+
+	if providerID == 0 {
+		if err := bcrypt.CompareHashAndPassword([]byte(storedPassword), []byte(password)); err != nil {
+			return fmt.Errorf("密码错误")
+		}
+		return nil
+	}
+
+	result, err := auth.AuthenticateWith(providerID, auth.ScopeVPN, username, password)
+	if err != nil {
+		return externalAuthError(err)
+	}
+	groupID, mapped, err := mappedGroupID(result)
+	if err != nil {
+		return err
+	}
+
+	var changed bool
+	if mapped {
+		res, err := models.DB.Exec("UPDATE users SET group_id=?, updated_at=CURRENT_TIMESTAMP WHERE username=? AND COALESCE(group_id, 0)<>?",
+			groupID, username, groupID)
+		if err == nil {
+			n, _ := res.RowsAffected()
+			changed = n > 0
+		}
+	}
+	models.DB.Exec("UPDATE users SET full_name=?, email=?, external_id=? WHERE username=?",
+		result.Identity.FullName, result.Identity.Email, result.Identity.ExternalID, username)
+
+	if changed {
+		log.Printf("用户 %s 的目录组变化，已按认证源 %s 更新用户组", username, result.Provider.Name)
+		if err := SyncCredentials(); err != nil {
+			log.Printf("同步VPN用户凭据失败: %v", err)
+		}
+	}
+	return nil
+}
+
+// provisionExternalUser 在本地不存在该用户时依次尝试外部认证源，认证成功后创建本地用户记录
+func provisionExternalUser(username, password string) error {
+	if err := ValidateUsername(username); err != nil {
+		return err
+	}
+	result, err := auth.Authenticate(auth.ScopeVPN, username, password)
+	if err != nil {
+		return externalAuthError(err)
+	}
+	groupID, _, err := mappedGroupID(result)
+	if err != nil {
+		return err
+	}
+
+	// 以不同大小写的用户名登录同一目录账号时不再创建新用户，会话、配额等均按已有用户名记录
+	var existing string
+	err = models.DB.QueryRow(`
+		SELECT username FROM users
+		WHERE auth_provider_id=? AND (external_id=? OR (COALESCE(external_id, '')='' AND LOWER(username)=LOWER(?)))
+	`, result.Provider.ID, result.Identity.ExternalID, username).Scan(&existing)
+	if err == nil {
+		return fmt.Errorf("该账号已存在，请使用用户名 %s 登录", existing)
+	}
+	if err != sql.ErrNoRows {
+		return fmt.Errorf("查询用户失败: %v", err)
+	}
+
+	_, err = models.DB.Exec(`
+		INSERT INTO users (username, password, full_name, email, group_id, auth_provider_id, external_id, enabled)
+		VALUES (?, ?, ?, ?, ?, ?, ?, 1)
+	`, username, externalPasswordPlaceholder, result.Identity.FullName, result.Identity.Email, groupID, result.Provider.ID, result.Identity.ExternalID)
+	if err != nil {
+		return fmt.Errorf("创建用户失败: %v", err)
+	}
+
+	log.Printf("通过认证源 %s 自动创建用户 %s", result.Provider.Name, username)
+	if err := SyncCredentials(); err != nil {
+		log.Printf("同步VPN用户凭据失败: %v", err)
+	}
+	return nil
+}
+
+// externalUsers 返回绑定了外部认证源的用户，ocserv 无法校验其密码，不写入 ocpasswd
+func externalUsers() map[string]bool {
+	users := make(map[string]bool)
+	rows, err := models.DB.Query("SELECT username FROM users WHERE COALESCE(auth_provider_id, 0) > 0")
+	if err != nil {
+		return users
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err == nil {
+			users[username] = true
+		}
+	}
+	return users
+}
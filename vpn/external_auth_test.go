@@ -0,0 +1,58 @@
+package vpn
+
+import (
+	"edge_server/auth"
+	"edge_server/models"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestProvisionExternalUserIgnoresCase(t *testing.T) {
+	if err := models.InitDB(filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	defer models.DB.Close()
+	UseOcservConfigDir(t.TempDir(), "")
+
+	authAddr, acctAddr := freeUDPAddr(t), freeUDPAddr(t)
+	// 模拟不区分用户名大小写的 RADIUS 服务器
+	users := map[string]auth.RADIUSStubUser{"alice": {Password: "pw"}, "Alice": {Password: "pw"}}
+	go auth.RunRADIUSStub(authAddr, acctAddr, "secret", users)
+	time.Sleep(100 * time.Millisecond)
+
+	settings, _ := json.Marshal(auth.RADIUSSettings{Servers: []auth.RADIUSServer{{Address: authAddr, Secret: "secret"}}, Timeout: 2})
+	provider := &models.AuthProvider{Name: "radius", Type: "radius", Enabled: true, VPNEnabled: true, Settings: settings}
+	if err := models.SaveAuthProvider(provider); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := provisionExternalUser("alice", "pw"); err != nil {
+		t.Fatalf("provision alice: %v", err)
+	}
+	if err := provisionExternalUser("Alice", "pw"); err == nil || !strings.Contains(err.Error(), "alice") {
+		t.Errorf("provision Alice: err = %v", err)
+	}
+
+	var count int
+	var externalID string
+	models.DB.QueryRow("SELECT COUNT(*), MAX(external_id) FROM users WHERE LOWER(username)='alice'").Scan(&count, &externalID)
+	if count != 1 || externalID != "alice" {
+		t.Errorf("users = %d, external_id = %q", count, externalID)
+	}
+}
+
+func freeUDPAddr(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().String()
+}
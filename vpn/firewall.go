@@ -0,0 +1,239 @@
+package vpn
+
+import (
+	"bytes"
+	"edge_server/models"
+	"fmt"
+	"log"
+	"net"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+const (
+	nftTable    = "edge_vpn"
+	nftUserMap  = "user_chains"
+	nftChainFmt = "user_%s"
+)
+
+type firewallSession struct {
+	Username  string
+	VirtualIP string
+}
+
+var (
+	firewallMu       sync.Mutex
+	firewallEnabled  bool
+	firewallSessions = make(map[int]firewallSession)
+)
+
+func runNft(script string) error {
+	cmd := exec.Command("nft", "-f", "-")
+	cmd.Stdin = strings.NewReader(script)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("nft 执行失败: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func userChainName(virtualIP string) string {
+	return fmt.Sprintf(nftChainFmt, strings.ReplaceAll(virtualIP, ".", "_"))
+}
+
+// StartFirewall 初始化 nftables 表并在用户上下线时加载/移除其访问规则
+func StartFirewall() {
+	if _, err := exec.LookPath("nft"); err != nil {
+		log.Printf("未找到 nft 命令，访问策略仅在应用层检查")
+	} else {
+		// 转发链按源地址(虚拟IP)跳转到各用户的规则链
+		base := fmt.Sprintf(`table inet %[1]s
+delete table inet %[1]s
+table inet %[1]s {
+	map %[2]s {
+		type ipv4_addr : verdict
+	}
+	chain forward {
+		type filter hook forward priority 0; policy accept;
+		ip saddr vmap @%[2]s
+	}
+}
+`, nftTable, nftUserMap)
+		if err := runNft(base); err != nil {
+			log.Printf("初始化防火墙失败: %v", err)
+		} else {
+			firewallMu.Lock()
+			firewallEnabled = true
+			firewallMu.Unlock()
+		}
+	}
+
+	rows, err := models.DB.Query("SELECT COALESCE(ocserv_id, 0), username, COALESCE(virtual_ip, '') FROM online_users")
+	if err == nil {
+		var existing []Event
+		for rows.Next() {
+			var e Event
+			if err := rows.Scan(&e.OCServID, &e.Username, &e.VirtualIP); err == nil {
+				existing = append(existing, e)
+			}
+		}
+		rows.Close()
+		for _, e := range existing {
+			applySessionFirewall(e.OCServID, e.Username, e.VirtualIP)
+		}
+	}
+
+	_, events := Events.Subscribe(256)
+	go func() {
+		for event := range events {
+			switch event.Type {
+			case EventOnlineUserAdded:
+				applySessionFirewall(event.OCServID, event.Username, event.VirtualIP)
+			case EventOnlineUserRemoved:
+				removeSessionFirewall(event.OCServID)
+			}
+		}
+	}()
+}
+
+func compileNftRules(chain string, policy *CompiledPolicy) string {
+	var b bytes.Buffer
+	for _, rule := range policy.rules {
+		var match []string
+		if rule.network != nil {
+			match = append(match, "ip daddr "+rule.network.String())
+		}
+
+		var ports []string
+		for _, pr := range rule.ports {
+			if pr.From == pr.To {
+				ports = append(ports, fmt.Sprint(pr.From))
+			} else {
+				ports = append(ports, fmt.Sprintf("%d-%d", pr.From, pr.To))
+			}
+		}
+		portSet := "{ " + strings.Join(ports, ", ") + " }"
+
+		switch {
+		case rule.Protocol == "icmp":
+			match = append(match, "ip protocol icmp")
+		case rule.Protocol != "any" && len(ports) > 0:
+			match = append(match, rule.Protocol+" dport "+portSet)
+		case rule.Protocol != "any":
+			match = append(match, "meta l4proto "+rule.Protocol)
+		case len(ports) > 0:
+			match = append(match, "meta l4proto { tcp, udp } th dport "+portSet)
+		}
+
+		verdict := "accept"
+		if rule.Action == PolicyActionDeny {
+			verdict = "drop"
+		}
+		fmt.Fprintf(&b, "add rule inet %s %s %s counter %s\n", nftTable, chain, strings.Join(match, " "), verdict)
+	}
+
+	verdict := "accept"
+	if policy.DefaultAction == PolicyActionDeny {
+		verdict = "drop"
+	}
+	fmt.Fprintf(&b, "add rule inet %s %s counter %s\n", nftTable, chain, verdict)
+	return b.String()
+}
+
+func applySessionFirewall(ocservID int, username, virtualIP string) {
+	if net.ParseIP(virtualIP).To4() == nil {
+		return
+	}
+
+	firewallMu.Lock()
+	defer firewallMu.Unlock()
+
+	firewallSessions[ocservID] = firewallSession{Username: username, VirtualIP: virtualIP}
+	if !firewallEnabled {
+		return
+	}
+
+	policy, err := LoadUserPolicy(username)
+	if err != nil {
+		log.Printf("加载用户 %s 访问策略失败: %v", username, err)
+		blockSessionFirewall(ocservID, username, virtualIP)
+		return
+	}
+
+	if err := runNft(userChainScript(virtualIP, compileNftRules(userChainName(virtualIP), policy))); err != nil {
+		log.Printf("加载用户 %s (%s) 防火墙规则失败: %v", username, virtualIP, err)
+		blockSessionFirewall(ocservID, username, virtualIP)
+		return
+	}
+	log.Printf("已加载用户 %s (%s) 的防火墙规则: %d 条", username, virtualIP, len(policy.rules))
+}
+
+// userChainScript 重建用户规则链并将虚拟IP指向该链
+func userChainScript(virtualIP, rules string) string {
+	chain := userChainName(virtualIP)
+	script := fmt.Sprintf("add chain inet %[1]s %[2]s\nflush chain inet %[1]s %[2]s\n", nftTable, chain)
+	script += rules
+	script += fmt.Sprintf("add element inet %[1]s %[2]s { %[3]s : jump %[4]s }\n", nftTable, nftUserMap, virtualIP, chain)
+	return script
+}
+
+// blockSessionFirewall 在访问规则无法加载时拒绝该会话的全部转发流量，转发链默认放行，不能让会话不受限制；
+// 仍然失败时断开会话。调用方需持有 firewallMu
+func blockSessionFirewall(ocservID int, username, virtualIP string) {
+	rules := fmt.Sprintf("add rule inet %s %s counter drop\n", nftTable, userChainName(virtualIP))
+	err := runNft(userChainScript(virtualIP, rules))
+	if err == nil {
+		log.Printf("已阻断用户 %s (%s) 的转发流量，请检查访问策略", username, virtualIP)
+		return
+	}
+	log.Printf("阻断用户 %s (%s) 流量失败: %v，断开会话", username, virtualIP, err)
+
+	MarkDisconnectReason(username, "访问策略加载失败")
+	if err := DisconnectSessionByOCCtl(ocservID); err != nil {
+		log.Printf("断开用户 %s 会话 [%d] 失败: %v", username, ocservID, err)
+	}
+}
+
+func removeSessionFirewall(ocservID int) {
+	firewallMu.Lock()
+	defer firewallMu.Unlock()
+
+	session, exists := firewallSessions[ocservID]
+	if !exists {
+		return
+	}
+	delete(firewallSessions, ocservID)
+
+	if !firewallEnabled {
+		return
+	}
+
+	// 同一虚拟IP可能已被新会话占用
+	for _, other := range firewallSessions {
+		if other.VirtualIP == session.VirtualIP {
+			return
+		}
+	}
+
+	chain := userChainName(session.VirtualIP)
+	script := fmt.Sprintf("delete element inet %[1]s %[2]s { %[3]s }\nflush chain inet %[1]s %[4]s\ndelete chain inet %[1]s %[4]s\n",
+		nftTable, nftUserMap, session.VirtualIP, chain)
+	if err := runNft(script); err != nil {
+		log.Printf("移除用户 %s (%s) 防火墙规则失败: %v", session.Username, session.VirtualIP, err)
+	}
+}
+
+// RefreshFirewall 在策略变更后为所有在线会话重新生成规则
+func RefreshFirewall() {
+	firewallMu.Lock()
+	sessions := make(map[int]firewallSession, len(firewallSessions))
+	for id, s := range firewallSessions {
+		sessions[id] = s
+	}
+	firewallMu.Unlock()
+
+	for id, s := range sessions {
+		applySessionFirewall(id, s.Username, s.VirtualIP)
+	}
+}
@@ -0,0 +1,172 @@
+package vpn
+
+import (
+	"edge_server/models"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	groupConfigDirName = "config-per-group"
+	userConfigDirName  = "config-per-user"
+)
+
+type SessionConfig struct {
+	IPPool        string
+	StaticIP      string
+	Routes        []string
+	NoRoutes      []string
+	DNS           []string
+	SplitTunnel   bool
+	UploadLimit   int64
+	DownloadLimit int64
+	IdleTimeout   int
+}
+
+type GroupConfig struct {
+	ID   int
+	Name string
+	SessionConfig
+}
+
+func SplitList(value string) []string {
+	var items []string
+	for _, item := range strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ';' || r == '\n' || r == ' '
+	}) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func GroupConfigName(groupID int) string {
+	return fmt.Sprintf("group-%d", groupID)
+}
+
+func LoadGroupConfigs() (map[int]*GroupConfig, error) {
+	rows, err := models.DB.Query(`
+		SELECT id, name, COALESCE(ip_pool, ''), COALESCE(routes, ''), COALESCE(no_routes, ''),
+		       COALESCE(dns, ''), COALESCE(split_tunnel, 1), COALESCE(upload_limit, 0), COALESCE(download_limit, 0)
+		FROM user_groups
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := make(map[int]*GroupConfig)
+	for rows.Next() {
+		var g GroupConfig
+		var routes, noRoutes, dns string
+		if err := rows.Scan(&g.ID, &g.Name, &g.IPPool, &routes, &noRoutes, &dns, &g.SplitTunnel, &g.UploadLimit, &g.DownloadLimit); err != nil {
+			continue
+		}
+		g.Routes = SplitList(routes)
+		g.NoRoutes = SplitList(noRoutes)
+		g.DNS = SplitList(dns)
+		groups[g.ID] = &g
+	}
+
+	return groups, nil
+}
+
+func renderSessionConfig(title string, cfg *SessionConfig) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s - 由 Edge Server 自动生成\n", title)
+
+	if cfg.IPPool != "" {
+		fmt.Fprintf(&b, "ipv4-network = %s\n", cfg.IPPool)
+	}
+	if cfg.StaticIP != "" {
+		fmt.Fprintf(&b, "explicit-ipv4 = %s\n", cfg.StaticIP)
+	}
+
+	for _, dns := range cfg.DNS {
+		fmt.Fprintf(&b, "dns = %s\n", dns)
+	}
+
+	if cfg.SplitTunnel && len(cfg.Routes) > 0 {
+		for _, route := range cfg.Routes {
+			fmt.Fprintf(&b, "route = %s\n", route)
+		}
+		b.WriteString("tunnel-all-dns = false\n")
+	} else {
+		b.WriteString("route = default\n")
+	}
+
+	for _, route := range cfg.NoRoutes {
+		fmt.Fprintf(&b, "no-route = %s\n", route)
+	}
+
+	if cfg.UploadLimit > 0 {
+		fmt.Fprintf(&b, "rx-data-per-sec = %d\n", cfg.UploadLimit)
+	}
+	if cfg.DownloadLimit > 0 {
+		fmt.Fprintf(&b, "tx-data-per-sec = %d\n", cfg.DownloadLimit)
+	}
+	if cfg.IdleTimeout > 0 {
+		fmt.Fprintf(&b, "idle-timeout = %d\n", cfg.IdleTimeout)
+	}
+
+	return b.String()
+}
+
+func GenerateGroupConfigs(configDir string, groups map[int]*GroupConfig) error {
+	dir := filepath.Join(configDir, groupConfigDirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("创建用户组配置目录失败: %v", err)
+	}
+
+	expected := make(map[string]bool)
+	for _, g := range groups {
+		name := GroupConfigName(g.ID)
+		expected[name] = true
+		if err := writeFileAtomic(filepath.Join(dir, name), []byte(renderSessionConfig(fmt.Sprintf("用户组: %s (ID %d)", g.Name, g.ID), &g.SessionConfig)), 0644); err != nil {
+			return fmt.Errorf("写入用户组配置 %s 失败: %v", g.Name, err)
+		}
+	}
+
+	return removeUnexpectedFiles(dir, expected)
+}
+
+func removeUnexpectedFiles(dir string, expected map[string]bool) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || expected[entry.Name()] {
+			continue
+		}
+		os.Remove(filepath.Join(dir, entry.Name()))
+	}
+	return nil
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,84 @@
+package vpn
+
+import (
+	"database/sql"
+	"edge_server/models"
+	"log"
+	"sync"
+	"time"
+)
+
+const defaultDisconnectReason = "会话结束"
+
+type disconnectInfo struct {
+	Reason  string
+	BytesRX int64
+	BytesTX int64
+}
+
+var (
+	disconnectInfos = make(map[string]disconnectInfo)
+	disconnectMu    sync.Mutex
+)
+
+func MarkDisconnectReason(username, reason string) {
+	disconnectMu.Lock()
+	disconnectInfos[username] = disconnectInfo{Reason: reason}
+	disconnectMu.Unlock()
+}
+
+func recordClosedEvent(event Event) {
+	disconnectMu.Lock()
+	disconnectInfos[event.Username+"|"+event.RemoteIP] = disconnectInfo{
+		Reason:  event.Reason,
+		BytesRX: event.BytesRX,
+		BytesTX: event.BytesTX,
+	}
+	disconnectMu.Unlock()
+}
+
+func takeDisconnectInfo(username, remoteIP string) disconnectInfo {
+	disconnectMu.Lock()
+	defer disconnectMu.Unlock()
+
+	info := disconnectInfo{Reason: defaultDisconnectReason}
+	if marked, exists := disconnectInfos[username]; exists {
+		info.Reason = marked.Reason
+		delete(disconnectInfos, username)
+	}
+	if closed, exists := disconnectInfos[username+"|"+remoteIP]; exists {
+		if info.Reason == defaultDisconnectReason && closed.Reason != "" {
+			info.Reason = closed.Reason
+		}
+		info.BytesRX = closed.BytesRX
+		info.BytesTX = closed.BytesTX
+		delete(disconnectInfos, username+"|"+remoteIP)
+	}
+	return info
+}
+
+func CloseOnlineSession(onlineID, ocservID int, username, remoteIP string) {
+	lastRX, lastTX := takeTrafficSample(ocservID)
+
+	info := takeDisconnectInfo(username, remoteIP)
+	// 最后一次采样之后的流量以断开日志中的最终计数补记
+	if info.BytesRX > lastRX || info.BytesTX > lastTX {
+		recordTrafficUsage(username, max(info.BytesRX-lastRX, 0), max(info.BytesTX-lastTX, 0), time.Now())
+	}
+	err := models.ArchiveOnlineSession(onlineID, info.Reason, info.BytesRX, info.BytesTX)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("归档会话记录失败 %s: %v", username, err)
+		models.DB.Exec("DELETE FROM online_users WHERE id=?", onlineID)
+	}
+	ReleaseIPIfOffline(username)
+
+	Events.Publish(Event{
+		Type:     EventOnlineUserRemoved,
+		Username: username,
+		RemoteIP: remoteIP,
+		OCServID: ocservID,
+		Reason:   info.Reason,
+		BytesRX:  info.BytesRX,
+		BytesTX:  info.BytesTX,
+	})
+}
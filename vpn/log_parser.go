@@ -0,0 +1,79 @@
+package vpn
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	userLogPrefixRe = regexp.MustCompile(`(?:main|worker)\[([^\]]*)\]:\s*\[?([0-9a-fA-F.:]+?)\]?(?::\d+)?\s+(.*)$`)
+	disconnectRe    = regexp.MustCompile(`user disconnected(?: \((.*)\))?`)
+	disconnectKVRe  = regexp.MustCompile(`(reason|rx|tx):\s*([^,]+)`)
+	dtlsRe          = regexp.MustCompile(`DTLS (?:handshake completed|established)(?: \(plaintext MTU: (\d+)\))?`)
+	authFailedRe    = regexp.MustCompile(`failed authentication for '([^']*)'`)
+	authSessionRe   = regexp.MustCompile(`initiating session for user '([^']*)'(?: \(session: ([^)]+)\))?`)
+	banRe           = regexp.MustCompile(`added IP '([^']+)'(?: \(with score (\d+)\))? to ban list`)
+	ipAddrRe        = regexp.MustCompile(`\b(\d{1,3}(?:\.\d{1,3}){3})\b`)
+)
+
+func ParseLogLine(line string) (Event, bool) {
+	if m := banRe.FindStringSubmatch(line); m != nil {
+		reason := "封禁分数超限"
+		if m[2] != "" {
+			reason += ": " + m[2]
+		}
+		return Event{Type: EventIPBanned, RemoteIP: m[1], Reason: reason, Raw: line}, true
+	}
+
+	if m := authFailedRe.FindStringSubmatch(line); m != nil {
+		return Event{Type: EventAuthFailed, Username: m[1], RemoteIP: findIP(line), Raw: line}, true
+	}
+
+	if m := authSessionRe.FindStringSubmatch(line); m != nil {
+		return Event{Type: EventUserAuthenticated, Username: m[1], SessionID: m[2], RemoteIP: findIP(line), Raw: line}, true
+	}
+
+	m := userLogPrefixRe.FindStringSubmatch(line)
+	if m == nil {
+		return Event{}, false
+	}
+	username, remoteIP, message := m[1], m[2], m[3]
+
+	if strings.HasPrefix(message, "user logged in") {
+		return Event{Type: EventSessionOpened, Username: username, RemoteIP: remoteIP, Raw: line}, true
+	}
+
+	if dm := disconnectRe.FindStringSubmatch(message); dm != nil {
+		event := Event{Type: EventSessionClosed, Username: username, RemoteIP: remoteIP, Raw: line}
+		for _, kv := range disconnectKVRe.FindAllStringSubmatch(dm[1], -1) {
+			value := strings.TrimSpace(kv[2])
+			switch kv[1] {
+			case "reason":
+				event.Reason = value
+			case "rx":
+				event.BytesRX, _ = strconv.ParseInt(value, 10, 64)
+			case "tx":
+				event.BytesTX, _ = strconv.ParseInt(value, 10, 64)
+			}
+		}
+		if event.Reason == "" {
+			event.Reason = "unknown"
+		}
+		return event, true
+	}
+
+	if dm := dtlsRe.FindStringSubmatch(message); dm != nil {
+		mtu, _ := strconv.Atoi(dm[1])
+		return Event{Type: EventDTLSEstablished, Username: username, RemoteIP: remoteIP, MTU: mtu, Raw: line}, true
+	}
+
+	return Event{}, false
+}
+
+func findIP(line string) string {
+	if m := ipAddrRe.FindStringSubmatch(line); m != nil {
+		return m[1]
+	}
+	return ""
+}
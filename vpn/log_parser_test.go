@@ -0,0 +1,91 @@
+package vpn
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLogLine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want Event
+		ok   bool
+	}{
+		{
+			name: "登录",
+			line: "Oct 18 10:00:01 vpn ocserv[812]: main[alice]:203.0.113.5:51234 user logged in",
+			want: Event{Type: EventSessionOpened, Username: "alice", RemoteIP: "203.0.113.5"},
+			ok:   true,
+		},
+		{
+			name: "IPv6 地址",
+			line: "Oct 18 10:00:01 vpn ocserv[812]: main[bob]:[2001:db8::10]:51234 user logged in",
+			want: Event{Type: EventSessionOpened, Username: "bob", RemoteIP: "2001:db8::10"},
+			ok:   true,
+		},
+		{
+			name: "断开并上报流量",
+			line: "Oct 18 11:20:45 vpn ocserv[812]: main[alice]:203.0.113.5:51234 user disconnected (reason: user disconnected, rx: 10485760, tx: 2048)",
+			want: Event{Type: EventSessionClosed, Username: "alice", RemoteIP: "203.0.113.5", Reason: "user disconnected", BytesRX: 10485760, BytesTX: 2048},
+			ok:   true,
+		},
+		{
+			name: "断开无原因",
+			line: "Oct 18 11:20:45 vpn ocserv[812]: main[alice]:203.0.113.5:51234 user disconnected",
+			want: Event{Type: EventSessionClosed, Username: "alice", RemoteIP: "203.0.113.5", Reason: "unknown"},
+			ok:   true,
+		},
+		{
+			name: "DTLS 建立",
+			line: "Oct 18 10:00:02 vpn ocserv[1024]: worker[alice]: 203.0.113.5 DTLS handshake completed (plaintext MTU: 1400)",
+			want: Event{Type: EventDTLSEstablished, Username: "alice", RemoteIP: "203.0.113.5", MTU: 1400},
+			ok:   true,
+		},
+		{
+			name: "认证失败",
+			line: "Oct 18 10:05:00 vpn ocserv[1030]: worker: 198.51.100.7 failed authentication for 'mallory'",
+			want: Event{Type: EventAuthFailed, Username: "mallory", RemoteIP: "198.51.100.7"},
+			ok:   true,
+		},
+		{
+			name: "认证成功",
+			line: "Oct 18 10:00:00 vpn ocserv[812]: sec-mod: 203.0.113.5 initiating session for user 'alice' (session: 8tOkQ5)",
+			want: Event{Type: EventUserAuthenticated, Username: "alice", SessionID: "8tOkQ5", RemoteIP: "203.0.113.5"},
+			ok:   true,
+		},
+		{
+			name: "封禁",
+			line: "Oct 18 10:06:00 vpn ocserv[812]: main: added IP '198.51.100.7' (with score 80) to ban list, will be reset at: Tue Oct 18 10:26:00 2026",
+			want: Event{Type: EventIPBanned, RemoteIP: "198.51.100.7", Reason: "封禁分数超限: 80"},
+			ok:   true,
+		},
+		{
+			name: "无关日志",
+			line: "Oct 18 10:00:00 vpn ocserv[812]: main: initialized ocserv 1.2.4",
+		},
+		{
+			name: "用户的其他日志",
+			line: "Oct 18 10:00:02 vpn ocserv[1024]: worker[alice]: 203.0.113.5 sending IPv4 10.10.0.2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParseLogLine(tt.line)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if got.Raw != tt.line {
+				t.Errorf("Raw = %q", got.Raw)
+			}
+			got.Raw = ""
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,8 +1,9 @@
 package vpn
 
 import (
-	"bufio"
 	"edge_server/models"
+	"encoding/json"
+	"fmt"
 	"log"
 	"os/exec"
 	"strconv"
@@ -11,88 +12,244 @@ import (
 )
 
 func StartOCCtlMonitor() {
-	ticker := time.NewTicker(10 * time.Second)
+	restoreOnlineSessions()
+
+	ticker := time.NewTicker(5 * time.Second)
+	_, events := Events.Subscribe(64)
 	go func() {
-		for range ticker.C {
-			updateOnlineUsersFromOCCtl()
+		for {
+			select {
+			case <-ticker.C:
+				updateOnlineUsersFromOCCtl()
+			case event := <-events:
+				if event.Type == EventSessionClosed {
+					recordClosedEvent(event)
+				}
+				if event.Type == EventSessionOpened || event.Type == EventSessionClosed {
+					updateOnlineUsersFromOCCtl()
+				}
+			}
 		}
 	}()
 }
 
-func updateOnlineUsersFromOCCtl() {
-	cmd := exec.Command("occtl", "show", "users")
-	output, err := cmd.Output()
+// restoreOnlineSessions 为服务启动前已在线的会话建立空闲跟踪，空闲时间从启动时开始计算；
+// 流量采样从已记录的累计值继续，之前的流量已计入配额
+func restoreOnlineSessions() {
+	rows, err := models.DB.Query(`
+		SELECT COALESCE(o.ocserv_id, 0), o.username, COALESCE(o.virtual_ip, ''), COALESCE(o.remote_ip, ''),
+		       COALESCE(o.total_upload, 0), COALESCE(o.total_download, 0), COALESCE(u.group_id, 0)
+		FROM online_users o LEFT JOIN users u ON u.username = o.username
+	`)
 	if err != nil {
+		log.Printf("恢复在线会话失败: %v", err)
 		return
 	}
+	defer rows.Close()
+
+	now := time.Now()
+	for rows.Next() {
+		var ocservID, groupID int
+		var username, virtualIP, remoteIP string
+		var upload, download int64
+		if err := rows.Scan(&ocservID, &username, &virtualIP, &remoteIP, &upload, &download, &groupID); err != nil {
+			continue
+		}
+		if ocservID > 0 {
+			seedTrafficSample(ocservID, upload, download, now)
+		}
+		if _, exists := GetSession(username); !exists {
+			AddSession(username, virtualIP, remoteIP, groupID)
+		}
+	}
+}
+
+type occtlNumber int64
+
+func (n *occtlNumber) UnmarshalJSON(data []byte) error {
+	value := strings.Trim(strings.TrimSpace(string(data)), `"`)
+	parsed, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil {
+		*n = 0
+		return nil
+	}
+	*n = occtlNumber(parsed)
+	return nil
+}
+
+type OCCtlSession struct {
+	ID             occtlNumber `json:"ID"`
+	Username       string      `json:"Username"`
+	Groupname      string      `json:"Groupname"`
+	State          string      `json:"State"`
+	Device         string      `json:"Device"`
+	MTU            occtlNumber `json:"MTU"`
+	RemoteIP       string      `json:"Remote IP"`
+	IPv4           string      `json:"IPv4"`
+	IPv6           string      `json:"IPv6"`
+	UserAgent      string      `json:"User-Agent"`
+	Hostname       string      `json:"Hostname"`
+	RX             occtlNumber `json:"RX"`
+	TX             occtlNumber `json:"TX"`
+	RawConnectedAt occtlNumber `json:"raw_connected_at"`
+	Session        string      `json:"Session"`
+	FullSession    string      `json:"Full session"`
+	DTLSCipher     string      `json:"DTLS cipher"`
+	TLSCiphersuite string      `json:"TLS ciphersuite"`
+}
+
+func (s *OCCtlSession) Protocol() string {
+	if s.DTLSCipher != "" && !strings.Contains(s.DTLSCipher, "no-dtls") {
+		return "DTLS"
+	}
+	return "TLS"
+}
+
+func (s *OCCtlSession) ConnectedAt() time.Time {
+	if s.RawConnectedAt > 0 {
+		return time.Unix(int64(s.RawConnectedAt), 0)
+	}
+	return time.Now()
+}
+
+func ListOCCtlSessions() ([]OCCtlSession, error) {
+	output, err := exec.Command("occtl", "-j", "show", "users").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []OCCtlSession
+	if err := json.Unmarshal(output, &sessions); err != nil {
+		return nil, fmt.Errorf("解析 occtl 输出失败: %v", err)
+	}
+
+	return sessions, nil
+}
 
-	scanner := bufio.NewScanner(strings.NewReader(string(output)))
-	activeUsers := make(map[string]bool)
+func updateOnlineUsersFromOCCtl() {
+	sessions, err := ListOCCtlSessions()
+	if err != nil {
+		return
+	}
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		fields := strings.Fields(line)
-		
-		if len(fields) < 5 {
+	// ocserv 重启后会复用会话 ID，按 ID 和用户名共同识别会话；
+	// 先归档已断开的会话，避免同一 ID 的新会话与旧记录混在一起
+	active := make(map[int]string)
+	var connected []*OCCtlSession
+	for i := range sessions {
+		session := &sessions[i]
+		if session.Username == "" || session.ID == 0 {
+			continue
+		}
+		if session.State != "" && session.State != "connected" {
 			continue
 		}
+		active[int(session.ID)] = session.Username
+		connected = append(connected, session)
+	}
+	closeStaleOnlineUsers(active)
 
-		username := fields[0]
-		if username == "id" || username == "" {
+	now := time.Now()
+	var traffic []SessionTraffic
+	for _, session := range connected {
+		id := int(session.ID)
+
+		uploadSpeed, downloadSpeed, uploadDelta, downloadDelta := sampleTraffic(id, int64(session.RX), int64(session.TX), now)
+		recordTrafficUsage(session.Username, uploadDelta, downloadDelta, now)
+		traffic = append(traffic, SessionTraffic{
+			OCServID:      id,
+			Username:      session.Username,
+			UploadSpeed:   uploadSpeed,
+			DownloadSpeed: downloadSpeed,
+			TotalUpload:   int64(session.RX),
+			TotalDownload: int64(session.TX),
+		})
+
+		result, err := models.DB.Exec(`
+			UPDATE online_users
+			SET session_id=?, virtual_ip=?, remote_ip=?, protocol=?, virtual_dev=?, mtu=?,
+			    upload_speed=?, download_speed=?, total_upload=?, total_download=?, user_agent=?, hostname=?
+			WHERE ocserv_id=? AND username=?
+		`, session.Session, session.IPv4, session.RemoteIP, session.Protocol(), session.Device, int(session.MTU),
+			uploadSpeed, downloadSpeed, int64(session.RX), int64(session.TX), session.UserAgent, session.Hostname, id, session.Username)
+		if err != nil {
+			log.Printf("更新在线用户失败: %v", err)
+			continue
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			UpdateSessionActivity(session.Username, uploadDelta, downloadDelta)
 			continue
 		}
 
-		activeUsers[username] = true
+		var groupID int
+		var groupName string
+		models.DB.QueryRow(`
+			SELECT u.group_id, COALESCE(g.name, '') FROM users u 
+			LEFT JOIN user_groups g ON u.group_id = g.id 
+			WHERE u.username=?
+		`, session.Username).Scan(&groupID, &groupName)
 
-		var exists int
-		models.DB.QueryRow("SELECT COUNT(*) FROM online_users WHERE username=?", username).Scan(&exists)
-		
-		if exists == 0 {
-			virtualIP := ""
-			remoteIP := ""
-			if len(fields) >= 3 {
-				virtualIP = fields[2]
-			}
-			if len(fields) >= 4 {
-				remoteIP = fields[3]
-			}
+		_, err = models.DB.Exec(`
+			INSERT INTO online_users 
+			(ocserv_id, session_id, username, group_name, mac, virtual_ip, remote_ip, protocol, virtual_dev, mtu,
+			 total_upload, total_download, user_agent, hostname, connected_at) 
+			VALUES (?, ?, ?, ?, '', ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, id, session.Session, session.Username, groupName, session.IPv4, session.RemoteIP, session.Protocol(),
+			session.Device, int(session.MTU), int64(session.RX), int64(session.TX), session.UserAgent, session.Hostname,
+			session.ConnectedAt())
+		if err != nil {
+			log.Printf("记录在线用户失败: %v", err)
+			continue
+		}
 
-			var groupID int
-			var groupName string
-			models.DB.QueryRow(`
-				SELECT u.group_id, COALESCE(g.name, '') FROM users u 
-				LEFT JOIN user_groups g ON u.group_id = g.id 
-				WHERE u.username=?
-			`, username).Scan(&groupID, &groupName)
-
-			models.DB.Exec(`
-				INSERT INTO online_users 
-				(username, group_name, virtual_ip, remote_ip, protocol, connected_at) 
-				VALUES (?, ?, ?, ?, 'DTLS', CURRENT_TIMESTAMP)
-			`, username, groupName, virtualIP, remoteIP)
-
-			log.Printf("检测到新连接: %s (%s -> %s)", username, remoteIP, virtualIP)
+		if _, exists := GetSession(session.Username); !exists {
+			AddSession(session.Username, session.IPv4, session.RemoteIP, groupID)
 		}
+
+		log.Printf("检测到新连接: %s [%d] (%s -> %s, %s)", session.Username, id, session.RemoteIP, session.IPv4, session.Protocol())
+		Events.Publish(Event{
+			Type:      EventOnlineUserAdded,
+			Username:  session.Username,
+			RemoteIP:  session.RemoteIP,
+			VirtualIP: session.IPv4,
+			OCServID:  id,
+			SessionID: session.Session,
+		})
 	}
 
-	rows, _ := models.DB.Query("SELECT username, id FROM online_users")
-	defer rows.Close()
+	Events.Publish(Event{Type: EventTrafficUpdate, Traffic: traffic})
+}
+
+// closeStaleOnlineUsers 归档 occtl 中已不存在的在线记录，active 为当前会话 ID 到用户名的映射
+func closeStaleOnlineUsers(active map[int]string) {
+	rows, err := models.DB.Query("SELECT id, COALESCE(ocserv_id, 0), username, COALESCE(remote_ip, '') FROM online_users")
+	if err != nil {
+		return
+	}
 
+	type staleSession struct {
+		ID       int
+		OCServID int
+		Username string
+		RemoteIP string
+	}
+	var stale []staleSession
 	for rows.Next() {
-		var username string
-		var id int
-		rows.Scan(&username, &id)
-
-		if !activeUsers[username] {
-			models.DB.Exec("DELETE FROM online_users WHERE id=?", id)
-			
-			var groupID int
-			models.DB.QueryRow("SELECT group_id FROM users WHERE username=?", username).Scan(&groupID)
-			models.ReleaseIP(username, groupID)
-			
-			log.Printf("用户已断开: %s", username)
+		var s staleSession
+		if err := rows.Scan(&s.ID, &s.OCServID, &s.Username, &s.RemoteIP); err != nil {
+			continue
+		}
+		if username, exists := active[s.OCServID]; !exists || username != s.Username {
+			stale = append(stale, s)
 		}
 	}
+	rows.Close()
+
+	for _, session := range stale {
+		CloseOnlineSession(session.ID, session.OCServID, session.Username, session.RemoteIP)
+
+		log.Printf("用户已断开: %s", session.Username)
+	}
 }
 
 func GetOCServStatus() map[string]interface{} {
@@ -126,3 +283,8 @@ func DisconnectUserByOCCtl(username string) error {
 	cmd := exec.Command("occtl", "disconnect", "user", username)
 	return cmd.Run()
 }
+
+func DisconnectSessionByOCCtl(ocservID int) error {
+	cmd := exec.Command("occtl", "disconnect", "id", strconv.Itoa(ocservID))
+	return cmd.Run()
+}
@@ -2,7 +2,6 @@ package vpn
 
 import (
 	"bufio"
-	"edge_server/models"
 	"fmt"
 	"io"
 	"log"
@@ -25,13 +24,19 @@ type OCServConfig struct {
 	MaxClients  int
 	IdleTimeout int
 	ConfigDir   string
+	AuthMode    string
+	AuthHelper  string
+	Domain      string
+	Device      string
 }
 
 type OCServServer struct {
-	config  *OCServConfig
-	cmd     *exec.Cmd
-	mu      sync.Mutex
-	running bool
+	config     *OCServConfig
+	cmd        *exec.Cmd
+	mu         sync.Mutex
+	running    bool
+	done       chan struct{}
+	supervisor supervisorState
 }
 
 func NewOCServServer(config *OCServConfig) *OCServServer {
@@ -48,6 +53,18 @@ func (s *OCServServer) Start() error {
 		return fmt.Errorf("ocserv 已在运行")
 	}
 
+	s.cancelPendingRestart()
+	s.supervisor.backoff = 0
+	if err := s.startProcess(); err != nil {
+		s.supervisor.wantRunning = false
+		return err
+	}
+	s.supervisor.wantRunning = true
+
+	return nil
+}
+
+func (s *OCServServer) startProcess() error {
 	if err := s.prepareConfig(); err != nil {
 		return fmt.Errorf("准备配置失败: %v", err)
 	}
@@ -68,44 +85,72 @@ func (s *OCServServer) Start() error {
 	}
 
 	s.running = true
+	s.done = make(chan struct{})
+	s.supervisor.startedAt = time.Now()
 	log.Printf("ocserv VPN 服务已启动，端口: %d", port)
 
 	go s.monitorLogs(stdout, "STDOUT")
 	go s.monitorLogs(stderr, "STDERR")
-
-	go func() {
-		err := s.cmd.Wait()
-		s.mu.Lock()
-		s.running = false
-		s.mu.Unlock()
-		if err != nil {
-			log.Printf("ocserv 进程退出: %v", err)
-		}
-	}()
+	go s.watch(s.cmd, s.done)
 
 	return nil
 }
 
 func (s *OCServServer) Stop() error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.supervisor.wantRunning = false
+	pendingRestart := s.supervisor.restartTimer != nil
+	s.cancelPendingRestart()
 
 	if !s.running || s.cmd == nil || s.cmd.Process == nil {
+		s.mu.Unlock()
+		if pendingRestart {
+			return nil
+		}
 		return fmt.Errorf("ocserv 未在运行")
 	}
 
-	if err := s.cmd.Process.Signal(os.Interrupt); err != nil {
+	process, done := s.cmd.Process, s.done
+	if err := process.Signal(os.Interrupt); err != nil {
+		s.mu.Unlock()
 		return fmt.Errorf("停止 ocserv 失败: %v", err)
 	}
+	s.mu.Unlock()
+
+	select {
+	case <-done:
+	case <-time.After(stopTimeout):
+		log.Printf("ocserv 未在 %v 内退出，强制结束", stopTimeout)
+		process.Kill()
+		<-done
+	}
 
-	s.running = false
 	return nil
 }
 
+func (s *OCServServer) IsRunning() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.running
+}
+
+func (s *OCServServer) Restart() error {
+	if err := s.Stop(); err != nil && s.IsRunning() {
+		return err
+	}
+	return s.Start()
+}
+
 func (s *OCServServer) prepareConfig() error {
 	if s.config.ConfigDir == "" {
 		s.config.ConfigDir = "/etc/ocserv"
 	}
+	if s.config.Domain == "" {
+		s.config.Domain = "edge-vpn.local"
+	}
+	if s.config.Device == "" {
+		s.config.Device = "vpns"
+	}
 
 	os.MkdirAll(s.config.ConfigDir, 0755)
 	os.MkdirAll("/run/ocserv", 0755)
@@ -121,42 +166,57 @@ func (s *OCServServer) prepareConfig() error {
 		ServerKey:   s.config.ServerKey,
 		IPPool:      s.config.IPPool,
 		DNS:         s.config.DNS,
+		AuthMode:    s.config.AuthMode,
+		ConfigDir:   s.config.ConfigDir,
+		Domain:      s.config.Domain,
+		Device:      s.config.Device,
+	}
+
+	groups, err := LoadGroupConfigs()
+	if err != nil {
+		return fmt.Errorf("读取用户组失败: %v", err)
+	}
+	if err := GenerateGroupConfigs(s.config.ConfigDir, groups); err != nil {
+		return err
+	}
+	credentialMu.Lock()
+	err = regenerateUserCredentials(s.config.ConfigDir, s.config.AuthMode, groups)
+	credentialMu.Unlock()
+	if err != nil {
+		return err
 	}
 
 	configPath := filepath.Join(s.config.ConfigDir, "ocserv.conf")
-	if err := GenerateOCServConfig(configPath, params); err != nil {
+	candidatePath := configPath + ".new"
+	if err := GenerateOCServConfig(candidatePath, params); err != nil {
 		return err
 	}
+	if err := validateOCServConfig(candidatePath); err != nil {
+		os.Remove(candidatePath)
+		return err
+	}
+	if err := os.Rename(candidatePath, configPath); err != nil {
+		return fmt.Errorf("替换配置文件失败: %v", err)
+	}
 
-	passwdPath := "/run/ocserv/ocpasswd"
-	if err := s.generatePasswordFile(passwdPath); err != nil {
-		return fmt.Errorf("生成密码文件失败: %v", err)
+	if s.config.AuthMode == AuthModePAM {
+		if err := GeneratePAMConfig(pamServicePath, s.config.AuthHelper); err != nil {
+			return fmt.Errorf("生成PAM配置失败: %v", err)
+		}
 	}
 
 	return nil
 }
 
-func (s *OCServServer) generatePasswordFile(path string) error {
-	rows, err := models.DB.Query("SELECT username, password FROM users WHERE enabled=1")
-	if err != nil {
-		return err
+func validateOCServConfig(configPath string) error {
+	if _, err := exec.LookPath("ocserv"); err != nil {
+		return nil
 	}
-	defer rows.Close()
 
-	file, err := os.Create(path)
+	output, err := exec.Command("ocserv", "-t", "-c", configPath).CombinedOutput()
 	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	for rows.Next() {
-		var username, password string
-		if err := rows.Scan(&username, &password); err != nil {
-			continue
-		}
-		fmt.Fprintf(file, "%s:%s\n", username, password)
+		return fmt.Errorf("配置校验失败: %s", strings.TrimSpace(string(output)))
 	}
-
 	return nil
 }
 
@@ -170,16 +230,13 @@ func (s *OCServServer) monitorLogs(pipe io.ReadCloser, source string) {
 	for scanner.Scan() {
 		line := scanner.Text()
 		log.Printf("[ocserv-%s] %s", source, line)
-		
-		s.parseLogLine(line)
-	}
-}
+		if source == "STDERR" {
+			s.recordStderr(line)
+		}
 
-func (s *OCServServer) parseLogLine(line string) {
-	if strings.Contains(line, "user") && strings.Contains(line, "connected") {
-		
-	} else if strings.Contains(line, "disconnected") {
-		
+		if event, ok := ParseLogLine(line); ok {
+			Events.Publish(event)
+		}
 	}
 }
 
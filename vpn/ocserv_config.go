@@ -4,13 +4,15 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"text/template"
+	"unicode"
 )
 
 const ocservConfigTemplate = `
 # ocserv 配置文件 - 由 Edge Server 自动生成
 
-auth = "plain[passwd=/run/ocserv/ocpasswd]"
+{{if eq .AuthMode "pam"}}auth = "pam"{{else}}auth = "plain[passwd=/run/ocserv/ocpasswd]"{{end}}
 
 tcp-port = {{.VPNPort}}
 udp-port = {{.VPNPort}}
@@ -22,7 +24,8 @@ socket-file = /run/ocserv/ocserv-socket
 chroot-dir = /var/lib/ocserv
 
 max-clients = {{.MaxClients}}
-max-same-clients = 2
+# 同一用户的并发会话数由 Edge Server 按用户/用户组限制
+max-same-clients = 0
 
 server-cert = {{.ServerCert}}
 server-key = {{.ServerKey}}
@@ -61,13 +64,16 @@ rekey-method = ssl
 use-occtl = true
 pid-file = /run/ocserv/ocserv.pid
 
-device = vpns
+device = {{.Device}}
 predictable-ips = true
 
-default-domain = edge-vpn.local
+default-domain = {{.Domain}}
 
 ipv4-network = {{.IPPool}}
 
+config-per-group = {{.ConfigDir}}/config-per-group/
+config-per-user = {{.ConfigDir}}/config-per-user/
+
 tunnel-all-dns = true
 {{range .DNS}}
 dns = {{.}}
@@ -81,6 +87,13 @@ dtls-legacy = true
 user-profile = /etc/ocserv/profile.xml
 `
 
+const pamServiceTemplate = `# ocserv PAM 配置 - 由 Edge Server 自动生成
+auth    required    pam_exec.so expose_authtok quiet %s auth
+account required    pam_exec.so quiet %s auth
+`
+
+const pamServicePath = "/etc/pam.d/ocserv"
+
 const userProfileTemplate = `<?xml version="1.0" encoding="UTF-8"?>
 <AnyConnectProfile xmlns="http://schemas.xmlsoap.org/encoding/">
 <ServerList>
@@ -100,9 +113,28 @@ type OCServConfigParams struct {
 	ServerKey    string
 	IPPool       string
 	DNS          []string
+	AuthMode     string
+	ConfigDir    string
+	Domain       string
+	Device       string
+}
+
+// validate 检查写入配置文件的字符串，换行等控制字符会被 ocserv 当作新的配置项
+func (p *OCServConfigParams) validate() error {
+	values := append([]string{p.ServerCert, p.ServerKey, p.IPPool, p.AuthMode, p.ConfigDir, p.Domain, p.Device}, p.DNS...)
+	for _, value := range values {
+		if strings.IndexFunc(value, unicode.IsControl) >= 0 {
+			return fmt.Errorf("配置值包含换行或控制字符: %q", value)
+		}
+	}
+	return nil
 }
 
 func GenerateOCServConfig(configPath string, params OCServConfigParams) error {
+	if err := params.validate(); err != nil {
+		return err
+	}
+
 	tmpl, err := template.New("ocserv").Parse(ocservConfigTemplate)
 	if err != nil {
 		return fmt.Errorf("解析配置模板失败: %v", err)
@@ -125,3 +157,16 @@ func GenerateOCServConfig(configPath string, params OCServConfigParams) error {
 
 	return nil
 }
+
+func GeneratePAMConfig(path string, helper string) error {
+	if helper == "" {
+		return fmt.Errorf("未指定认证程序路径")
+	}
+
+	content := fmt.Sprintf(pamServiceTemplate, helper, helper)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return fmt.Errorf("写入PAM配置失败: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,325 @@
+package vpn
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"edge_server/models"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	otpIssuer = "Edge Server VPN"
+	otpPeriod = 30
+	otpDigits = 6
+
+	// 首次绑定需要管理员发放的一次性绑定码，防止仅凭泄露的密码抢先绑定攻击者的设备
+	otpEnrollCodeTTL = 72 * time.Hour
+)
+
+// userOTP 为 VPN 用户的动态口令设置，Required 表示所在用户组要求动态口令
+type userOTP struct {
+	Secret        string
+	PendingSecret string
+	Enabled       bool
+	Required      bool
+	LastStep      int64
+}
+
+func loadUserOTP(username string) (*userOTP, error) {
+	var o userOTP
+	err := models.DB.QueryRow(`
+		SELECT COALESCE(u.otp_secret, ''), COALESCE(u.otp_pending_secret, ''), COALESCE(u.otp_enabled, 0),
+		       COALESCE(g.otp_required, 0), COALESCE(u.otp_last_step, 0)
+		FROM users u LEFT JOIN user_groups g ON g.id = u.group_id
+		WHERE u.username=?
+	`, username).Scan(&o.Secret, &o.PendingSecret, &o.Enabled, &o.Required, &o.LastStep)
+	if err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// splitOTPPassword 拆分"密码+动态口令"形式的输入，动态口令为末尾 6 位数字
+func splitOTPPassword(input string) (password, code string) {
+	if len(input) <= otpDigits {
+		return input, ""
+	}
+	code = input[len(input)-otpDigits:]
+	for _, ch := range code {
+		if ch < '0' || ch > '9' {
+			return input, ""
+		}
+	}
+	return input[:len(input)-otpDigits], code
+}
+
+// matchOTPCode 校验验证码，允许前后各一个时间步的误差，不接受已使用过的时间步
+func matchOTPCode(secret string, lastStep int64, code string, now time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if secret == "" || len(code) != otpDigits {
+		return 0, false
+	}
+
+	current := now.Unix() / otpPeriod
+	for _, offset := range []int64{0, -1, 1} {
+		step := current + offset
+		if step <= lastStep {
+			continue
+		}
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(step*otpPeriod, 0), totp.ValidateOpts{
+			Period:    otpPeriod,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// verifyUserOTP 校验动态口令并记录时间步。记录时要求时间步大于已记录的值，
+// 同一动态口令被并发提交时只有一个请求通过
+func verifyUserOTP(username string, settings *userOTP, code string) bool {
+	step, ok := matchOTPCode(settings.Secret, settings.LastStep, code, time.Now())
+	if !ok {
+		return false
+	}
+	result, err := models.DB.Exec(`
+		UPDATE users SET otp_last_step=? WHERE username=? AND COALESCE(otp_last_step, 0)<?
+	`, step, username, step)
+	if err != nil {
+		log.Printf("记录用户 %s 的动态口令时间步失败: %v", username, err)
+		return false
+	}
+	if n, err := result.RowsAffected(); err != nil || n != 1 {
+		return false
+	}
+	settings.LastStep = step
+	return true
+}
+
+// checkPasswordWithOTP 在用户启用或所在组要求动态口令时，从输入中拆出动态口令，密码部分交给 verify 校验
+func checkPasswordWithOTP(username, input string, verify func(password string) error) error {
+	settings, err := loadUserOTP(username)
+	if err != nil {
+		return fmt.Errorf("查询动态口令设置失败: %v", err)
+	}
+
+	if !settings.Enabled && !settings.Required {
+		return verify(input)
+	}
+
+	password, code := splitOTPPassword(input)
+	if err := verify(password); err != nil {
+		return err
+	}
+	if !settings.Enabled {
+		return fmt.Errorf("所在用户组要求动态口令，请先在自助页面绑定身份验证器")
+	}
+	if code == "" {
+		return fmt.Errorf("缺少动态口令，请在密码后输入 6 位动态口令")
+	}
+	if !verifyUserOTP(username, settings, code) {
+		return fmt.Errorf("动态口令错误")
+	}
+	return nil
+}
+
+// otpRequiredUsers 返回需要动态口令的用户，plain 模式下 ocserv 无法校验动态口令，这些用户不写入 ocpasswd
+func otpRequiredUsers() map[string]bool {
+	required := make(map[string]bool)
+	rows, err := models.DB.Query(`
+		SELECT u.username FROM users u LEFT JOIN user_groups g ON g.id = u.group_id
+		WHERE COALESCE(u.otp_enabled, 0)=1 OR COALESCE(g.otp_required, 0)=1
+	`)
+	if err != nil {
+		return required
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err == nil {
+			required[username] = true
+		}
+	}
+	return required
+}
+
+// verifyEnrollmentPassword 校验自助绑定页面提交的 VPN 账号密码
+func verifyEnrollmentPassword(username, password string) error {
+	if username == "" || password == "" {
+		return fmt.Errorf("用户名或密码为空")
+	}
+	var enabled bool
+	err := models.DB.QueryRow("SELECT enabled FROM users WHERE username=?", username).Scan(&enabled)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("用户名或密码错误")
+	}
+	if err != nil {
+		return fmt.Errorf("查询用户失败: %v", err)
+	}
+	if err := verifyUserPassword(username, password); err != nil {
+		return fmt.Errorf("用户名或密码错误")
+	}
+	if !enabled {
+		return fmt.Errorf("用户已被禁用")
+	}
+	return nil
+}
+
+func hashEnrollCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueOTPEnrollCode 为用户生成一次性绑定码，只保存哈希，新绑定码会使之前的作废
+func IssueOTPEnrollCode(username string) (string, error) {
+	b := make([]byte, 5)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := hex.EncodeToString(b)
+	code = code[:5] + "-" + code[5:]
+
+	result, err := models.DB.Exec(`
+		UPDATE users SET otp_enroll_code=?, otp_enroll_expires=?, updated_at=CURRENT_TIMESTAMP WHERE username=?
+	`, hashEnrollCode(code), time.Now().Add(otpEnrollCodeTTL), username)
+	if err != nil {
+		return "", err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return "", sql.ErrNoRows
+	}
+	return code, nil
+}
+
+// checkEnrollCode 校验首次绑定时提交的绑定码，返回其哈希供确认绑定时作废
+func checkEnrollCode(username, code string) (string, error) {
+	var stored string
+	var expires sql.NullTime
+	err := models.DB.QueryRow(`
+		SELECT COALESCE(otp_enroll_code, ''), otp_enroll_expires FROM users WHERE username=?
+	`, username).Scan(&stored, &expires)
+	if err != nil {
+		return "", fmt.Errorf("查询绑定码失败: %v", err)
+	}
+	if stored == "" || !expires.Valid || time.Now().After(expires.Time) {
+		return "", fmt.Errorf("首次绑定需要管理员发放的绑定码，请联系管理员获取")
+	}
+	hash := hashEnrollCode(code)
+	if strings.TrimSpace(code) == "" || subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) != 1 {
+		return "", fmt.Errorf("绑定码错误")
+	}
+	return hash, nil
+}
+
+// UserOTPStatus 返回用户是否已绑定动态口令以及所在组是否要求动态口令
+func UserOTPStatus(username, password string) (enabled, required bool, err error) {
+	if err := verifyEnrollmentPassword(username, password); err != nil {
+		return false, false, err
+	}
+	settings, err := loadUserOTP(username)
+	if err != nil {
+		return false, false, fmt.Errorf("查询动态口令设置失败: %v", err)
+	}
+	return settings.Enabled, settings.Required, nil
+}
+
+// BeginOTPEnrollment 生成待确认的密钥；首次绑定需提供管理员发放的绑定码，已绑定的用户更换设备时需提供当前动态口令
+func BeginOTPEnrollment(username, password, currentCode, enrollCode string) (*otp.Key, error) {
+	if err := verifyEnrollmentPassword(username, password); err != nil {
+		return nil, err
+	}
+	settings, err := loadUserOTP(username)
+	if err != nil {
+		return nil, fmt.Errorf("查询动态口令设置失败: %v", err)
+	}
+	if settings.Enabled {
+		if !verifyUserOTP(username, settings, currentCode) {
+			return nil, fmt.Errorf("已绑定动态口令，更换设备需输入当前动态口令")
+		}
+	} else if _, err := checkEnrollCode(username, enrollCode); err != nil {
+		return nil, err
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      otpIssuer,
+		AccountName: username,
+		Period:      otpPeriod,
+		Digits:      otp.DigitsSix,
+		Algorithm:   otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("生成密钥失败: %v", err)
+	}
+
+	if _, err := models.DB.Exec("UPDATE users SET otp_pending_secret=? WHERE username=?", key.Secret(), username); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// ConfirmOTPEnrollment 校验新密钥生成的首个动态口令，通过后替换原有密钥；首次绑定时绑定码随之作废
+func ConfirmOTPEnrollment(username, password, code, enrollCode string) error {
+	if err := verifyEnrollmentPassword(username, password); err != nil {
+		return err
+	}
+	settings, err := loadUserOTP(username)
+	if err != nil {
+		return fmt.Errorf("查询动态口令设置失败: %v", err)
+	}
+	if settings.PendingSecret == "" {
+		return fmt.Errorf("请先生成密钥")
+	}
+	step, ok := matchOTPCode(settings.PendingSecret, 0, code, time.Now())
+	if !ok {
+		return fmt.Errorf("动态口令错误")
+	}
+
+	query := `
+		UPDATE users SET otp_secret=otp_pending_secret, otp_pending_secret='', otp_enabled=1, otp_last_step=?,
+		       otp_enroll_code='', otp_enroll_expires=NULL, updated_at=CURRENT_TIMESTAMP
+		WHERE username=? AND otp_pending_secret=?`
+	args := []interface{}{step, username, settings.PendingSecret}
+	if !settings.Enabled {
+		hash, err := checkEnrollCode(username, enrollCode)
+		if err != nil {
+			return err
+		}
+		query += " AND otp_enroll_code=?"
+		args = append(args, hash)
+	}
+	result, err := models.DB.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n != 1 {
+		return fmt.Errorf("绑定状态已变化，请重新生成密钥")
+	}
+	return nil
+}
+
+// ResetUserOTP 清除用户的动态口令绑定，用于设备丢失后重新绑定
+func ResetUserOTP(username string) error {
+	_, err := models.DB.Exec(`
+		UPDATE users SET otp_secret='', otp_pending_secret='', otp_enabled=0, otp_last_step=0,
+		       otp_enroll_code='', otp_enroll_expires=NULL, updated_at=CURRENT_TIMESTAMP
+		WHERE username=?
+	`, username)
+	return err
+}
@@ -2,20 +2,286 @@ package vpn
 
 import (
 	"edge_server/models"
+	"encoding/json"
+	"fmt"
 	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
 )
 
+const (
+	PolicyActionAllow = "allow"
+	PolicyActionDeny  = "deny"
+)
+
+var privateNetworks = []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"}
+
+// PolicyRule 为一条访问控制规则，Priority 越小越先匹配
+type PolicyRule struct {
+	Priority    int    `json:"priority"`
+	Action      string `json:"action"`
+	Destination string `json:"destination,omitempty"`
+	Protocol    string `json:"protocol,omitempty"`
+	Ports       string `json:"ports,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// PolicySet 为 user_groups.policies 中保存的 JSON。
+// allow_internet/allow_lan 为旧版开关，解析时转换为等价规则。
+type PolicySet struct {
+	DefaultAction string       `json:"default_action,omitempty"`
+	Rules         []PolicyRule `json:"rules,omitempty"`
+	AllowInternet *bool        `json:"allow_internet,omitempty"`
+	AllowLAN      *bool        `json:"allow_lan,omitempty"`
+}
+
+type portRange struct {
+	From int
+	To   int
+}
+
+type compiledRule struct {
+	PolicyRule
+	network *net.IPNet
+	ports   []portRange
+	order   int
+}
+
+type CompiledPolicy struct {
+	DefaultAction string
+	rules         []compiledRule
+}
+
+func ParsePolicySet(value string) (*PolicySet, error) {
+	set := &PolicySet{}
+	if strings.TrimSpace(value) == "" {
+		return set, nil
+	}
+	if err := json.Unmarshal([]byte(value), set); err != nil {
+		return nil, fmt.Errorf("访问策略必须为JSON对象: %v", err)
+	}
+	return set, nil
+}
+
+func ValidatePolicySet(set *PolicySet) error {
+	switch set.DefaultAction {
+	case "", PolicyActionAllow, PolicyActionDeny:
+	default:
+		return fmt.Errorf("默认动作只能是 allow 或 deny")
+	}
+
+	for i, rule := range set.Rules {
+		if _, err := compileRule(rule, i); err != nil {
+			return fmt.Errorf("第 %d 条规则错误: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+func ValidatePolicies(value string) error {
+	set, err := ParsePolicySet(value)
+	if err != nil {
+		return err
+	}
+	return ValidatePolicySet(set)
+}
+
+func parsePorts(value string) ([]portRange, error) {
+	var ranges []portRange
+	for _, item := range SplitList(value) {
+		from, to := item, item
+		if idx := strings.Index(item, "-"); idx > 0 {
+			from, to = item[:idx], item[idx+1:]
+		}
+		start, err1 := strconv.Atoi(from)
+		end, err2 := strconv.Atoi(to)
+		if err1 != nil || err2 != nil || start < 1 || end > 65535 || start > end {
+			return nil, fmt.Errorf("端口格式错误: %s", item)
+		}
+		ranges = append(ranges, portRange{From: start, To: end})
+	}
+	return ranges, nil
+}
+
+func compileRule(rule PolicyRule, order int) (compiledRule, error) {
+	c := compiledRule{PolicyRule: rule, order: order}
+	c.Action = strings.ToLower(strings.TrimSpace(rule.Action))
+	c.Protocol = strings.ToLower(strings.TrimSpace(rule.Protocol))
+
+	if c.Action != PolicyActionAllow && c.Action != PolicyActionDeny {
+		return c, fmt.Errorf("动作只能是 allow 或 deny")
+	}
+
+	switch c.Protocol {
+	case "", "any":
+		c.Protocol = "any"
+	case "tcp", "udp", "icmp":
+	default:
+		return c, fmt.Errorf("不支持的协议: %s", rule.Protocol)
+	}
+
+	if dst := strings.TrimSpace(rule.Destination); dst != "" && dst != "any" {
+		if !strings.Contains(dst, "/") {
+			dst += "/32"
+		}
+		ip, network, err := net.ParseCIDR(dst)
+		if err != nil || ip.To4() == nil {
+			return c, fmt.Errorf("目标地址必须为IPv4地址或网段: %s", rule.Destination)
+		}
+		c.network = network
+		c.Destination = network.String()
+	} else {
+		c.Destination = ""
+	}
+
+	ports, err := parsePorts(rule.Ports)
+	if err != nil {
+		return c, err
+	}
+	if len(ports) > 0 && c.Protocol == "icmp" {
+		return c, fmt.Errorf("ICMP 规则不能指定端口")
+	}
+	c.ports = ports
+
+	return c, nil
+}
+
+func (set *PolicySet) legacyRules() []PolicyRule {
+	var rules []PolicyRule
+	if set.AllowLAN != nil && !*set.AllowLAN {
+		for _, network := range privateNetworks {
+			rules = append(rules, PolicyRule{Priority: 10000, Action: PolicyActionDeny, Destination: network, Description: "allow_lan=false"})
+		}
+	}
+	if set.AllowInternet != nil && !*set.AllowInternet {
+		if set.AllowLAN == nil || *set.AllowLAN {
+			for _, network := range privateNetworks {
+				rules = append(rules, PolicyRule{Priority: 10001, Action: PolicyActionAllow, Destination: network, Description: "allow_lan=true"})
+			}
+		}
+		rules = append(rules, PolicyRule{Priority: 10002, Action: PolicyActionDeny, Description: "allow_internet=false"})
+	}
+	return rules
+}
+
+// CompilePolicy 合并用户规则与用户组规则。优先级相同时用户规则先于用户组规则匹配。
+func CompilePolicy(userSet, groupSet *PolicySet) *CompiledPolicy {
+	policy := &CompiledPolicy{DefaultAction: PolicyActionAllow}
+
+	var rules []PolicyRule
+	for _, set := range []*PolicySet{userSet, groupSet} {
+		if set == nil {
+			continue
+		}
+		rules = append(rules, set.Rules...)
+		rules = append(rules, set.legacyRules()...)
+	}
+
+	for i, rule := range rules {
+		compiled, err := compileRule(rule, i)
+		if err != nil {
+			log.Printf("忽略无效访问规则 %+v: %v", rule, err)
+			continue
+		}
+		policy.rules = append(policy.rules, compiled)
+	}
+	sort.SliceStable(policy.rules, func(i, j int) bool {
+		return policy.rules[i].Priority < policy.rules[j].Priority
+	})
+
+	if groupSet != nil && groupSet.DefaultAction != "" {
+		policy.DefaultAction = groupSet.DefaultAction
+	}
+	if userSet != nil && userSet.DefaultAction != "" {
+		policy.DefaultAction = userSet.DefaultAction
+	}
+
+	return policy
+}
+
+func (r *compiledRule) matches(ip net.IP, port int, protocol string) bool {
+	if r.network != nil && !r.network.Contains(ip) {
+		return false
+	}
+	if r.Protocol != "any" && protocol != "any" && r.Protocol != protocol {
+		return false
+	}
+	if len(r.ports) == 0 {
+		return true
+	}
+	if port <= 0 {
+		return protocol == "any"
+	}
+	for _, pr := range r.ports {
+		if port >= pr.From && port <= pr.To {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *CompiledPolicy) Evaluate(dstIP string, dstPort int, protocol string) string {
+	ip := net.ParseIP(dstIP)
+	if ip == nil {
+		return p.DefaultAction
+	}
+	if protocol == "" {
+		protocol = "any"
+	}
+	for i := range p.rules {
+		if p.rules[i].matches(ip, dstPort, protocol) {
+			return p.rules[i].Action
+		}
+	}
+	return p.DefaultAction
+}
+
+func LoadUserPolicy(username string) (*CompiledPolicy, error) {
+	var groupPolicies, customPolicies string
+	err := models.DB.QueryRow(`
+		SELECT COALESCE(g.policies, ''), COALESCE(u.custom_policies, '')
+		FROM users u LEFT JOIN user_groups g ON g.id = u.group_id
+		WHERE u.username=?
+	`, username).Scan(&groupPolicies, &customPolicies)
+	if err != nil {
+		return nil, err
+	}
+
+	groupSet, err := ParsePolicySet(groupPolicies)
+	if err != nil {
+		log.Printf("用户 %s 所属组的访问策略无效: %v", username, err)
+		groupSet = nil
+	}
+
+	var userSet *PolicySet
+	if userPolicies, err := ParseUserPolicies(customPolicies); err == nil {
+		userSet = &userPolicies.PolicySet
+	}
+
+	return CompilePolicy(userSet, groupSet), nil
+}
+
 func LogAccess(username, srcIP, dstIP string, dstPort int, protocol, action string, bytesSent, bytesRecv int64) {
 	_, err := models.DB.Exec(`
-		INSERT INTO access_logs (username, src_ip, dst_ip, dst_port, protocol, action, bytes_sent, bytes_recv) 
+		INSERT INTO access_logs (username, src_ip, dst_ip, dst_port, protocol, action, bytes_sent, bytes_recv)
 		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
 	`, username, srcIP, dstIP, dstPort, protocol, action, bytesSent, bytesRecv)
-	
+
 	if err != nil {
 		log.Printf("记录访问日志失败: %v", err)
 	}
 }
 
 func CheckPolicy(username string, dstIP string, dstPort int) bool {
-	return true
+	return CheckPolicyProtocol(username, dstIP, dstPort, "any")
+}
+
+func CheckPolicyProtocol(username, dstIP string, dstPort int, protocol string) bool {
+	policy, err := LoadUserPolicy(username)
+	if err != nil {
+		return false
+	}
+	return policy.Evaluate(dstIP, dstPort, protocol) == PolicyActionAllow
 }
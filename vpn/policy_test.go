@@ -0,0 +1,188 @@
+package vpn
+
+import (
+	"strings"
+	"testing"
+)
+
+func boolPtr(v bool) *bool {
+	return &v
+}
+
+func TestValidatePolicies(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr string
+	}{
+		{name: "空策略", value: ""},
+		{name: "完整规则", value: `{"default_action":"deny","rules":[{"priority":10,"action":"allow","destination":"10.0.0.0/8","protocol":"tcp","ports":"22,80,8000-8100"}]}`},
+		{name: "单个地址", value: `{"rules":[{"action":"DENY","destination":"192.168.1.10"}]}`},
+		{name: "旧版开关", value: `{"allow_internet":false,"allow_lan":true}`},
+		{name: "非JSON", value: `allow all`, wantErr: "访问策略必须为JSON对象"},
+		{name: "默认动作错误", value: `{"default_action":"reject"}`, wantErr: "默认动作只能是 allow 或 deny"},
+		{name: "动作错误", value: `{"rules":[{"action":"drop"}]}`, wantErr: "第 1 条规则错误: 动作只能是 allow 或 deny"},
+		{name: "协议错误", value: `{"rules":[{"action":"allow"},{"action":"allow","protocol":"sctp"}]}`, wantErr: "第 2 条规则错误: 不支持的协议"},
+		{name: "IPv6 地址", value: `{"rules":[{"action":"allow","destination":"2001:db8::/32"}]}`, wantErr: "目标地址必须为IPv4地址或网段"},
+		{name: "网段错误", value: `{"rules":[{"action":"allow","destination":"10.0.0.0/33"}]}`, wantErr: "目标地址必须为IPv4地址或网段"},
+		{name: "端口越界", value: `{"rules":[{"action":"allow","protocol":"tcp","ports":"0"}]}`, wantErr: "端口格式错误"},
+		{name: "端口范围倒置", value: `{"rules":[{"action":"allow","protocol":"tcp","ports":"90-80"}]}`, wantErr: "端口格式错误"},
+		{name: "ICMP 带端口", value: `{"rules":[{"action":"allow","protocol":"icmp","ports":"80"}]}`, wantErr: "ICMP 规则不能指定端口"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidatePolicies(tt.value)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCompilePolicyEvaluate(t *testing.T) {
+	userSet := &PolicySet{Rules: []PolicyRule{
+		{Priority: 20, Action: PolicyActionAllow, Destination: "10.1.0.0/16", Protocol: "tcp", Ports: "443"},
+		{Priority: 50, Action: PolicyActionDeny, Destination: "10.2.0.5"},
+	}}
+	groupSet := &PolicySet{
+		DefaultAction: PolicyActionDeny,
+		Rules: []PolicyRule{
+			{Priority: 10, Action: PolicyActionDeny, Destination: "10.1.2.0/24"},
+			{Priority: 50, Action: PolicyActionAllow, Destination: "10.2.0.0/16"},
+			{Priority: 60, Action: PolicyActionAllow, Protocol: "udp", Ports: "53"},
+			{Priority: 70, Action: "bogus"},
+		},
+	}
+	policy := CompilePolicy(userSet, groupSet)
+
+	tests := []struct {
+		name     string
+		ip       string
+		port     int
+		protocol string
+		want     string
+	}{
+		{name: "组规则优先级更高", ip: "10.1.2.3", port: 443, protocol: "tcp", want: PolicyActionDeny},
+		{name: "用户规则允许", ip: "10.1.9.9", port: 443, protocol: "tcp", want: PolicyActionAllow},
+		{name: "端口不匹配走默认", ip: "10.1.9.9", port: 80, protocol: "tcp", want: PolicyActionDeny},
+		{name: "协议不匹配走默认", ip: "10.1.9.9", port: 443, protocol: "udp", want: PolicyActionDeny},
+		{name: "同优先级用户规则在前", ip: "10.2.0.5", port: 80, protocol: "tcp", want: PolicyActionDeny},
+		{name: "同优先级组规则", ip: "10.2.0.6", port: 80, protocol: "tcp", want: PolicyActionAllow},
+		{name: "任意目标", ip: "8.8.8.8", port: 53, protocol: "udp", want: PolicyActionAllow},
+		{name: "组默认动作", ip: "8.8.8.8", port: 443, protocol: "tcp", want: PolicyActionDeny},
+		{name: "无效地址", ip: "not-an-ip", want: PolicyActionDeny},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.Evaluate(tt.ip, tt.port, tt.protocol); got != tt.want {
+				t.Errorf("Evaluate(%s, %d, %s) = %s, want %s", tt.ip, tt.port, tt.protocol, got, tt.want)
+			}
+		})
+	}
+
+	if len(policy.rules) != 5 {
+		t.Fatalf("compiled %d rules, want 5 (invalid rule skipped)", len(policy.rules))
+	}
+	for i := 1; i < len(policy.rules); i++ {
+		if policy.rules[i-1].Priority > policy.rules[i].Priority {
+			t.Fatalf("rules not sorted by priority: %+v", policy.rules)
+		}
+	}
+}
+
+func TestCompilePolicyDefaultAction(t *testing.T) {
+	tests := []struct {
+		name  string
+		user  *PolicySet
+		group *PolicySet
+		want  string
+	}{
+		{name: "无策略", want: PolicyActionAllow},
+		{name: "组默认拒绝", group: &PolicySet{DefaultAction: PolicyActionDeny}, want: PolicyActionDeny},
+		{name: "用户覆盖组", user: &PolicySet{DefaultAction: PolicyActionAllow}, group: &PolicySet{DefaultAction: PolicyActionDeny}, want: PolicyActionAllow},
+		{name: "用户未设置时沿用组", user: &PolicySet{}, group: &PolicySet{DefaultAction: PolicyActionDeny}, want: PolicyActionDeny},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CompilePolicy(tt.user, tt.group).DefaultAction; got != tt.want {
+				t.Errorf("DefaultAction = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompilePolicyLegacy(t *testing.T) {
+	tests := []struct {
+		name string
+		set  *PolicySet
+		ip   string
+		want string
+	}{
+		{name: "禁止内网", set: &PolicySet{AllowLAN: boolPtr(false)}, ip: "192.168.1.1", want: PolicyActionDeny},
+		{name: "禁止内网时可访问外网", set: &PolicySet{AllowLAN: boolPtr(false)}, ip: "1.1.1.1", want: PolicyActionAllow},
+		{name: "禁止外网", set: &PolicySet{AllowInternet: boolPtr(false)}, ip: "1.1.1.1", want: PolicyActionDeny},
+		{name: "禁止外网时可访问内网", set: &PolicySet{AllowInternet: boolPtr(false)}, ip: "172.16.5.5", want: PolicyActionAllow},
+		{name: "全部禁止", set: &PolicySet{AllowInternet: boolPtr(false), AllowLAN: boolPtr(false)}, ip: "10.0.0.1", want: PolicyActionDeny},
+		{
+			name: "显式规则优先于旧版开关",
+			set:  &PolicySet{AllowInternet: boolPtr(false), Rules: []PolicyRule{{Priority: 100, Action: PolicyActionAllow, Destination: "1.1.1.1"}}},
+			ip:   "1.1.1.1",
+			want: PolicyActionAllow,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CompilePolicy(nil, tt.set).Evaluate(tt.ip, 0, ""); got != tt.want {
+				t.Errorf("Evaluate(%s) = %s, want %s", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileNftRules(t *testing.T) {
+	tests := []struct {
+		name string
+		set  *PolicySet
+		want []string
+	}{
+		{
+			name: "默认允许",
+			set:  &PolicySet{},
+			want: []string{"add rule inet edge_vpn user_10_10_0_2 counter accept"},
+		},
+		{
+			name: "各类规则",
+			set: &PolicySet{
+				DefaultAction: PolicyActionDeny,
+				Rules: []PolicyRule{
+					{Priority: 30, Action: PolicyActionAllow, Protocol: "icmp"},
+					{Priority: 10, Action: PolicyActionAllow, Destination: "10.1.0.0/16", Protocol: "tcp", Ports: "22,8000-8100"},
+					{Priority: 20, Action: PolicyActionDeny, Destination: "10.2.0.1", Protocol: "udp"},
+					{Priority: 40, Action: PolicyActionAllow, Ports: "53"},
+				},
+			},
+			want: []string{
+				"add rule inet edge_vpn user_10_10_0_2 ip daddr 10.1.0.0/16 tcp dport { 22, 8000-8100 } counter accept",
+				"add rule inet edge_vpn user_10_10_0_2 ip daddr 10.2.0.1/32 meta l4proto udp counter drop",
+				"add rule inet edge_vpn user_10_10_0_2 ip protocol icmp counter accept",
+				"add rule inet edge_vpn user_10_10_0_2 meta l4proto { tcp, udp } th dport { 53 } counter accept",
+				"add rule inet edge_vpn user_10_10_0_2 counter drop",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := strings.Split(strings.TrimSpace(compileNftRules(userChainName("10.10.0.2"), CompilePolicy(nil, tt.set))), "\n")
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
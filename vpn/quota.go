@@ -0,0 +1,89 @@
+package vpn
+
+import (
+	"edge_server/models"
+	"fmt"
+	"log"
+	"time"
+)
+
+const quotaCheckInterval = time.Minute
+
+func recordTrafficUsage(username string, uploadDelta, downloadDelta int64, now time.Time) {
+	if uploadDelta == 0 && downloadDelta == 0 {
+		return
+	}
+	if err := models.AddTrafficUsage(username, now, uploadDelta, downloadDelta); err != nil {
+		log.Printf("记录用户 %s 流量失败: %v", username, err)
+	}
+}
+
+func checkUserQuota(username string, now time.Time) error {
+	usages, err := models.LoadQuotaUsage(now, username)
+	if err != nil {
+		return fmt.Errorf("查询流量配额失败: %v", err)
+	}
+	for _, usage := range usages {
+		if usage.Exceeded {
+			return fmt.Errorf("%s", usage.ExceededReason)
+		}
+	}
+	return nil
+}
+
+// quotaExceededUsers 返回当前周期内流量配额已用尽的用户
+func quotaExceededUsers(now time.Time) map[string]bool {
+	exceeded := make(map[string]bool)
+	usages, err := models.LoadQuotaUsage(now, "")
+	if err != nil {
+		log.Printf("查询流量配额失败: %v", err)
+		return exceeded
+	}
+	for _, usage := range usages {
+		if usage.Exceeded {
+			exceeded[usage.Username] = true
+		}
+	}
+	return exceeded
+}
+
+func onlineUsernames() []string {
+	rows, err := models.DB.Query("SELECT DISTINCT username FROM online_users")
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	var online []string
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err == nil {
+			online = append(online, username)
+		}
+	}
+	return online
+}
+
+// StartQuotaEnforcer 定期检查流量配额：超额时断开在线会话并从 ocpasswd 移除，周期重置后恢复
+func StartQuotaEnforcer() {
+	ticker := time.NewTicker(quotaCheckInterval)
+	go func() {
+		var lastExceeded map[string]bool
+		for now := range ticker.C {
+			exceeded := quotaExceededUsers(now)
+			if lastExceeded == nil || !sameUserSet(exceeded, lastExceeded) {
+				if err := SyncCredentials(); err != nil {
+					log.Printf("按流量配额同步凭据失败: %v", err)
+				}
+			}
+			lastExceeded = exceeded
+
+			for _, username := range onlineUsernames() {
+				if exceeded[username] {
+					log.Printf("用户 %s 流量配额已用尽，断开连接", username)
+					RevokeUserSessions(username, "流量配额已用尽")
+				}
+			}
+		}
+	}()
+}
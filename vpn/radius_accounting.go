@@ -0,0 +1,257 @@
+package vpn
+
+import (
+	"edge_server/auth"
+	"edge_server/models"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const radiusAccountingQueueSize = 256
+
+// acctSession 为正在计费的会话，流量为 occtl 上报的累计值。
+// ProviderID 为用户所属的认证源，LastInterim 记录已向哪些认证源发送过 Start 及最近一次中间更新的时间。
+type acctSession struct {
+	Username    string
+	ProviderID  int
+	SessionID   string
+	RemoteIP    string
+	VirtualIP   string
+	StartedAt   time.Time
+	Upload      int64
+	Download    int64
+	LastInterim map[int]time.Time
+}
+
+type radiusAccounter struct {
+	ID   int
+	Name string
+	auth.Accounter
+}
+
+// covers 判断会话是否需要向该认证源计费
+func (a radiusAccounter) covers(s *acctSession) bool {
+	return a.AccountAllUsers() || s.ProviderID == a.ID
+}
+
+type accountingJob struct {
+	accounter radiusAccounter
+	record    auth.AccountingRecord
+}
+
+// accounterCache 缓存开启计费的认证源，认证源变更后由 InvalidateRADIUSAccounters 标记重新加载
+var accounterCache struct {
+	sync.Mutex
+	loaded     bool
+	accounters []radiusAccounter
+}
+
+// InvalidateRADIUSAccounters 在认证源新增、修改或删除后调用，下次计费时重新读取配置
+func InvalidateRADIUSAccounters() {
+	accounterCache.Lock()
+	accounterCache.loaded = false
+	accounterCache.Unlock()
+}
+
+// radiusAccounters 返回启用且开启了计费的 RADIUS 认证源
+func radiusAccounters() []radiusAccounter {
+	accounterCache.Lock()
+	defer accounterCache.Unlock()
+	if accounterCache.loaded {
+		return accounterCache.accounters
+	}
+
+	providers, err := models.ListAuthProviders()
+	if err != nil {
+		log.Printf("查询认证源失败: %v", err)
+		return nil
+	}
+
+	var accounters []radiusAccounter
+	for i := range providers {
+		p := &providers[i]
+		if !p.Enabled || p.Type != auth.ProviderRADIUS {
+			continue
+		}
+		impl, err := auth.New(p)
+		if err != nil {
+			log.Printf("认证源 %s 配置错误: %v", p.Name, err)
+			continue
+		}
+		if a, ok := impl.(auth.Accounter); ok && a.AccountingEnabled() {
+			accounters = append(accounters, radiusAccounter{ID: p.ID, Name: p.Name, Accounter: a})
+		}
+	}
+	accounterCache.accounters = accounters
+	accounterCache.loaded = true
+	return accounters
+}
+
+// userAuthProviderID 返回用户的认证源，本地用户或查询失败时为 0
+func userAuthProviderID(username string) int {
+	var id int
+	models.DB.QueryRow("SELECT COALESCE(auth_provider_id, 0) FROM users WHERE username=?", username).Scan(&id)
+	return id
+}
+
+// loadAccountingSession 从在线用户表恢复会话，用于服务重启前已上线的用户
+func loadAccountingSession(ocservID int) *acctSession {
+	s := &acctSession{LastInterim: make(map[int]time.Time)}
+	var connectedAt *time.Time
+	err := models.DB.QueryRow(`
+		SELECT username, COALESCE(session_id, ''), COALESCE(remote_ip, ''), COALESCE(virtual_ip, ''), connected_at
+		FROM online_users WHERE ocserv_id=?
+	`, ocservID).Scan(&s.Username, &s.SessionID, &s.RemoteIP, &s.VirtualIP, &connectedAt)
+	if err != nil {
+		return nil
+	}
+	s.StartedAt = time.Now()
+	if connectedAt != nil {
+		s.StartedAt = *connectedAt
+	}
+	if s.SessionID == "" {
+		s.SessionID = strconv.Itoa(ocservID)
+	}
+	s.ProviderID = userAuthProviderID(s.Username)
+	return s
+}
+
+// terminateCause 按断开原因确定 Acct-Terminate-Cause。
+// 后台操作和各项策略断开时原因为中文，ocserv 日志中的原因为英文，如 user disconnected、DPD timeout。
+func terminateCause(reason string) string {
+	lower := strings.ToLower(reason)
+	switch {
+	case reason == defaultDisconnectReason || reason == "unknown" || strings.Contains(lower, "user disconnected"):
+		return auth.TerminateUserRequest
+	case reason == "空闲超时" || strings.Contains(lower, "idle"):
+		return auth.TerminateIdleTimeout
+	case strings.Contains(lower, "session timeout"):
+		return auth.TerminateSessionTimeout
+	case strings.Contains(lower, "timeout"):
+		return auth.TerminateLostCarrier
+	}
+	return auth.TerminateAdminReset
+}
+
+func (s *acctSession) record(status string, now time.Time) auth.AccountingRecord {
+	return auth.AccountingRecord{
+		Status:       status,
+		SessionID:    s.SessionID,
+		Username:     s.Username,
+		RemoteIP:     s.RemoteIP,
+		VirtualIP:    s.VirtualIP,
+		SessionTime:  now.Sub(s.StartedAt),
+		InputOctets:  s.Upload,
+		OutputOctets: s.Download,
+	}
+}
+
+// StartRADIUSAccounting 根据上线、流量和下线事件向开启计费的 RADIUS 认证源发送计费请求。
+// 请求由单独的协程按顺序发送，RADIUS 服务器无响应时不会阻塞事件处理。
+func StartRADIUSAccounting() {
+	jobs := make(chan accountingJob, radiusAccountingQueueSize)
+	go func() {
+		for job := range jobs {
+			if err := job.accounter.Account(&job.record); err != nil {
+				log.Printf("发送 RADIUS 计费请求失败 [%s] %s %s: %v", job.accounter.Name, job.record.Status, job.record.Username, err)
+			}
+		}
+	}()
+
+	enqueue := func(accounter radiusAccounter, record auth.AccountingRecord) {
+		select {
+		case jobs <- accountingJob{accounter: accounter, record: record}:
+		default:
+			log.Printf("RADIUS 计费队列已满，丢弃 %s 的 %s 请求", record.Username, record.Status)
+		}
+	}
+
+	sessions := make(map[int]*acctSession)
+	_, events := Events.Subscribe(256)
+	go func() {
+		for event := range events {
+			now := time.Now()
+			switch event.Type {
+			case EventOnlineUserAdded:
+				s := &acctSession{
+					Username:    event.Username,
+					ProviderID:  userAuthProviderID(event.Username),
+					SessionID:   event.SessionID,
+					RemoteIP:    event.RemoteIP,
+					VirtualIP:   event.VirtualIP,
+					StartedAt:   now,
+					LastInterim: make(map[int]time.Time),
+				}
+				if s.SessionID == "" {
+					s.SessionID = strconv.Itoa(event.OCServID)
+				}
+				sessions[event.OCServID] = s
+				for _, a := range radiusAccounters() {
+					if !a.covers(s) {
+						continue
+					}
+					s.LastInterim[a.ID] = now
+					enqueue(a, s.record(auth.AccountingStart, now))
+				}
+
+			case EventTrafficUpdate:
+				for _, t := range event.Traffic {
+					s, exists := sessions[t.OCServID]
+					if !exists {
+						if s = loadAccountingSession(t.OCServID); s == nil {
+							continue
+						}
+						sessions[t.OCServID] = s
+					}
+					s.Upload, s.Download = t.TotalUpload, t.TotalDownload
+				}
+				if len(sessions) == 0 {
+					continue
+				}
+				accounters := radiusAccounters()
+				for _, s := range sessions {
+					for _, a := range accounters {
+						if !a.covers(s) {
+							continue
+						}
+						// 服务重启后恢复的会话或之后才开启计费的认证源，先补发 Start
+						last, exists := s.LastInterim[a.ID]
+						if !exists {
+							s.LastInterim[a.ID] = now
+							enqueue(a, s.record(auth.AccountingStart, now))
+							continue
+						}
+						if now.Sub(last) < a.InterimInterval() {
+							continue
+						}
+						s.LastInterim[a.ID] = now
+						enqueue(a, s.record(auth.AccountingInterim, now))
+					}
+				}
+
+			case EventOnlineUserRemoved:
+				s, exists := sessions[event.OCServID]
+				if !exists {
+					continue
+				}
+				delete(sessions, event.OCServID)
+				if event.BytesRX > s.Upload {
+					s.Upload = event.BytesRX
+				}
+				if event.BytesTX > s.Download {
+					s.Download = event.BytesTX
+				}
+				record := s.record(auth.AccountingStop, now)
+				record.TerminateCause = terminateCause(event.Reason)
+				for _, a := range radiusAccounters() {
+					if _, started := s.LastInterim[a.ID]; started && a.covers(s) {
+						enqueue(a, record)
+					}
+				}
+			}
+		}
+	}()
+}
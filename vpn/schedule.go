@@ -0,0 +1,102 @@
+package vpn
+
+import (
+	"database/sql"
+	"edge_server/models"
+	"fmt"
+	"log"
+	"time"
+)
+
+const scheduleCheckInterval = time.Minute
+
+func checkUserSchedule(username string, now time.Time) error {
+	schedule, err := models.GetEffectiveSchedule(username)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		// 无法确认时间策略时拒绝登录
+		return fmt.Errorf("查询时间策略失败: %v", err)
+	}
+	if schedule == nil {
+		return nil
+	}
+	if !schedule.Allows(now) {
+		return fmt.Errorf("当前不在允许的访问时段 (%s)", schedule.Name)
+	}
+	return nil
+}
+
+// scheduleBlockedUsers 返回当前处于访问时段之外的用户
+func scheduleBlockedUsers(now time.Time) map[string]bool {
+	blocked := make(map[string]bool)
+
+	schedules, err := models.ListSchedules()
+	if err != nil || len(schedules) == 0 {
+		return blocked
+	}
+	closed := make(map[int]bool)
+	for i := range schedules {
+		if !schedules[i].Allows(now) {
+			closed[schedules[i].ID] = true
+		}
+	}
+	if len(closed) == 0 {
+		return blocked
+	}
+
+	rows, err := models.DB.Query(`
+		SELECT u.username, CASE WHEN COALESCE(u.schedule_id, 0) > 0 THEN u.schedule_id ELSE COALESCE(g.schedule_id, 0) END
+		FROM users u LEFT JOIN user_groups g ON g.id = u.group_id
+	`)
+	if err != nil {
+		return blocked
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var username string
+		var scheduleID int
+		if err := rows.Scan(&username, &scheduleID); err == nil && closed[scheduleID] {
+			blocked[username] = true
+		}
+	}
+	return blocked
+}
+
+func sameUserSet(a, b map[string]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for username := range a {
+		if !b[username] {
+			return false
+		}
+	}
+	return true
+}
+
+// StartScheduleEnforcer 定期检查访问时段：时段变化时刷新 ocpasswd，时段结束时断开在线会话
+func StartScheduleEnforcer() {
+	ticker := time.NewTicker(scheduleCheckInterval)
+	go func() {
+		var lastBlocked map[string]bool
+		for now := range ticker.C {
+			blocked := scheduleBlockedUsers(now)
+			if lastBlocked == nil || !sameUserSet(blocked, lastBlocked) {
+				if err := SyncCredentials(); err != nil {
+					log.Printf("按访问时段同步凭据失败: %v", err)
+				}
+			}
+			lastBlocked = blocked
+
+			for _, username := range onlineUsernames() {
+				if blocked[username] {
+					log.Printf("用户 %s 访问时段已结束，断开连接", username)
+					RevokeUserSessions(username, "访问时段已结束")
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,58 @@
+package vpn
+
+import (
+	"edge_server/models"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestCheckUserSchedule(t *testing.T) {
+	if err := models.InitDB(filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	defer models.DB.Close()
+
+	office := &models.AccessSchedule{
+		Name:     "工作时间",
+		Timezone: "UTC",
+		Windows:  []models.ScheduleWindow{{Days: []int{1, 2, 3, 4, 5}, Start: "09:00", End: "18:00"}},
+	}
+	if err := models.SaveSchedule(office); err != nil {
+		t.Fatalf("SaveSchedule: %v", err)
+	}
+	if _, err := models.DB.Exec(`
+		INSERT INTO users (username, password, schedule_id) VALUES ('free', 'x', 0), ('office', 'x', ?)
+	`, office.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// 2026-10-19 为周一
+	monday := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		username string
+		t        time.Time
+		wantErr  bool
+	}{
+		{name: "无时间策略", username: "free", t: monday},
+		{name: "用户不存在", username: "nobody", t: monday},
+		{name: "时段内", username: "office", t: monday},
+		{name: "时段外", username: "office", t: monday.Add(10 * time.Hour), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkUserSchedule(tt.username, tt.t); (err != nil) != tt.wantErr {
+				t.Errorf("checkUserSchedule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// 数据库异常时无法确认时间策略，必须拒绝
+	models.DB.Close()
+	if err := checkUserSchedule("free", monday); err == nil {
+		t.Error("checkUserSchedule() allowed login on database error")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"edge_server/models"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -22,6 +23,7 @@ type VPNSession struct {
 var (
 	sessions = make(map[string]*VPNSession)
 	sessionsMu sync.RWMutex
+	sessionIdleTimeout atomic.Int64
 )
 
 func AddSession(username, virtualIP, remoteIP string, groupID int) {
@@ -48,6 +50,22 @@ func RemoveSession(username string) {
 	}
 }
 
+func ReleaseIPIfOffline(username string) {
+	var remaining int
+	models.DB.QueryRow("SELECT COUNT(*) FROM online_users WHERE username=?", username).Scan(&remaining)
+	if remaining > 0 {
+		return
+	}
+
+	sessionsMu.Lock()
+	delete(sessions, username)
+	sessionsMu.Unlock()
+
+	var groupID int
+	models.DB.QueryRow("SELECT group_id FROM users WHERE username=?", username).Scan(&groupID)
+	models.ReleaseIP(username, groupID)
+}
+
 func GetSession(username string) (*VPNSession, bool) {
 	sessionsMu.RLock()
 	defer sessionsMu.RUnlock()
@@ -66,34 +84,40 @@ func UpdateSessionActivity(username string, uploadBytes, downloadBytes int64) {
 	}
 	
 	session.mu.Lock()
-	session.LastActivity = time.Now()
+	if uploadBytes > 0 || downloadBytes > 0 {
+		session.LastActivity = time.Now()
+	}
 	session.TotalUpload += uploadBytes
 	session.TotalDownload += downloadBytes
 	session.mu.Unlock()
-	
-	models.DB.Exec(`
-		UPDATE online_users 
-		SET total_upload = ?, total_download = ?, upload_speed = ?, download_speed = ?
-		WHERE username = ?
-	`, session.TotalUpload, session.TotalDownload, uploadBytes, downloadBytes, username)
+}
+
+func SetIdleTimeout(idleTimeout int) {
+	if idleTimeout > 0 {
+		sessionIdleTimeout.Store(int64(idleTimeout))
+	}
 }
 
 func StartSessionCleanup(idleTimeout int) {
+	SetIdleTimeout(idleTimeout)
 	ticker := time.NewTicker(30 * time.Second)
 	go func() {
 		for range ticker.C {
 			sessionsMu.Lock()
 			now := time.Now()
+			idleTimeout := int(sessionIdleTimeout.Load())
 			for username, session := range sessions {
 				session.mu.Lock()
 				idle := now.Sub(session.LastActivity).Seconds()
 				session.mu.Unlock()
 				
-				if idle > float64(idleTimeout) {
+				if idle > float64(idleTimeoutFor(username, idleTimeout)) {
 					log.Printf("会话超时，断开用户: %s (空闲 %.0f 秒)", username, idle)
 					
-					models.DB.Exec("DELETE FROM online_users WHERE username=?", username)
-					models.ReleaseIP(username, session.GroupID)
+					MarkDisconnectReason(username, "空闲超时")
+					if err := DisconnectUserByOCCtl(username); err != nil {
+						log.Printf("断开超时用户失败 %s: %v", username, err)
+					}
 					
 					delete(sessions, username)
 				}
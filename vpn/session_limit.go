@@ -0,0 +1,176 @@
+package vpn
+
+import (
+	"database/sql"
+	"edge_server/models"
+	"fmt"
+	"log"
+	"sync"
+)
+
+const (
+	SessionPolicyReject     = "reject"
+	SessionPolicyKickOldest = "kick_oldest"
+
+	defaultMaxSameClients = 2
+)
+
+// SessionLimit 为用户生效的并发会话限制，Max 为 0 表示不限制
+type SessionLimit struct {
+	Max    int    `json:"max_sessions"`
+	Policy string `json:"session_limit_policy"`
+}
+
+var (
+	sessionKickMu  sync.Mutex
+	kickedSessions = make(map[int]bool)
+)
+
+func ValidateSessionLimit(maxSessions int, policy string) error {
+	if maxSessions < 0 {
+		return fmt.Errorf("最大并发会话数不能为负数")
+	}
+	switch policy {
+	case "", SessionPolicyReject, SessionPolicyKickOldest:
+		return nil
+	}
+	return fmt.Errorf("未知的超限策略: %s", policy)
+}
+
+// LoadSessionLimit 用户设置优先于用户组，均未设置时使用系统配置 max_same_clients
+func LoadSessionLimit(username string) (SessionLimit, error) {
+	limit := SessionLimit{
+		Max:    models.GetConfigInt("max_same_clients", defaultMaxSameClients),
+		Policy: SessionPolicyReject,
+	}
+
+	var userMax, groupMax int
+	var userPolicy, groupPolicy string
+	err := models.DB.QueryRow(`
+		SELECT COALESCE(u.max_sessions, 0), COALESCE(u.session_limit_policy, ''),
+		       COALESCE(g.max_sessions, 0), COALESCE(g.session_limit_policy, '')
+		FROM users u LEFT JOIN user_groups g ON g.id = u.group_id
+		WHERE u.username=?
+	`, username).Scan(&userMax, &userPolicy, &groupMax, &groupPolicy)
+	if err == sql.ErrNoRows {
+		return limit, nil
+	}
+	if err != nil {
+		return limit, err
+	}
+
+	if groupMax > 0 {
+		limit.Max = groupMax
+	}
+	if userMax > 0 {
+		limit.Max = userMax
+	}
+	if groupPolicy != "" {
+		limit.Policy = groupPolicy
+	}
+	if userPolicy != "" {
+		limit.Policy = userPolicy
+	}
+	return limit, nil
+}
+
+// onlineSessionIDs 返回用户尚未被踢出的在线会话，按连接时间从早到晚排列
+func onlineSessionIDs(username string) ([]int, error) {
+	rows, err := models.DB.Query(`
+		SELECT COALESCE(ocserv_id, 0) FROM online_users WHERE username=? ORDER BY connected_at, id
+	`, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessionKickMu.Lock()
+	defer sessionKickMu.Unlock()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err == nil && id > 0 && !kickedSessions[id] {
+			ids = append(ids, id)
+		}
+	}
+	return ids, rows.Err()
+}
+
+// checkSessionLimit 在认证阶段拒绝超出并发限制的登录，踢出最早会话的策略在连接建立后处理
+func checkSessionLimit(username string) error {
+	limit, err := LoadSessionLimit(username)
+	if err != nil {
+		return fmt.Errorf("查询并发会话限制失败: %v", err)
+	}
+	if limit.Max <= 0 || limit.Policy != SessionPolicyReject {
+		return nil
+	}
+
+	ids, err := onlineSessionIDs(username)
+	if err != nil {
+		return fmt.Errorf("查询在线会话失败: %v", err)
+	}
+	if len(ids) >= limit.Max {
+		return fmt.Errorf("已达到最大同时在线数 (%d)", limit.Max)
+	}
+	return nil
+}
+
+// enforceSessionLimit 在新会话上线后按策略断开超出限制的会话
+func enforceSessionLimit(username string) {
+	limit, err := LoadSessionLimit(username)
+	if err != nil {
+		log.Printf("查询用户 %s 并发会话限制失败: %v", username, err)
+		return
+	}
+	if limit.Max <= 0 {
+		return
+	}
+
+	ids, err := onlineSessionIDs(username)
+	if err != nil || len(ids) <= limit.Max {
+		return
+	}
+
+	excess := len(ids) - limit.Max
+	victims := ids[len(ids)-excess:]
+	reason := fmt.Sprintf("超出最大同时在线数 (%d)", limit.Max)
+	if limit.Policy == SessionPolicyKickOldest {
+		victims = ids[:excess]
+		reason = fmt.Sprintf("超出最大同时在线数 (%d)，已被新登录踢下线", limit.Max)
+	}
+
+	for _, id := range victims {
+		sessionKickMu.Lock()
+		kickedSessions[id] = true
+		sessionKickMu.Unlock()
+
+		MarkDisconnectReason(username, reason)
+		if err := DisconnectSessionByOCCtl(id); err != nil {
+			sessionKickMu.Lock()
+			delete(kickedSessions, id)
+			sessionKickMu.Unlock()
+			log.Printf("断开用户 %s 的会话 [%d] 失败: %v", username, id, err)
+			continue
+		}
+		log.Printf("已断开用户 %s 的会话 [%d]: %s", username, id, reason)
+	}
+}
+
+// StartSessionLimiter 监听上线事件并执行并发会话限制
+func StartSessionLimiter() {
+	_, events := Events.Subscribe(256)
+	go func() {
+		for event := range events {
+			switch event.Type {
+			case EventOnlineUserAdded:
+				enforceSessionLimit(event.Username)
+			case EventOnlineUserRemoved:
+				sessionKickMu.Lock()
+				delete(kickedSessions, event.OCServID)
+				sessionKickMu.Unlock()
+			}
+		}
+	}()
+}
@@ -0,0 +1,151 @@
+package vpn
+
+import (
+	"log"
+	"os/exec"
+	"time"
+)
+
+const (
+	minRestartBackoff = time.Second
+	maxRestartBackoff = 2 * time.Minute
+	stableRunDuration = time.Minute
+	stopTimeout       = 10 * time.Second
+	stderrTailLines   = 50
+)
+
+type supervisorState struct {
+	wantRunning    bool
+	startedAt      time.Time
+	restarts       int
+	crashes        int
+	lastExitReason string
+	lastExitAt     time.Time
+	backoff        time.Duration
+	nextRestartAt  time.Time
+	restartTimer   *time.Timer
+	stderrTail     []string
+}
+
+type OCServStatus struct {
+	Running        bool                   `json:"running"`
+	Supervised     bool                   `json:"supervised"`
+	PID            int                    `json:"pid,omitempty"`
+	StartedAt      time.Time              `json:"started_at,omitempty"`
+	Uptime         int64                  `json:"uptime"`
+	Restarts       int                    `json:"restarts"`
+	Crashes        int                    `json:"crashes"`
+	LastExitReason string                 `json:"last_exit_reason,omitempty"`
+	LastExitAt     time.Time              `json:"last_exit_at,omitempty"`
+	NextRestartAt  time.Time              `json:"next_restart_at,omitempty"`
+	StderrTail     []string               `json:"stderr_tail"`
+	OCCtl          map[string]interface{} `json:"occtl,omitempty"`
+}
+
+// watch 等待 ocserv 进程退出，非主动停止时按指数退避自动重启
+func (s *OCServServer) watch(cmd *exec.Cmd, done chan struct{}) {
+	err := cmd.Wait()
+
+	s.mu.Lock()
+	s.running = false
+	reason := "进程正常退出"
+	if err != nil {
+		reason = err.Error()
+	}
+	s.supervisor.lastExitReason = reason
+	s.supervisor.lastExitAt = time.Now()
+
+	if s.supervisor.wantRunning {
+		s.supervisor.crashes++
+		if time.Since(s.supervisor.startedAt) >= stableRunDuration {
+			s.supervisor.backoff = 0
+		}
+		s.scheduleRestart()
+		log.Printf("ocserv 进程异常退出: %s，%v 后重启", reason, s.supervisor.backoff)
+	} else {
+		log.Printf("ocserv 进程已停止: %s", reason)
+	}
+	s.mu.Unlock()
+
+	close(done)
+}
+
+func (s *OCServServer) scheduleRestart() {
+	if s.supervisor.backoff == 0 {
+		s.supervisor.backoff = minRestartBackoff
+	} else {
+		s.supervisor.backoff *= 2
+		if s.supervisor.backoff > maxRestartBackoff {
+			s.supervisor.backoff = maxRestartBackoff
+		}
+	}
+
+	s.supervisor.nextRestartAt = time.Now().Add(s.supervisor.backoff)
+	s.supervisor.restartTimer = time.AfterFunc(s.supervisor.backoff, s.autoRestart)
+}
+
+func (s *OCServServer) cancelPendingRestart() {
+	if s.supervisor.restartTimer != nil {
+		s.supervisor.restartTimer.Stop()
+		s.supervisor.restartTimer = nil
+	}
+	s.supervisor.nextRestartAt = time.Time{}
+}
+
+func (s *OCServServer) autoRestart() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.supervisor.restartTimer = nil
+	s.supervisor.nextRestartAt = time.Time{}
+	if !s.supervisor.wantRunning || s.running {
+		return
+	}
+
+	s.supervisor.restarts++
+	if err := s.startProcess(); err != nil {
+		s.supervisor.crashes++
+		s.supervisor.lastExitReason = "重启失败: " + err.Error()
+		s.supervisor.lastExitAt = time.Now()
+		s.scheduleRestart()
+		log.Printf("ocserv 自动重启失败: %v，%v 后重试", err, s.supervisor.backoff)
+	}
+}
+
+func (s *OCServServer) recordStderr(line string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.supervisor.stderrTail = append(s.supervisor.stderrTail, line)
+	if len(s.supervisor.stderrTail) > stderrTailLines {
+		s.supervisor.stderrTail = s.supervisor.stderrTail[len(s.supervisor.stderrTail)-stderrTailLines:]
+	}
+}
+
+func (s *OCServServer) Status() OCServStatus {
+	s.mu.Lock()
+	status := OCServStatus{
+		Running:        s.running,
+		Supervised:     s.supervisor.wantRunning,
+		Restarts:       s.supervisor.restarts,
+		Crashes:        s.supervisor.crashes,
+		LastExitReason: s.supervisor.lastExitReason,
+		LastExitAt:     s.supervisor.lastExitAt,
+		NextRestartAt:  s.supervisor.nextRestartAt,
+		StderrTail:     append([]string{}, s.supervisor.stderrTail...),
+	}
+	if s.running {
+		status.StartedAt = s.supervisor.startedAt
+		status.Uptime = int64(time.Since(s.supervisor.startedAt).Seconds())
+		if s.cmd != nil && s.cmd.Process != nil {
+			status.PID = s.cmd.Process.Pid
+		}
+	}
+	s.mu.Unlock()
+
+	if status.Running {
+		status.OCCtl = GetOCServStatus()
+	}
+
+	return status
+}
@@ -0,0 +1,73 @@
+package vpn
+
+import (
+	"sync"
+	"time"
+)
+
+type trafficSample struct {
+	RX            int64
+	TX            int64
+	At            time.Time
+	UploadSpeed   int64
+	DownloadSpeed int64
+}
+
+var (
+	trafficSamples = make(map[int]*trafficSample)
+	trafficMu      sync.Mutex
+)
+
+const minSampleInterval = time.Second
+
+// sampleTraffic 根据 occtl 的累计流量计算速率和本次增量，会话的首次采样按累计值计入增量
+func sampleTraffic(ocservID int, rx, tx int64, now time.Time) (uploadSpeed, downloadSpeed, uploadDelta, downloadDelta int64) {
+	trafficMu.Lock()
+	defer trafficMu.Unlock()
+
+	last, exists := trafficSamples[ocservID]
+	if !exists {
+		trafficSamples[ocservID] = &trafficSample{RX: rx, TX: tx, At: now}
+		return 0, 0, rx, tx
+	}
+
+	elapsed := now.Sub(last.At)
+	if elapsed < minSampleInterval {
+		return last.UploadSpeed, last.DownloadSpeed, 0, 0
+	}
+
+	if rx >= last.RX {
+		uploadDelta = rx - last.RX
+	}
+	if tx >= last.TX {
+		downloadDelta = tx - last.TX
+	}
+
+	seconds := elapsed.Seconds()
+	last.UploadSpeed = int64(float64(uploadDelta) / seconds)
+	last.DownloadSpeed = int64(float64(downloadDelta) / seconds)
+	last.RX, last.TX, last.At = rx, tx, now
+
+	return last.UploadSpeed, last.DownloadSpeed, uploadDelta, downloadDelta
+}
+
+// seedTrafficSample 以已记录的累计值作为服务启动前已在线会话的上次采样，避免重复计入配额
+func seedTrafficSample(ocservID int, rx, tx int64, now time.Time) {
+	trafficMu.Lock()
+	if _, exists := trafficSamples[ocservID]; !exists {
+		trafficSamples[ocservID] = &trafficSample{RX: rx, TX: tx, At: now}
+	}
+	trafficMu.Unlock()
+}
+
+// takeTrafficSample 移除并返回会话最后一次采样的累计值
+func takeTrafficSample(ocservID int) (rx, tx int64) {
+	trafficMu.Lock()
+	defer trafficMu.Unlock()
+
+	if last, exists := trafficSamples[ocservID]; exists {
+		rx, tx = last.RX, last.TX
+		delete(trafficSamples, ocservID)
+	}
+	return rx, tx
+}
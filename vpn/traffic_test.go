@@ -0,0 +1,76 @@
+package vpn
+
+import (
+	"edge_server/models"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestSampleTraffic(t *testing.T) {
+	start := time.Now()
+	defer takeTrafficSample(9001)
+
+	tests := []struct {
+		name          string
+		rx, tx        int64
+		at            time.Duration
+		upload        int64
+		download      int64
+		uploadSpeed   int64
+		downloadSpeed int64
+	}{
+		{name: "首次采样计入累计值", rx: 1000, tx: 5000, upload: 1000, download: 5000},
+		{name: "间隔过短", rx: 1500, tx: 5000, at: 500 * time.Millisecond},
+		{name: "正常增量", rx: 3000, tx: 9000, at: 2 * time.Second, upload: 2000, download: 4000, uploadSpeed: 1000, downloadSpeed: 2000},
+		{name: "计数回退", rx: 100, tx: 9500, at: 3 * time.Second, download: 500, downloadSpeed: 500},
+	}
+	for _, tt := range tests {
+		uploadSpeed, downloadSpeed, upload, download := sampleTraffic(9001, tt.rx, tt.tx, start.Add(tt.at))
+		if upload != tt.upload || download != tt.download || uploadSpeed != tt.uploadSpeed || downloadSpeed != tt.downloadSpeed {
+			t.Errorf("%s: got speed %d/%d delta %d/%d, want speed %d/%d delta %d/%d", tt.name,
+				uploadSpeed, downloadSpeed, upload, download, tt.uploadSpeed, tt.downloadSpeed, tt.upload, tt.download)
+		}
+	}
+
+	// 服务启动前已在线的会话从已记录的累计值继续
+	seedTrafficSample(9002, 4000, 8000, start)
+	defer takeTrafficSample(9002)
+	if _, _, upload, download := sampleTraffic(9002, 4500, 8000, start.Add(2*time.Second)); upload != 500 || download != 0 {
+		t.Errorf("restored session delta = %d/%d, want 500/0", upload, download)
+	}
+}
+
+func TestCloseOnlineSessionFlushesTraffic(t *testing.T) {
+	if err := models.InitDB(filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	defer models.DB.Close()
+
+	if _, err := models.DB.Exec("INSERT INTO users (username, password) VALUES ('alice', 'x')"); err != nil {
+		t.Fatal(err)
+	}
+	result, err := models.DB.Exec("INSERT INTO online_users (ocserv_id, username, remote_ip) VALUES (42, 'alice', '203.0.113.5')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	onlineID, _ := result.LastInsertId()
+
+	now := time.Now()
+	_, _, upload, download := sampleTraffic(42, 1000, 2000, now)
+	recordTrafficUsage("alice", upload, download, now)
+
+	// 断开日志中的最终计数多于最后一次采样
+	recordClosedEvent(Event{Username: "alice", RemoteIP: "203.0.113.5", Reason: "user disconnected", BytesRX: 1300, BytesTX: 2600})
+	CloseOnlineSession(int(onlineID), 42, "alice", "203.0.113.5")
+
+	usages, err := models.LoadQuotaUsage(now, "alice")
+	if err != nil || len(usages) != 1 {
+		t.Fatalf("LoadQuotaUsage = %+v, %v", usages, err)
+	}
+	if usages[0].MonthlyUsed != 3900 {
+		t.Errorf("usage = %d, want 3900", usages[0].MonthlyUsed)
+	}
+}
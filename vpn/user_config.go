@@ -0,0 +1,231 @@
+package vpn
+
+import (
+	"edge_server/models"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// UserPolicies 为 users.custom_policies 中保存的 JSON，覆盖所属用户组的对应设置
+type UserPolicies struct {
+	DNS           []string `json:"dns,omitempty"`
+	NoRoutes      []string `json:"no_routes,omitempty"`
+	StaticIP      string   `json:"static_ip,omitempty"`
+	UploadLimit   int64    `json:"upload_limit,omitempty"`
+	DownloadLimit int64    `json:"download_limit,omitempty"`
+	IdleTimeout   int      `json:"idle_timeout,omitempty"`
+	PolicySet
+}
+
+type userConfigSource struct {
+	Username       string
+	GroupID        int
+	CustomRoutes   string
+	CustomPolicies string
+	UploadLimit    int64
+	DownloadLimit  int64
+}
+
+var (
+	userConfigMu     sync.Mutex
+	ocservConfigDir  string
+	ocservAuthMode   string
+	userIdleTimeouts = make(map[string]int)
+)
+
+func ParseUserPolicies(value string) (*UserPolicies, error) {
+	policies := &UserPolicies{}
+	if strings.TrimSpace(value) == "" {
+		return policies, nil
+	}
+	if err := json.Unmarshal([]byte(value), policies); err != nil {
+		return nil, fmt.Errorf("自定义策略必须为JSON对象: %v", err)
+	}
+	return policies, nil
+}
+
+func ValidateUserOverrides(customRoutes, customPolicies string) error {
+	for _, route := range SplitList(customRoutes) {
+		if route == "default" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(route); err != nil {
+			return fmt.Errorf("自定义路由格式错误: %s", route)
+		}
+	}
+
+	policies, err := ParseUserPolicies(customPolicies)
+	if err != nil {
+		return err
+	}
+	for _, dns := range policies.DNS {
+		if net.ParseIP(dns) == nil {
+			return fmt.Errorf("DNS地址格式错误: %s", dns)
+		}
+	}
+	for _, route := range policies.NoRoutes {
+		if _, _, err := net.ParseCIDR(route); err != nil {
+			return fmt.Errorf("排除路由格式错误: %s", route)
+		}
+	}
+	if policies.StaticIP != "" && net.ParseIP(policies.StaticIP).To4() == nil {
+		return fmt.Errorf("静态IP格式错误: %s", policies.StaticIP)
+	}
+	if policies.UploadLimit < 0 || policies.DownloadLimit < 0 || policies.IdleTimeout < 0 {
+		return fmt.Errorf("带宽限制和空闲超时不能为负数")
+	}
+	if err := ValidatePolicySet(&policies.PolicySet); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func safeConfigFileName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\\x00")
+}
+
+func (u *userConfigSource) hasOverrides() bool {
+	return strings.TrimSpace(u.CustomRoutes) != "" || strings.TrimSpace(u.CustomPolicies) != "" ||
+		u.UploadLimit > 0 || u.DownloadLimit > 0
+}
+
+func buildUserSessionConfig(u *userConfigSource, group *GroupConfig) SessionConfig {
+	cfg := SessionConfig{SplitTunnel: true}
+	if group != nil {
+		cfg = group.SessionConfig
+		cfg.Routes = append([]string(nil), group.Routes...)
+		cfg.NoRoutes = append([]string(nil), group.NoRoutes...)
+	}
+
+	for _, route := range SplitList(u.CustomRoutes) {
+		if route == "default" {
+			cfg.SplitTunnel = false
+			continue
+		}
+		cfg.Routes = append(cfg.Routes, route)
+	}
+
+	policies, err := ParseUserPolicies(u.CustomPolicies)
+	if err != nil {
+		log.Printf("忽略用户 %s 的自定义策略: %v", u.Username, err)
+		policies = &UserPolicies{}
+	}
+
+	if len(policies.DNS) > 0 {
+		cfg.DNS = policies.DNS
+	}
+	cfg.NoRoutes = append(cfg.NoRoutes, policies.NoRoutes...)
+	if policies.StaticIP != "" {
+		cfg.StaticIP = policies.StaticIP
+	}
+	if policies.UploadLimit > 0 {
+		cfg.UploadLimit = policies.UploadLimit
+	}
+	if policies.DownloadLimit > 0 {
+		cfg.DownloadLimit = policies.DownloadLimit
+	}
+	if policies.IdleTimeout > 0 {
+		cfg.IdleTimeout = policies.IdleTimeout
+	}
+
+	// 用户字段中的带宽限制优先于自定义策略 JSON 中的同名设置
+	if u.UploadLimit > 0 {
+		cfg.UploadLimit = u.UploadLimit
+	}
+	if u.DownloadLimit > 0 {
+		cfg.DownloadLimit = u.DownloadLimit
+	}
+
+	return cfg
+}
+
+func loadUserConfigSources() ([]userConfigSource, error) {
+	rows, err := models.DB.Query(`
+		SELECT username, COALESCE(group_id, 0), COALESCE(custom_routes, ''), COALESCE(custom_policies, ''),
+		       COALESCE(upload_limit, 0), COALESCE(download_limit, 0)
+		FROM users WHERE enabled=1
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []userConfigSource
+	for rows.Next() {
+		var u userConfigSource
+		if err := rows.Scan(&u.Username, &u.GroupID, &u.CustomRoutes, &u.CustomPolicies, &u.UploadLimit, &u.DownloadLimit); err != nil {
+			continue
+		}
+		users = append(users, u)
+	}
+
+	return users, nil
+}
+
+// GenerateUserConfigs 为有自定义设置的用户生成 config-per-user 文件，内容为用户组设置叠加用户设置。
+// PAM 模式下 ocserv 无法获知用户组，所有有用户组的用户都会生成文件。
+// 返回已生成文件的用户名，这些用户在 ocpasswd 中不再指定用户组，避免组配置重复生效。
+func GenerateUserConfigs(configDir, authMode string, groups map[int]*GroupConfig) (map[string]bool, error) {
+	userConfigMu.Lock()
+	defer userConfigMu.Unlock()
+
+	dir := filepath.Join(configDir, userConfigDirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("创建用户配置目录失败: %v", err)
+	}
+
+	users, err := loadUserConfigSources()
+	if err != nil {
+		return nil, err
+	}
+
+	written := make(map[string]bool)
+	idleTimeouts := make(map[string]int)
+	for i := range users {
+		u := &users[i]
+		group := groups[u.GroupID]
+		if !u.hasOverrides() && (authMode != AuthModePAM || group == nil) {
+			continue
+		}
+		if !safeConfigFileName(u.Username) {
+			log.Printf("用户名 %s 不能作为配置文件名，跳过", u.Username)
+			continue
+		}
+
+		cfg := buildUserSessionConfig(u, group)
+		content := renderSessionConfig(fmt.Sprintf("用户: %s", u.Username), &cfg)
+		if err := writeFileAtomic(filepath.Join(dir, u.Username), []byte(content), 0644); err != nil {
+			return nil, fmt.Errorf("写入用户配置 %s 失败: %v", u.Username, err)
+		}
+		written[u.Username] = true
+		if cfg.IdleTimeout > 0 {
+			idleTimeouts[u.Username] = cfg.IdleTimeout
+		}
+	}
+
+	ocservConfigDir = configDir
+	ocservAuthMode = authMode
+	userIdleTimeouts = idleTimeouts
+
+	if err := removeUnexpectedFiles(dir, written); err != nil {
+		return nil, err
+	}
+	return written, nil
+}
+
+func idleTimeoutFor(username string, defaultTimeout int) int {
+	userConfigMu.Lock()
+	defer userConfigMu.Unlock()
+
+	if timeout, exists := userIdleTimeouts[username]; exists {
+		return timeout
+	}
+	return defaultTimeout
+}